@@ -9,6 +9,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -64,6 +65,8 @@ var siteFS embed.FS
 type buildMsg struct {
 	stats map[string]int
 	time  time.Time
+	// errs are the errors of the sources still failing after the build.
+	errs []*sitegen.BuildError
 }
 type fileMsg struct {
 	path   string
@@ -73,6 +76,12 @@ type statusMsg string
 
 type errMsg string
 
+// buildErrMsg reports a failed build with its errors located in the site.
+type buildErrMsg struct {
+	title string
+	errs  []*sitegen.BuildError
+}
+
 type shareMsg string
 type shareErrMsg string
 
@@ -129,9 +138,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.sg.Mu.Lock()
 				m.sg.ClearCache()
 				stats, err := m.sg.BuildAll(true)
+				m.srv.SetRedirects(m.sg.Redirects())
+				m.srv.SetErrors(m.sg.Errors())
 				m.sg.Mu.Unlock()
 				if err != nil {
-					return errMsg(fmt.Sprintf("Reload failed: %v", err))
+					m.srv.Notifier <- []byte("updated")
+					return buildErrMsg{title: "Reload failed", errs: sitegen.BuildErrors(err)}
 				}
 				m.srv.Notifier <- []byte("updated")
 				return buildMsg{stats: stats, time: time.Now()}
@@ -144,6 +156,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.lastBuild = msg.time
 		m.status = "Build complete"
 		m.errorMsg = "" // Clear error on successful build
+		if len(msg.errs) > 0 {
+			m.status = "Build complete with errors"
+			m.errorMsg = formatBuildErrors("Build errors", msg.errs, m.sg.SitePath, maxShownErrors)
+		}
 	case fileMsg:
 		entry := fmt.Sprintf("[%s] %s", time.Now().Format("15:04:05"), msg.path)
 		m.recentFiles = append(m.recentFiles, entry)
@@ -153,6 +169,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case errMsg:
 		m.errorMsg = formatErrorMsg(string(msg), m.sg.SitePath)
 		m.status = "Build failed"
+	case buildErrMsg:
+		m.errorMsg = formatBuildErrors(msg.title, msg.errs, m.sg.SitePath, maxShownErrors)
+		m.status = "Build failed"
 	case statusMsg:
 		m.status = string(msg)
 	case shareMsg:
@@ -165,6 +184,37 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// maxShownErrors caps the build errors the TUI lists; the rest are counted.
+const maxShownErrors = 5
+
+// formatBuildErrors lists errs, each with its location, message and the
+// excerpt of the file it is in, at most limit of them unless limit is 0.
+func formatBuildErrors(title string, errs []*sitegen.BuildError, basePath string, limit int) string {
+	var b strings.Builder
+	if title != "" {
+		b.WriteString(title + ":\n")
+	}
+	for i, e := range errs {
+		if limit > 0 && i == limit {
+			fmt.Fprintf(&b, "\n... and %d more\n", len(errs)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		msg := e.Message
+		if loc := e.Location(); loc != "" {
+			msg = loc + ": " + msg
+		}
+		b.WriteString(formatErrorMsg(msg, basePath) + "\n")
+		if e.Source != "" && e.Source != e.File {
+			fmt.Fprintf(&b, "  while building %s\n", e.Source)
+		}
+		b.WriteString(e.Snippet())
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
 func formatErrorMsg(errStr string, basePath string) string {
 	if errStr == "" {
 		return ""
@@ -313,6 +363,7 @@ func (m model) View() string {
 func main() {
 	var (
 		sitePath    string
+		configPath  string
 		publicPath  string
 		sourceDir   string
 		dataDir     string
@@ -329,7 +380,12 @@ func main() {
 		isMinify    bool
 		isWebp      bool
 		buildAll    bool
+		noCache     bool
+		drafts      bool
+		future      bool
+		expired     bool
 		cmdTimeout  int
+		jobs        int
 		showVersion bool
 		cms         bool
 		cmsAuth     string
@@ -339,6 +395,7 @@ func main() {
 	)
 	flag.BoolVar(&create, "create", false, "Creates a new site template")
 	flag.StringVar(&sitePath, "site", "./site", "Absolute or relative root site path")
+	flag.StringVar(&configPath, "config", "", "Config file (default: sitegen.yaml, .yml or .json in the site path)")
 	flag.StringVar(&sourceDir, "source", "src", "Source folder relative to site path")
 	flag.StringVar(&dataDir, "data", "data", "Data folder relative to site path")
 	flag.StringVar(&tplDir, "templates", "templates", "Template folder relative to site path")
@@ -350,7 +407,12 @@ func main() {
 	flag.BoolVar(&isMinify, "minify", false, "Minify (HTML|JS|CSS)")
 	flag.BoolVar(&isWebp, "webp", false, "Generate WebP optimized images")
 	flag.BoolVar(&buildAll, "buildall", false, "Always build all on change")
+	flag.BoolVar(&noCache, "no-cache", false, "Ignore the build cache and rebuild everything")
+	flag.BoolVar(&drafts, "drafts", false, "Include pages marked draft: true (default true with -serve)")
+	flag.BoolVar(&future, "future", false, "Include pages with a publishDate in the future")
+	flag.BoolVar(&expired, "expired", false, "Include pages past their expiryDate")
 	flag.IntVar(&cmdTimeout, "cmd-timeout", 120, "Timeout in seconds for serve/build frontmatter commands (0 disables)")
+	flag.IntVar(&jobs, "jobs", 0, "Number of sources to build concurrently (0 uses all CPUs)")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.StringVar(&port, "port", "8888", "Port for localhost")
 	flag.BoolVar(&isShare, "share", false, "Enable public sharing")
@@ -360,6 +422,17 @@ func main() {
 	flag.StringVar(&cmsAuth, "cms-auth", "", `Basic auth for the CMS ("user:pass")`)
 	flag.Parse()
 
+	// Commands come after the options, which may follow them too:
+	// sitegen -site mysite cache prune.
+	var command []string
+	if flag.Arg(0) == "cache" {
+		command = flag.Args()
+		if len(command) > 2 {
+			command = command[:2]
+		}
+		flag.CommandLine.Parse(flag.Args()[len(command):])
+	}
+
 	if showVersion {
 		fmt.Println(headerStyle.Render(fmt.Sprintf("SiteGen %s", version)))
 		return
@@ -376,6 +449,28 @@ func main() {
 		return
 	}
 
+	// Settings from the config file fill in every flag not given explicitly.
+	if configPath == "" {
+		configPath = sitegen.FindConfig(sitePath)
+	}
+	cfg := &sitegen.Config{}
+	if configPath != "" {
+		var err error
+		if cfg, err = applyConfig(configPath, serve); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// Drafts are previewed under -serve unless -drafts was set explicitly, on
+	// the command line or in the config file.
+	if serve {
+		draftsSet := false
+		flag.Visit(func(f *flag.Flag) { draftsSet = draftsSet || f.Name == "drafts" })
+		if !draftsSet {
+			drafts = true
+		}
+	}
+
 	if isMinify {
 		min = minify.New()
 		min.AddFunc("text/css", css.Minify)
@@ -402,8 +497,56 @@ func main() {
 	if basePath != "/" {
 		basePath = "/" + strings.Trim(basePath, "/") + "/"
 	}
-	sg = sitegen.NewSiteGen(sitePath, tplDir, dataDir, sourceDir, pubPath, basePath, min, clean, serve, isWebp)
-	sg.CmdTimeout = time.Duration(cmdTimeout) * time.Second
+	// -cmd-timeout 0 disables the timeout, which Options spells negative.
+	timeout := time.Duration(cmdTimeout) * time.Second
+	if timeout == 0 {
+		timeout = -1
+	}
+	sg = sitegen.NewSiteGen(sitegen.Options{
+		SitePath:    sitePath,
+		SourceDir:   sourceDir,
+		TemplateDir: tplDir,
+		DataDir:     dataDir,
+		PublicPath:  pubPath,
+		BasePath:    basePath,
+		Minify:      min,
+		Clean:       clean,
+		Dev:         serve,
+		Webp:        isWebp,
+		CmdTimeout:  timeout,
+		Jobs:        jobs,
+		// One-shot builds reuse the manifest from the previous run; serve
+		// keeps its own in-memory incremental rebuilds.
+		Incremental: !serve && !noCache,
+		Drafts:      drafts,
+		Future:      future,
+		Expired:     expired,
+		Site:        cfg.Site,
+		Taxonomies:  cfg.Taxonomies,
+		Feeds:       cfg.Feeds,
+		Markdown:    cfg.Markdown,
+		Highlight:   cfg.Highlight,
+		Redirects:   cfg.Redirects,
+		Headers:     cfg.Headers,
+		ServerFiles: cfg.ServerFiles,
+
+		TextTemplates:    cfg.TextTemplates,
+		ImageAttributes:  cfg.ImageAttributes,
+		ImagePlaceholder: cfg.ImagePlaceholder,
+		KeepMetadata:     cfg.KeepMetadata,
+	})
+
+	if command != nil {
+		if len(command) < 2 || command[1] != "prune" {
+			log.Fatalln("Usage: sitegen [options] cache prune")
+		}
+		n, size, err := sg.PruneImageCache()
+		if err != nil {
+			log.Fatalln("Failed to prune image cache:", err)
+		}
+		fmt.Printf("Removed %d cached images (%s)\n", n, formatSize(size))
+		return
+	}
 
 	// Single run
 	if !serve {
@@ -412,7 +555,7 @@ func main() {
 		renderStats(stats)
 		if err != nil {
 			fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Bold(true).Render("Build errors:"))
-			fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(formatErrorMsg(err.Error(), sg.SitePath)))
+			fmt.Println(lipgloss.NewStyle().Foreground(lipgloss.Color("196")).Render(formatBuildErrors("", sitegen.BuildErrors(err), sg.SitePath, 0)))
 			os.Exit(1)
 		}
 		return
@@ -429,6 +572,7 @@ func main() {
 	if cms {
 		ss.CMSEnabled = true
 		ss.CMSAuth = cmsAuth
+		ss.KeepMetadata = cfg.KeepMetadata
 		if abs, err := filepath.Abs(filepath.Join(sitePath, sourceDir)); err == nil {
 			ss.SrcDir = abs
 		} else {
@@ -474,9 +618,11 @@ func main() {
 		}()
 		sg.Mu.Lock()
 		stats, err := sg.BuildAll(false)
+		ss.SetRedirects(sg.Redirects())
+		ss.SetErrors(sg.Errors())
 		sg.Mu.Unlock()
 		if err != nil {
-			p.Send(errMsg(fmt.Sprintf("Build failed: %v", err)))
+			p.Send(buildErrMsg{title: "Build failed", errs: sitegen.BuildErrors(err)})
 		} else {
 			p.Send(buildMsg{stats: stats, time: time.Now()})
 		}
@@ -519,6 +665,53 @@ func main() {
 	}
 }
 
+// configOnly flags pick the mode or locate the config file itself, so the
+// config file can't set them.
+var configOnly = map[string]bool{"config": true, "create": true, "serve": true, "site": true, "version": true}
+
+// applyConfig loads the config file for the current environment (development
+// under -serve, production otherwise) and applies its settings to every flag
+// not given on the command line. Without an explicit base, the path of
+// baseURL is used.
+func applyConfig(path string, serve bool) (*sitegen.Config, error) {
+	env := sitegen.EnvProduction
+	if serve {
+		env = sitegen.EnvDevelopment
+	}
+	cfg, err := sitegen.LoadConfig(path, env)
+	if err != nil {
+		return nil, err
+	}
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := make([]string, 0, len(cfg.Settings))
+	for k := range cfg.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if configOnly[k] {
+			return nil, fmt.Errorf("%s: %q can only be set on the command line", path, k)
+		}
+		if flag.Lookup(k) == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, k)
+		}
+		if explicit[k] {
+			continue
+		}
+		if err := flag.Set(k, fmt.Sprint(cfg.Settings[k])); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, k, err)
+		}
+	}
+	if _, ok := cfg.Settings["base"]; !ok && !explicit["base"] && cfg.BaseURL != "" {
+		if u, err := url.Parse(cfg.BaseURL); err == nil && u.Path != "" {
+			flag.Set("base", u.Path)
+		}
+	}
+	return cfg, nil
+}
+
 func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, exclude, sourceDir, tplDir string, buildAll bool) {
 	watcher, err := fsnotify.NewWatcher()
 	var mu sync.Mutex
@@ -556,6 +749,7 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 		}
 
 		stats := map[string]int{}
+		var errs []*sitegen.BuildError
 
 		// Serialize all source-map and build access; concurrent processKey
 		// goroutines would otherwise race the sources map (an unrecoverable
@@ -588,7 +782,7 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 						}
 
 						if err := sg.Build(pp); err != nil {
-							p.Send(errMsg(fmt.Sprintf("Build failed %s: %v", pp, err)))
+							p.Send(buildErrMsg{title: "Build failed", errs: sitegen.BuildErrors(err)})
 						} else {
 							// handled by fileMsg
 						}
@@ -596,25 +790,17 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 						if buildAll {
 							s, err := sg.BuildAll(true)
 							if err != nil {
-								p.Send(errMsg(fmt.Sprintf("BuildAll failed: %v", err)))
+								p.Send(buildErrMsg{title: "BuildAll failed", errs: sitegen.BuildErrors(err)})
 							} else {
 								stats = s
 							}
 						} else if _, err := sg.BuildDependents(pp); err != nil {
 							// Rebuild listing pages so they pick up the new/edited
 							// content (e.g. a blog index showing a new post).
-							p.Send(errMsg(fmt.Sprintf("Rebuild dependents failed: %v", err)))
+							p.Send(buildErrMsg{title: "Rebuild dependents failed", errs: sitegen.BuildErrors(err)})
 						}
 					} else {
-						if strings.HasPrefix(rp, string(os.PathSeparator)+tplDir) {
-							sg.ClearCache()
-						}
-						s, err := sg.BuildAll(true)
-						if err != nil {
-							p.Send(errMsg(fmt.Sprintf("BuildAll failed: %v", err)))
-						} else {
-							stats = s
-						}
+						stats = rebuildNonSource(p, sg, pp, rp, tplDir, buildAll)
 					}
 				case "del":
 					if isSrc {
@@ -626,31 +812,26 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 						if buildAll {
 							s, err := sg.BuildAll(true)
 							if err != nil {
-								p.Send(errMsg(fmt.Sprintf("BuildAll failed: %v", err)))
+								p.Send(buildErrMsg{title: "BuildAll failed", errs: sitegen.BuildErrors(err)})
 							} else {
 								stats = s
 							}
 						} else if _, err := sg.BuildDependents(pp); err != nil {
-							p.Send(errMsg(fmt.Sprintf("Rebuild dependents failed: %v", err)))
+							p.Send(buildErrMsg{title: "Rebuild dependents failed", errs: sitegen.BuildErrors(err)})
 						}
 					} else {
-						if strings.HasPrefix(rp, string(os.PathSeparator)+tplDir) {
-							sg.ClearCache()
-						}
-						s, err := sg.BuildAll(true)
-						if err != nil {
-							p.Send(errMsg(fmt.Sprintf("BuildAll failed: %v", err)))
-						} else {
-							stats = s
-						}
+						stats = rebuildNonSource(p, sg, pp, rp, tplDir, buildAll)
 					}
 				}
 			}
+			ss.SetRedirects(sg.Redirects())
+			errs = sg.Errors()
+			ss.SetErrors(errs)
 		}()
 		ss.Notifier <- []byte("updated")
 
 		// Send build message to update timestamp, even if stats are empty
-		p.Send(buildMsg{stats: stats, time: time.Now()})
+		p.Send(buildMsg{stats: stats, time: time.Now(), errs: errs})
 	}
 
 	go func() {
@@ -673,6 +854,11 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 					if n := strings.Split(event.Name, string(os.PathSeparator)); strings.HasPrefix(n[len(n)-1], ".") {
 						continue
 					}
+					// Build output (public files, cached images) would
+					// otherwise trigger another build for every file written.
+					if buildOutput(sg, event.Name) {
+						continue
+					}
 					b := false
 					mu.Lock()
 					key := op + ":" + event.Name
@@ -705,7 +891,10 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 			if err != nil || info == nil {
 				return nil
 			}
-			if info.IsDir() && !strings.HasPrefix(path, sg.PublicPath) {
+			if info.IsDir() && buildOutput(sg, path) {
+				return filepath.SkipDir
+			}
+			if info.IsDir() {
 				if !excluded(exclude, strings.Replace(path, sg.SitePath+string(os.PathSeparator), "", 1)) {
 					if err := watcher.Add(path); err != nil {
 						p.Send(statusMsg(fmt.Sprintf("Watch dir %s error %v", path, err)))
@@ -719,6 +908,40 @@ func runWatcher(p *tea.Program, sg *sitegen.SiteGen, ss *server.StaticServer, ex
 	select {}
 }
 
+// rebuildNonSource handles a change outside the source dir. Template and data
+// edits only rebuild the pages whose last render read that file; anything else
+// (or -buildall) falls back to a full rebuild. The caller must hold sg.Mu.
+func rebuildNonSource(p *tea.Program, sg *sitegen.SiteGen, pp, rp, tplDir string, buildAll bool) map[string]int {
+	sep := string(os.PathSeparator)
+	tracked := strings.HasPrefix(rp, sep+tplDir+sep) || strings.HasPrefix(rp, sep+sg.DataDir+sep)
+	if tracked && !buildAll {
+		if _, err := sg.BuildAffected(pp); err != nil {
+			p.Send(buildErrMsg{title: "Rebuild failed", errs: sitegen.BuildErrors(err)})
+		}
+		return nil
+	}
+	if strings.HasPrefix(rp, sep+tplDir) {
+		sg.ClearCache()
+	}
+	s, err := sg.BuildAll(true)
+	if err != nil {
+		p.Send(buildErrMsg{title: "BuildAll failed", errs: sitegen.BuildErrors(err)})
+		return nil
+	}
+	return s
+}
+
+// formatSize formats n bytes as B, KB or MB.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
 func renderStats(stats map[string]int) {
 	if len(stats) == 0 {
 		return
@@ -744,6 +967,21 @@ func renderStats(stats map[string]int) {
 	))
 }
 
+// buildOutput reports whether path is in the public dir or the cache dir,
+// which builds write to and the watcher must not react to.
+func buildOutput(sg *sitegen.SiteGen, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, dir := range []string{sg.PublicPath, sg.CacheDir()} {
+		if d, err := filepath.Abs(dir); err == nil && (abs == d || strings.HasPrefix(abs, d+string(os.PathSeparator))) {
+			return true
+		}
+	}
+	return false
+}
+
 func excluded(pattern, path string) bool {
 	if strings.HasPrefix(path, ".") {
 		return true
@@ -468,11 +468,15 @@ type cmsCreateReq struct {
 	Collection string                 `json:"collection"`
 	Fields     map[string]interface{} `json:"fields"`
 	Body       string                 `json:"body"`
+	// Draft marks the new entry draft: true, keeping it out of production
+	// builds until an editor removes the flag.
+	Draft bool `json:"draft"`
 }
 
 // cmsCreate creates a new entry in a folder collection: it derives a slug,
 // writes src/<folder>/<slug>.<ext> with the collection's fields as frontmatter
 // (in declared order) plus the body, and returns the new entry's relative path.
+// A draft request also sets draft: true, on the declared field if there is one.
 func (ss *StaticServer) cmsCreate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -536,8 +540,14 @@ func (ss *StaticServer) cmsCreate(w http.ResponseWriter, r *http.Request) {
 		if val == nil {
 			val = ""
 		}
+		if f.Name == "draft" && req.Draft {
+			val = true
+		}
 		fields = append(fields, sitegen.FrontmatterField{Key: f.Name, Value: val})
 	}
+	if req.Draft && !hasField(fields, "draft") {
+		fields = append(fields, sitegen.FrontmatterField{Key: "draft", Value: true})
+	}
 
 	if err := sitegen.CreateSource(full, fields, []byte("\n"+req.Body)); err != nil {
 		if errors.Is(err, os.ErrExist) {
@@ -550,6 +560,15 @@ func (ss *StaticServer) cmsCreate(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true, "path": rel})
 }
 
+func hasField(fields []sitegen.FrontmatterField, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
 type cmsBlocksReq struct {
 	Path   string                   `json:"path"`
 	Blocks []map[string]interface{} `json:"blocks"`
@@ -684,7 +703,8 @@ func uniquePath(dir, name string) string {
 // cmsUpload accepts a multipart "file" image and saves it into the media folder
 // under src/. It returns the site-root URL to store in a frontmatter/data field
 // (e.g. /img/photo.png). The normal build (and -webp) pipeline processes it on
-// the next rebuild.
+// the next rebuild. JPEG and PNG uploads are saved with only the metadata
+// KeepMetadata lists (see sitegen.StripSourceMetadata).
 func (ss *StaticServer) cmsUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -712,14 +732,22 @@ func (ss *StaticServer) cmsUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "mkdir failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	full := uniquePath(dir, name)
-	out, err := os.Create(full)
+	b, err := io.ReadAll(file)
 	if err != nil {
-		http.Error(w, "create failed: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "read failed: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer out.Close()
-	if _, err := io.Copy(out, file); err != nil {
+	keep := ss.KeepMetadata
+	if keep == nil {
+		keep = sitegen.DefaultKeepMetadata
+	}
+	// Unless exif is kept, the EXIF is cut down to the orientation, capture
+	// date, camera and caption: the build needs the orientation to publish
+	// the image upright and templates read the rest, while serial numbers,
+	// owner names and the location go.
+	b = sitegen.StripSourceMetadata(b, keep)
+	full := uniquePath(dir, name)
+	if err := os.WriteFile(full, b, 0644); err != nil {
 		http.Error(w, "write failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -337,6 +337,55 @@ func TestCMSUploadSavesSanitizedAndUnique(t *testing.T) {
 	}
 }
 
+func TestCMSUploadStripsLocation(t *testing.T) {
+	ss, src := newCMSTestServer(t)
+
+	// A JPEG whose EXIF has a camera make, an artist and a GPS IFD with a
+	// latitude ref.
+	tiff := "II*\x00\x08\x00\x00\x00" +
+		"\x03\x00" +
+		"\x0f\x01\x02\x00\x04\x00\x00\x00Foo\x00" +
+		"\x3b\x01\x02\x00\x04\x00\x00\x00Ann\x00" +
+		"\x25\x88\x04\x00\x01\x00\x00\x00\x32\x00\x00\x00" +
+		"\x00\x00\x00\x00" +
+		"\x01\x00" +
+		"\x01\x00\x02\x00\x02\x00\x00\x00N\x00\x00\x00" +
+		"\x00\x00\x00\x00"
+	app1 := "Exif\x00\x00" + tiff
+	photo := "\xff\xd8\xff\xe1" + string([]byte{0, byte(len(app1) + 2)}) + app1 +
+		"\xff\xfe\x00\x07notes" + "\xff\xda\x00\x02scan\xff\xd9"
+
+	if code, out := uploadReq(t, ss, "phone.jpg", []byte(photo)); code != 200 {
+		t.Fatalf("upload status %d out %#v", code, out)
+	}
+	b, err := os.ReadFile(filepath.Join(src, "img", "phone.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// By default only the EXIF fields the build reads stay, the camera.
+	if !bytes.Contains(b, []byte("Foo")) || bytes.Contains(b, []byte("Ann")) || bytes.Contains(b, []byte("\x25\x88")) || bytes.Contains(b, []byte("N\x00\x00\x00")) {
+		t.Errorf("EXIF not cut down to the camera: %q", b)
+	}
+	if bytes.Contains(b, []byte("notes")) {
+		t.Errorf("comment kept: %q", b)
+	}
+
+	// Keeping exif keeps the artist, but not the location unless gps is
+	// kept too.
+	ss.KeepMetadata = []string{"exif"}
+	uploadReq(t, ss, "phone.jpg", []byte(photo))
+	if b, _ := os.ReadFile(filepath.Join(src, "img", "phone-1.jpg")); !bytes.Contains(b, []byte("Ann")) || bytes.Contains(b, []byte("\x25\x88")) {
+		t.Errorf("exif upload = %q", b)
+	}
+
+	// With everything kept the upload is saved as is.
+	ss.KeepMetadata = []string{"exif", "gps", "comment"}
+	uploadReq(t, ss, "phone.jpg", []byte(photo))
+	if b, _ := os.ReadFile(filepath.Join(src, "img", "phone-2.jpg")); string(b) != photo {
+		t.Errorf("kept upload changed: %q", b)
+	}
+}
+
 // extractScripts pulls the contents of every <script> block out of html.
 func extractScripts(html string) string {
 	var b strings.Builder
@@ -470,3 +519,24 @@ func TestCMSTraversalCannotEscapeSrc(t *testing.T) {
 		t.Errorf("traversal reached a file outside src (status %d)", code)
 	}
 }
+
+func TestCMSCreateDraft(t *testing.T) {
+	ss, src := newCMSWithCollection(t)
+	body := `{"collection":"blog","fields":{"title":"Later"},"body":"x","draft":true}`
+	if code, out := doJSON(t, ss, "POST", "/__cms/api/create", body); code != 200 {
+		t.Fatalf("create status %d out %#v", code, out)
+	}
+	got, err := os.ReadFile(filepath.Join(src, "blog", "later.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "draft: true") {
+		t.Errorf("draft flag missing:\n%s", got)
+	}
+	if code, _ := doJSON(t, ss, "POST", "/__cms/api/create", `{"collection":"blog","fields":{"title":"Now"},"body":"x"}`); code != 200 {
+		t.Fatalf("create status %d", code)
+	}
+	if got, _ := os.ReadFile(filepath.Join(src, "blog", "now.md")); strings.Contains(string(got), "draft") {
+		t.Errorf("non-draft entry has a draft flag:\n%s", got)
+	}
+}
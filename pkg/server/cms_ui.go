@@ -45,6 +45,7 @@ const cmsHTML = `<!DOCTYPE html>
   .create h2 { font-size:15px; margin:0 0 12px; }
   .create .err { color:var(--danger); font-size:13px; min-height:18px; }
   .create .actions { display:flex; gap:8px; margin-top:10px; }
+  .create .draftopt { display:flex; align-items:center; gap:6px; color:var(--muted); font-size:13px; margin-bottom:10px; }
   aside .file { padding:7px 14px; cursor:pointer; color:var(--muted); white-space:nowrap; overflow:hidden; text-overflow:ellipsis; }
   aside .file:hover { background:#1e2230; color:var(--fg); }
   aside .file.active { background:#1e2230; color:var(--accent); }
@@ -225,7 +226,7 @@ function openCreate(col){
   $('data').classList.remove('show');
   [].forEach.call($('files').children, function(c){ c.classList.remove('active'); });
   [].forEach.call(document.querySelectorAll('.datarow'), function(c){ c.classList.remove('active'); });
-  var draft={}, bf=bodyFieldOf(col), bodyVal='';
+  var draft={}, bf=bodyFieldOf(col), bodyVal='', asDraft=false;
   (col.fields||[]).forEach(function(f){ if(f.widget==='hidden'||f.name===bf) return; draft[f.name]=(f.widget==='list')?[]:''; });
   var err=document.createElement('div'); err.className='err';
   function draw(){
@@ -236,10 +237,15 @@ function openCreate(col){
     var blbl=document.createElement('label'); blbl.textContent='Body'; brow.appendChild(blbl);
     var bta=document.createElement('textarea'); bta.style.minHeight='180px'; bta.value=bodyVal;
     bta.oninput=function(){ bodyVal=bta.value; }; brow.appendChild(bta); host.appendChild(brow);
+    var drow=document.createElement('label'); drow.className='draftopt';
+    var dcb=document.createElement('input'); dcb.type='checkbox'; dcb.checked=asDraft;
+    dcb.onchange=function(){ asDraft=dcb.checked; };
+    drow.appendChild(dcb); drow.appendChild(document.createTextNode('Save as draft (hidden from production builds)'));
+    host.appendChild(drow);
     host.appendChild(err);
     var actions=document.createElement('div'); actions.className='actions';
     var ok=document.createElement('button'); ok.textContent='Create';
-    ok.onclick=function(){ submitCreate(col, draft, bodyVal, err, ok); };
+    ok.onclick=function(){ submitCreate(col, draft, bodyVal, asDraft, err, ok); };
     var cancel=document.createElement('button'); cancel.className='ghost'; cancel.textContent='Cancel';
     cancel.onclick=function(){ host.classList.remove('show'); $('blocks').classList.remove('hide'); };
     actions.appendChild(ok); actions.appendChild(cancel); host.appendChild(actions);
@@ -248,10 +254,10 @@ function openCreate(col){
   draw();
 }
 
-function submitCreate(col, draft, body, errEl, btn){
+function submitCreate(col, draft, body, asDraft, errEl, btn){
   btn.disabled=true; errEl.textContent='';
   fetch('/__cms/api/create',{method:'POST',headers:{'Content-Type':'application/json'},
-    body:JSON.stringify({collection:col.name, fields:draft, body:body})})
+    body:JSON.stringify({collection:col.name, fields:draft, body:body, draft:asDraft})})
     .then(function(r){ return r.json().then(function(j){return {ok:r.ok,j:j};}); })
     .then(function(res){
       btn.disabled=false;
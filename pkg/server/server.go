@@ -3,12 +3,16 @@ package server
 import (
 	"bytes"
 	"fmt"
+	"html/template"
 	"log"
 	"mime"
 	"net/http"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+
+	"github.com/altlimit/sitegen/pkg/sitegen"
 )
 
 var (
@@ -32,6 +36,17 @@ function initHotReload() {
 }
 initHotReload();
 	</script>`
+
+	// errorOverlay covers the page with the errors of the last build, so a
+	// broken page shows what broke it rather than its last good output.
+	errorOverlay = template.Must(template.New("errors").Parse(`<div id="sitegen-errors" style="position:fixed;inset:0;z-index:2147483647;overflow:auto;padding:32px;background:rgba(24,24,27,.96);color:#e4e4e7;font:14px/1.5 ui-monospace,SFMono-Regular,Menlo,Consolas,monospace;text-align:left">
+<button type="button" onclick="this.parentNode.remove()" style="float:right;background:none;border:1px solid #52525b;color:#e4e4e7;border-radius:4px;padding:2px 10px;cursor:pointer">Dismiss</button>
+<h2 style="margin:0 0 20px;color:#f87171;font-size:18px">Build failed: {{len .}} error{{if gt (len .) 1}}s{{end}}</h2>
+{{range .}}<section style="margin-bottom:24px">
+{{with .Location}}<div style="color:#facc15">{{.}}</div>{{end}}{{if and .Source (ne .Source .File)}}<div style="color:#a1a1aa">while building {{.Source}}</div>{{end}}
+<div style="white-space:pre-wrap">{{.Message}}</div>
+{{with .Snippet}}<pre style="margin:8px 0 0;padding:12px;background:#09090b;border-radius:4px;overflow:auto">{{.}}</pre>{{end}}
+</section>{{end}}</div>`))
 )
 
 type StaticServer struct {
@@ -47,6 +62,67 @@ type StaticServer struct {
 	SrcDir     string // absolute path to the site source directory
 	DataDir    string // absolute path to the site data directory
 	CMSAuth    string // "user:pass" for basic auth, or "" for none
+	// KeepMetadata lists the image metadata kinds uploads keep (see
+	// sitegen.MetadataKinds), sitegen.DefaultKeepMetadata when nil.
+	KeepMetadata []string
+
+	// redirects is the site's redirect table keyed by old path without a
+	// trailing slash, replaced after every build via SetRedirects.
+	redirectsMu sync.RWMutex
+	redirects   map[string]sitegen.Redirect
+
+	// errors are the errors of the last build, shown over every page served
+	// until a build fixes them.
+	errorsMu sync.RWMutex
+	errors   []*sitegen.BuildError
+}
+
+// SetRedirects replaces the redirect table the server answers from, so old
+// paths get the same redirect status a host would send.
+func (ss *StaticServer) SetRedirects(redirects []sitegen.Redirect) {
+	m := make(map[string]sitegen.Redirect, len(redirects))
+	for _, r := range redirects {
+		m[redirectKey(r.From)] = r
+	}
+	ss.redirectsMu.Lock()
+	ss.redirects = m
+	ss.redirectsMu.Unlock()
+}
+
+// SetErrors replaces the build errors shown over served pages; nil clears
+// them.
+func (ss *StaticServer) SetErrors(errs []*sitegen.BuildError) {
+	ss.errorsMu.Lock()
+	ss.errors = errs
+	ss.errorsMu.Unlock()
+}
+
+// overlay renders the error overlay, empty when the last build succeeded.
+func (ss *StaticServer) overlay() string {
+	ss.errorsMu.RLock()
+	defer ss.errorsMu.RUnlock()
+	if len(ss.errors) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := errorOverlay.Execute(&buf, ss.errors); err != nil {
+		log.Println("error overlay", err)
+	}
+	return buf.String()
+}
+
+func (ss *StaticServer) redirect(p string) (sitegen.Redirect, bool) {
+	ss.redirectsMu.RLock()
+	defer ss.redirectsMu.RUnlock()
+	r, ok := ss.redirects[redirectKey(p)]
+	return r, ok
+}
+
+func redirectKey(p string) string {
+	if p != "/" {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
 }
 
 func (ss *StaticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -84,6 +160,11 @@ func (ss *StaticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	} else {
 		const indexPage = "/index.html"
 
+		if rd, ok := ss.redirect(r.URL.Path); ok {
+			http.Redirect(w, r, rd.To, rd.Status)
+			return
+		}
+
 		if strings.HasSuffix(r.URL.Path, indexPage) {
 			localRedirect(w, r, "./")
 			return
@@ -106,6 +187,13 @@ func (ss *StaticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			if err != nil {
+				// A page that failed to build shows why instead.
+				if o := ss.overlay(); o != "" {
+					w.Header().Set("Content-Type", "text/html; charset=utf-8")
+					w.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>Build failed</title></head><body>%s%s</body></html>", o, hotReloadScript)
+					return
+				}
 				log.Println(name, " error ", err)
 				return
 			}
@@ -154,7 +242,7 @@ func (ss *StaticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				log.Println("Error writing response: ", err)
 			}
 			if bb := string(body); strings.Contains(bb, "</body>") {
-				bb = strings.ReplaceAll(bb, "</body>", fmt.Sprintf("%s</body>", hotReloadScript))
+				bb = strings.ReplaceAll(bb, "</body>", fmt.Sprintf("%s%s</body>", ss.overlay(), hotReloadScript))
 				body = []byte(bb)
 			}
 			w.Write(body)
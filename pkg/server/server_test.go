@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/altlimit/sitegen/pkg/sitegen"
+)
+
+func TestStaticServerRedirects(t *testing.T) {
+	pub := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(pub, "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pub, "new", "index.html"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ss := &StaticServer{PublicDir: pub, BaseDir: "/"}
+	ss.SetRedirects([]sitegen.Redirect{
+		{From: "/old", To: "/new", Status: http.StatusMovedPermanently},
+		{From: "/tmp/", To: "https://example.com/", Status: http.StatusFound},
+	})
+	for _, c := range []struct {
+		path, loc string
+		code      int
+	}{
+		{"/old", "/new", 301},
+		{"/old/", "/new", 301},
+		{"/tmp", "https://example.com/", 302},
+		{"/new/", "", 200},
+	} {
+		w := httptest.NewRecorder()
+		ss.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code || w.Header().Get("Location") != c.loc {
+			t.Errorf("%s: %d %q, want %d %q", c.path, w.Code, w.Header().Get("Location"), c.code, c.loc)
+		}
+	}
+
+	ss.SetRedirects(nil)
+	w := httptest.NewRecorder()
+	ss.ServeHTTP(w, httptest.NewRequest("GET", "/old", nil))
+	if w.Code == http.StatusMovedPermanently {
+		t.Error("redirect kept after the table was replaced")
+	}
+}
+
+func TestStaticServerErrors(t *testing.T) {
+	pub := t.TempDir()
+	if err := os.WriteFile(filepath.Join(pub, "index.html"), []byte("<html><body>old</body></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ss := &StaticServer{PublicDir: pub, BaseDir: "/"}
+	get := func(p string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ss.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		return w
+	}
+	if body := get("/").Body.String(); strings.Contains(body, "sitegen-errors") {
+		t.Errorf("overlay without errors:\n%s", body)
+	}
+
+	ss.SetErrors([]*sitegen.BuildError{{
+		Source:  "src/index.html",
+		File:    "templates/main.html",
+		Line:    3,
+		Column:  5,
+		Message: `executing "main.html" at <.x>: <bad>`,
+		Excerpt: []sitegen.ExcerptLine{{Number: 3, Text: "<p>{{.x}}</p>", Error: true}},
+	}})
+	body := get("/").Body.String()
+	for _, want := range []string{"old", `id="sitegen-errors"`, "templates/main.html:3:5", "while building src/index.html", "&lt;bad&gt;", "&gt; 3 | &lt;p&gt;{{.x}}&lt;/p&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if w := get("/missing/"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "sitegen-errors") {
+		t.Errorf("missing page: %d\n%s", w.Code, w.Body.String())
+	}
+
+	ss.SetErrors(nil)
+	if body := get("/").Body.String(); strings.Contains(body, "sitegen-errors") {
+		t.Error("overlay kept after the errors were cleared")
+	}
+}
@@ -0,0 +1,308 @@
+package sitegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cache.go persists a build manifest between runs so a one-shot build only
+// re-renders what changed. The manifest lives in <site>/.sitegen/cache.json
+// and records, per source, the content hash of the file, the hashes of the
+// templates and data files its render read, and the public files it wrote.
+//
+// A source is skipped when its own hash, every dependency hash and every
+// output file are unchanged. Sources flagged dynamic (listing pages) depend on
+// the whole content set, so they are rebuilt whenever anything at all
+// changed. Renders that read .BuildID, .Today or .Year record the values they
+// read and are rebuilt once those change; an incremental build's .BuildID is a
+// hash of its inputs rather than the time, so it only changes with them.
+// Sources flagged volatile (renders calling now) are rebuilt on every build.
+// Outputs of sources that no longer exist are deleted.
+
+const cacheDir = ".sitegen"
+
+type buildManifest struct {
+	// Config fingerprints the settings that shape output (public path, base,
+	// minify, ...). A mismatch invalidates every entry.
+	Config  string                    `json:"config"`
+	Entries map[string]*manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	Hash     string            `json:"hash"`
+	Deps     map[string]string `json:"deps,omitempty"`
+	Outputs  []string          `json:"outputs,omitempty"`
+	Dynamic  bool              `json:"dynamic,omitempty"`
+	Volatile bool              `json:"volatile,omitempty"`
+	// Build holds the build-time fields the render read and their values.
+	Build map[string]string `json:"build,omitempty"`
+}
+
+// CacheDir is the directory holding the build manifest and the image cache.
+// Watchers should ignore it; builds write to it.
+func (sg *SiteGen) CacheDir() string {
+	return filepath.Join(sg.SitePath, cacheDir)
+}
+
+func (sg *SiteGen) manifestPath() string {
+	return filepath.Join(sg.CacheDir(), "cache.json")
+}
+
+// configFingerprint summarizes the settings that change rendered output,
+// with the site config, taxonomies, feeds, redirects and headers hashed.
+func (sg *SiteGen) configFingerprint() string {
+	b, _ := json.Marshal([]interface{}{sg.Site, sg.TaxonomyConfig, sg.FeedConfig, sg.RedirectConfig, sg.HeaderConfig, sg.ServerFiles})
+	site := sha256.Sum256(b)
+	return fmt.Sprintf("src=%s;tpl=%s;data=%s;public=%s;base=%s;minify=%v;webp=%v;imgattrs=%v;placeholder=%s;keep=%v;dev=%v;drafts=%v;future=%v;expired=%v;text=%v;markdown=%+v;highlight=%+v;site=%s",
+		sg.SourceDir, sg.TemplateDir, sg.DataDir, sg.PublicPath, sg.BasePath,
+		sg.Minify != nil, sg.Webp, sg.ImageAttributes, sg.ImagePlaceholder, sg.KeepMetadata, sg.Dev,
+		sg.Drafts, sg.Future, sg.Expired, sg.TextTemplates, sg.Markdown, sg.Highlight, hex.EncodeToString(site[:8]))
+}
+
+// loadManifest reads the previous manifest. A missing, unreadable or stale
+// manifest yields an empty one, which simply means a full build.
+func (sg *SiteGen) loadManifest() *buildManifest {
+	m := &buildManifest{Config: sg.configFingerprint(), Entries: map[string]*manifestEntry{}}
+	b, err := os.ReadFile(sg.manifestPath())
+	if err != nil {
+		return m
+	}
+	var old buildManifest
+	if err := json.Unmarshal(b, &old); err != nil || old.Config != m.Config || old.Entries == nil {
+		return m
+	}
+	m.Entries = old.Entries
+	return m
+}
+
+func (sg *SiteGen) saveManifest(m *buildManifest) error {
+	p := sg.manifestPath()
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, b, 0644)
+}
+
+// fileHasher hashes files once per build; templates and data files are
+// shared by many sources.
+type fileHasher struct {
+	hashes map[string]string
+}
+
+func newFileHasher() *fileHasher {
+	return &fileHasher{hashes: map[string]string{}}
+}
+
+// hash returns the hex sha256 of the file at path, or "" if unreadable. A
+// directory (the data dir) hashes the paths and hashes of its files.
+func (h *fileHasher) hash(path string) string {
+	if v, ok := h.hashes[path]; ok {
+		return v
+	}
+	var v string
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		sum := sha256.New()
+		filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if rel, rerr := filepath.Rel(path, p); err == nil && rerr == nil && !info.IsDir() {
+				fmt.Fprintln(sum, filepath.ToSlash(rel), h.hash(p))
+			}
+			return nil
+		})
+		v = hex.EncodeToString(sum.Sum(nil))
+	} else if b, err := os.ReadFile(path); err == nil {
+		sum := sha256.Sum256(b)
+		v = hex.EncodeToString(sum[:])
+	}
+	h.hashes[path] = v
+	return v
+}
+
+// sourceHash is the content hash of the source registered under k; virtual
+// sources carry their own. The set of a page's translations is mixed in, so
+// adding or removing one rebuilds the pages linking to it.
+func (sg *SiteGen) sourceHash(k string, h *fileHasher) string {
+	s := sg.sources[k]
+	if s != nil && s.virtual {
+		return s.hash
+	}
+	v := h.hash(k)
+	if s == nil || v == "" {
+		return v
+	}
+	if ts := s.Translations(); len(ts) > 0 {
+		sum := sha256.New()
+		fmt.Fprintln(sum, v)
+		for _, t := range ts {
+			fmt.Fprintln(sum, sg.rel(t.Local))
+		}
+		v = hex.EncodeToString(sum.Sum(nil))
+	}
+	return v
+}
+
+// inputsID is the build ID of an incremental build: a hash of the config
+// fingerprint and of the source, template and data dirs, so it only changes
+// when something the build reads does.
+func (sg *SiteGen) inputsID(config string, h *fileHasher) string {
+	sum := sha256.New()
+	fmt.Fprintln(sum, config)
+	for _, dir := range []string{sg.SourceDir, sg.TemplateDir, sg.DataDir} {
+		fmt.Fprintln(sum, dir, h.hash(filepath.Join(sg.SitePath, dir)))
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:12]
+}
+
+// rel makes path relative to the site root so the manifest survives moving
+// the checkout.
+func (sg *SiteGen) rel(path string) string {
+	r, err := filepath.Rel(sg.SitePath, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(r)
+}
+
+// fresh reports whether the recorded entry still matches the source on disk.
+func (sg *SiteGen) fresh(e *manifestEntry, hash string, h *fileHasher) bool {
+	if e == nil || e.Hash != hash || hash == "" {
+		return false
+	}
+	for dep, want := range e.Deps {
+		if h.hash(filepath.Join(sg.SitePath, filepath.FromSlash(dep))) != want {
+			return false
+		}
+	}
+	for _, o := range e.Outputs {
+		if _, err := os.Stat(filepath.Join(sg.PublicPath, filepath.FromSlash(o))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheable reports whether a source's output is fully determined by the
+// files the manifest tracks. Frontmatter commands have unknown inputs.
+func cacheable(s *Source) bool {
+	if s.Err != nil {
+		return false
+	}
+	if _, ok := s.Meta["serve"]; ok {
+		return false
+	}
+	if _, ok := s.Meta["build"]; ok {
+		return false
+	}
+	return true
+}
+
+// planIncremental decides which sources must be rebuilt. It returns the keys
+// to build and the entries carried over unchanged into the next manifest.
+func (sg *SiteGen) planIncremental(keys []string, old *buildManifest, h *fileHasher) ([]string, map[string]*manifestEntry) {
+	vals := sg.buildValues()
+	hashes := make(map[string]string, len(keys))
+	changed := false
+	seen := map[string]bool{}
+	for _, k := range keys {
+		rk := sg.rel(k)
+		seen[rk] = true
+		hashes[k] = sg.sourceHash(k, h)
+		if e := old.Entries[rk]; !sg.fresh(e, hashes[k], h) {
+			changed = true
+		}
+	}
+	for rk := range old.Entries {
+		if !seen[rk] {
+			changed = true
+		}
+	}
+
+	var build []string
+	kept := map[string]*manifestEntry{}
+	for _, k := range keys {
+		rk := sg.rel(k)
+		e := old.Entries[rk]
+		if cacheable(sg.sources[k]) && sg.fresh(e, hashes[k], h) && !e.Volatile && !(e.Dynamic && changed) && sameBuild(e.Build, vals) {
+			kept[rk] = e
+			continue
+		}
+		build = append(build, k)
+	}
+	return build, kept
+}
+
+// sameBuild reports whether the build-time fields a render read still have
+// the values it read.
+func sameBuild(read, cur map[string]string) bool {
+	for k, v := range read {
+		if cur[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// recordFailure keeps tracking the outputs of a source whose build failed, so
+// they are still pruned if it is deleted later. The empty hash guarantees it
+// is rebuilt next time.
+func (sg *SiteGen) recordFailure(m, old *buildManifest, k string) {
+	if e, ok := old.Entries[sg.rel(k)]; ok {
+		m.Entries[sg.rel(k)] = &manifestEntry{Outputs: e.Outputs}
+	}
+}
+
+// recordBuild stores the manifest entry for a freshly built source. An
+// uncacheable source only records its outputs, like recordFailure, so they
+// are not pruned as if the source were gone.
+func (sg *SiteGen) recordBuild(m *buildManifest, k string, h *fileHasher) {
+	s := sg.sources[k]
+	e := &manifestEntry{}
+	for _, o := range s.outputs {
+		if r, err := filepath.Rel(sg.PublicPath, o); err == nil {
+			e.Outputs = append(e.Outputs, filepath.ToSlash(r))
+		}
+	}
+	if cacheable(s) {
+		e.Hash, e.Dynamic, e.Volatile, e.Deps = sg.sourceHash(k, h), s.dynamic, s.volatile, map[string]string{}
+		for dep := range s.deps {
+			e.Deps[sg.rel(dep)] = h.hash(dep)
+		}
+		e.Build = s.buildValues
+	}
+	m.Entries[sg.rel(k)] = e
+}
+
+// pruneOutputs deletes files written by sources that are gone, unless a
+// current source still claims them.
+func (sg *SiteGen) pruneOutputs(old, cur *buildManifest) {
+	claimed := map[string]bool{}
+	for _, e := range cur.Entries {
+		for _, o := range e.Outputs {
+			claimed[o] = true
+		}
+	}
+	for rk, e := range old.Entries {
+		if _, ok := cur.Entries[rk]; ok {
+			continue
+		}
+		for _, o := range e.Outputs {
+			if claimed[o] || strings.HasPrefix(o, "..") {
+				continue
+			}
+			p := filepath.Join(sg.PublicPath, filepath.FromSlash(o))
+			if err := os.Remove(p); err == nil {
+				if empty, err := isDirEmpty(filepath.Dir(p)); err == nil && empty {
+					os.Remove(filepath.Dir(p))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package sitegen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// config.go loads the optional site configuration file, sitegen.yaml (or
+// .yml/.json) at the site root. It carries two kinds of settings:
+//
+//   - site-wide params (title, baseURL, language, params) that templates see
+//     as .Site, and
+//   - flag-equivalent build options keyed by flag name (public, minify, webp,
+//     exclude, cmd-timeout, ...), which main applies unless the same flag was
+//     given on the command line.
+//
+// Top-level "development" and "production" sections are overlaid on the rest
+// of the file for serve and one-shot builds respectively.
+
+// ConfigNames are the file names FindConfig looks for, in order.
+var ConfigNames = []string{"sitegen.yaml", "sitegen.yml", "sitegen.json"}
+
+// Environments that may appear as override sections in the config file.
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// Site holds the site-wide params exposed to templates as .Site.
+type Site struct {
+	Title    string                 `yaml:"title" json:"title"`
+	BaseURL  string                 `yaml:"baseURL" json:"baseURL"`
+	Language string                 `yaml:"language" json:"language"`
+	Params   map[string]interface{} `yaml:"params" json:"params"`
+	// Languages enables multilingual content, keyed by language code.
+	// Language above is the default one, built without a path prefix.
+	Languages map[string]LanguageConfig `yaml:"languages" json:"languages"`
+	// data is the tree of the data dir's files, loaded for every build,
+	// that templates read through Data, and page the source being rendered.
+	data map[string]interface{}
+	page *Source
+}
+
+// Config is a parsed site configuration file.
+type Config struct {
+	Site `yaml:",inline"`
+
+	// Taxonomies maps a frontmatter key (tags, categories, ...) to how its
+	// term pages are generated.
+	Taxonomies map[string]TaxonomyConfig `yaml:"taxonomies"`
+
+	// Feeds maps a section path (blog, or / for the whole site) to the
+	// RSS, Atom and JSON feeds generated for it.
+	Feeds map[string]FeedConfig `yaml:"feeds"`
+
+	// Markdown configures the markdown extensions. LoadConfig always sets
+	// it: keys left out, or the whole section, keep their DefaultMarkdown
+	// value.
+	Markdown *MarkdownConfig `yaml:"markdown"`
+
+	// Highlight configures syntax highlighting of code.
+	Highlight HighlightConfig `yaml:"highlight"`
+
+	// Redirects maps old paths to the path or URL they moved to, optionally
+	// followed by a status ("/new 302").
+	Redirects map[string]string `yaml:"redirects"`
+
+	// Headers maps path patterns to response headers for the _headers file.
+	Headers map[string]map[string]string `yaml:"headers"`
+
+	// ServerFiles lists the server configs to generate from the redirects:
+	// netlify (_redirects), headers (_headers), nginx and apache (.htaccess).
+	ServerFiles []string `yaml:"serverFiles"`
+
+	// TextTemplates renders HTML without html/template's contextual
+	// escaping, as sites written for older versions expect.
+	TextTemplates bool `yaml:"textTemplates"`
+
+	// ImageAttributes adds width and height to img tags from the image, and
+	// loading="lazy" and decoding="async" to all but the first on a page.
+	ImageAttributes bool `yaml:"imageAttributes"`
+
+	// ImagePlaceholder gives img tags a background while the image loads:
+	// "color" for its dominant color or "blur" for a blurred copy.
+	ImagePlaceholder string `yaml:"imagePlaceholder"`
+
+	// KeepMetadata lists the kinds of image metadata published images
+	// keep: exif, gps, xmp, iptc, icc and/or comment. Only icc when unset.
+	KeepMetadata []string `yaml:"keepMetadata"`
+
+	// Settings holds every other top-level key: build options named after
+	// the command-line flag they stand in for.
+	Settings map[string]interface{} `yaml:",inline"`
+}
+
+// FindConfig returns the path of the first config file present in dir, or ""
+// when there is none.
+func FindConfig(dir string) string {
+	for _, n := range ConfigNames {
+		p := filepath.Join(dir, n)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// LoadConfig reads the config file at path and overlays the section for env
+// (EnvDevelopment or EnvProduction) on top of it. JSON files parse as YAML.
+func LoadConfig(path, env string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if section, ok := raw[env]; ok {
+		m, ok := section.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("config %s: %s must be a mapping", path, env)
+		}
+		mergeMaps(raw, m)
+	}
+	delete(raw, EnvDevelopment)
+	delete(raw, EnvProduction)
+
+	// Round-trip the merged map so the typed fields decode normally.
+	merged, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	md := DefaultMarkdown
+	cfg := &Config{Markdown: &md}
+	if err := yaml.Unmarshal(merged, cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if cfg.Settings == nil {
+		cfg.Settings = map[string]interface{}{}
+	}
+	for name, t := range cfg.Taxonomies {
+		if strings.Trim(name, "/") == "" || (t.Path != "" && strings.Trim(t.Path, "/") == "") {
+			return nil, fmt.Errorf("config %s: taxonomy %q: path must not be the site root", path, name)
+		}
+	}
+	return cfg, nil
+}
+
+// mergeMaps copies src into dst, merging nested mappings key by key so an
+// environment section can override a single param.
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeMaps(dv, sv)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
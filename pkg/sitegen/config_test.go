@@ -0,0 +1,145 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigEnvironmentOverlay(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sitegen.yaml")
+	cfg := "title: Docs\nbaseURL: https://example.com/docs/\nlanguage: en\n" +
+		"params:\n  author: Ann\n  theme: light\n" +
+		"minify: false\npublic: ./out\n" +
+		"production:\n  minify: true\n  params:\n    theme: dark\n" +
+		"development:\n  cms: true\n"
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	prod, err := LoadConfig(path, EnvProduction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prod.Title != "Docs" || prod.BaseURL != "https://example.com/docs/" || prod.Language != "en" {
+		t.Errorf("site = %+v", prod.Site)
+	}
+	if prod.Params["author"] != "Ann" || prod.Params["theme"] != "dark" {
+		t.Errorf("params not merged: %v", prod.Params)
+	}
+	if prod.Settings["minify"] != true || prod.Settings["public"] != "./out" {
+		t.Errorf("settings = %v", prod.Settings)
+	}
+	if _, ok := prod.Settings["cms"]; ok {
+		t.Error("development section leaked into production")
+	}
+	for _, k := range []string{"title", "params", "production", "development"} {
+		if _, ok := prod.Settings[k]; ok {
+			t.Errorf("%q should not be a setting", k)
+		}
+	}
+
+	dev, err := LoadConfig(path, EnvDevelopment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Settings["minify"] != false || dev.Settings["cms"] != true || dev.Params["theme"] != "light" {
+		t.Errorf("development config = %+v", dev)
+	}
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sitegen.json"), []byte(`{"title": "J", "webp": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := FindConfig(dir)
+	if filepath.Base(path) != "sitegen.json" {
+		t.Fatalf("FindConfig = %q", path)
+	}
+	cfg, err := LoadConfig(path, EnvProduction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Title != "J" || cfg.Settings["webp"] != true {
+		t.Errorf("config = %+v", cfg)
+	}
+	if FindConfig(t.TempDir()) != "" {
+		t.Error("FindConfig found a file in an empty dir")
+	}
+}
+
+func TestSiteExposedToTemplates(t *testing.T) {
+	site := t.TempDir()
+	pub := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(site, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	page := `<title>{{.Site.Title}}</title><html lang="{{.Site.Language}}">{{.Site.Params.tagline}}`
+	if err := os.WriteFile(filepath.Join(site, "src", "index.html"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Site: Site{
+		Title: "Hello", Language: "de", Params: map[string]interface{}{"tagline": "Hi"},
+	}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(pub, "index.html"))
+	if want := `<title>Hello</title><html lang="de">Hi`; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
+func TestLoadConfigMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sitegen.yaml")
+	if err := os.WriteFile(path, []byte("markdown:\n  footnotes: true\n  headingIDs: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path, EnvProduction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MarkdownConfig{Footnotes: true, HeadingIDs: true}
+	if cfg.Markdown == nil || *cfg.Markdown != want {
+		t.Errorf("markdown = %+v, want %+v", cfg.Markdown, want)
+	}
+	if _, ok := cfg.Settings["markdown"]; ok {
+		t.Error("markdown should not be a setting")
+	}
+
+	// Without a markdown section the defaults are set all the same.
+	if err := os.WriteFile(path, []byte("title: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = LoadConfig(path, EnvProduction); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Markdown == nil || *cfg.Markdown != DefaultMarkdown {
+		t.Errorf("markdown without a section = %+v, want %+v", cfg.Markdown, DefaultMarkdown)
+	}
+}
+
+func TestLoadConfigRejectsRootTaxonomy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sitegen.yaml")
+	if err := os.WriteFile(path, []byte("taxonomies:\n  tags:\n    path: /\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path, EnvProduction); err == nil {
+		t.Error("taxonomy at the site root accepted")
+	}
+}
+
+func TestNewSiteGenCmdTimeout(t *testing.T) {
+	site := t.TempDir()
+	os.MkdirAll(filepath.Join(site, "src"), 0755)
+	if got := NewSiteGen(Options{SitePath: site}).CmdTimeout; got != DefaultCmdTimeout {
+		t.Errorf("unset CmdTimeout = %s, want %s", got, DefaultCmdTimeout)
+	}
+	if got := NewSiteGen(Options{SitePath: site, CmdTimeout: -1}).CmdTimeout; got > 0 {
+		t.Errorf("negative CmdTimeout = %s, want disabled", got)
+	}
+}
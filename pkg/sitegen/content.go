@@ -0,0 +1,283 @@
+package sitegen
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// content.go derives reading aids from markdown sources: the table of
+// contents, word count, reading time and summary. They are computed from the
+// parsed document once per loaded content and exposed on Source, so they work
+// both at the page root (.TableOfContents) and on items from the sources func.
+
+// moreMarker ends a page's manual summary.
+const moreMarker = "<!--more-->"
+
+// Heading is an entry of a table of contents.
+type Heading struct {
+	ID       string
+	Title    string
+	Level    int
+	Children []*Heading
+}
+
+// TableOfContents is the nested heading tree of a markdown page. Its HTML
+// renders as a nav of nested lists linking each heading's id.
+type TableOfContents []*Heading
+
+func (toc TableOfContents) String() string {
+	if len(toc) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString(`<nav id="TableOfContents">`)
+	toc.write(&buf)
+	buf.WriteString(`</nav>`)
+	return buf.String()
+}
+
+// HTML is String typed as safe HTML.
+func (toc TableOfContents) HTML() template.HTML {
+	return template.HTML(toc.String())
+}
+
+func (toc TableOfContents) write(buf *strings.Builder) {
+	buf.WriteString("<ul>")
+	for _, h := range toc {
+		buf.WriteString("<li>")
+		title := template.HTMLEscapeString(h.Title)
+		if h.ID != "" {
+			fmt.Fprintf(buf, `<a href="#%s">%s</a>`, template.HTMLEscapeString(h.ID), title)
+		} else {
+			buf.WriteString(title)
+		}
+		if len(h.Children) > 0 {
+			TableOfContents(h.Children).write(buf)
+		}
+		buf.WriteString("</li>")
+	}
+	buf.WriteString("</ul>")
+}
+
+// pageInfo caches what analyze derived from a source's content. LoadContent
+// replaces it whenever the content is read again.
+type pageInfo struct {
+	once      sync.Once
+	toc       TableOfContents
+	words     int
+	minutes   int
+	summary   template.HTML
+	truncated bool
+}
+
+func (s *Source) pageInfo() *pageInfo {
+	p := s.info
+	if p == nil {
+		p = &pageInfo{}
+	}
+	p.once.Do(func() { s.analyze(p) })
+	return p
+}
+
+// TableOfContents returns the page's headings between the configured
+// tocStartLevel and tocEndLevel (h2 to h3 by default).
+func (s *Source) TableOfContents() TableOfContents {
+	return s.pageInfo().toc
+}
+
+// WordCount is the number of words of the page's text, code blocks excluded.
+func (s *Source) WordCount() int {
+	return s.pageInfo().words
+}
+
+// ReadingTime is WordCount in minutes, rounded up.
+func (s *Source) ReadingTime() int {
+	return s.pageInfo().minutes
+}
+
+// Summary is the page's summary: frontmatter, else the page rendered up to a
+// <!--more--> marker, else the first summaryWords words of its text.
+func (s *Source) Summary() template.HTML {
+	return s.pageInfo().summary
+}
+
+// Truncated reports whether the page has more content than its Summary.
+func (s *Source) Truncated() bool {
+	return s.pageInfo().truncated
+}
+
+// analyze fills p from the source's markdown content.
+func (s *Source) analyze(p *pageInfo) {
+	if sum, ok := s.Meta["summary"]; ok {
+		p.summary = template.HTML(template.HTMLEscapeString(fmt.Sprint(sum)))
+		p.truncated = true
+	}
+	if s.Ext != ".md" || s.sg == nil || s.content == nil {
+		return
+	}
+	cfg, err := s.sg.markdownConfig(s)
+	if err != nil {
+		log.Println("analyze", s.Local, err)
+		return
+	}
+	md, err := s.sg.markdownFor(s)
+	if err != nil {
+		log.Println("analyze", s.Local, err)
+		return
+	}
+	// Shortcodes get the placeholders a render gives them, so heading ids
+	// match, but stand for their inner text only.
+	content := s.content
+	ph := &placeholders{}
+	if hasShortcodes(content) {
+		sc, err := parseShortcodes(string(content), s.position)
+		if err != nil {
+			return
+		}
+		sc.ph = ph
+		sc.render = func(t scTag, inner string, paired bool) (string, error) {
+			if t.literal != "" {
+				return t.literal, nil
+			}
+			return string(ph.resolve([]byte(inner))), nil
+		}
+		expanded, err := sc.expand()
+		if err != nil {
+			return
+		}
+		content = []byte(expanded)
+	}
+	more := bytes.Index(content, []byte(moreMarker))
+	if more >= 0 {
+		content = bytes.Replace(content, []byte(moreMarker), nil, 1)
+	}
+	pc := parseContext()
+	pc.Set(refSourceKey, s)
+	doc := md.Parser().Parse(text.NewReader(content), parser.WithContext(pc))
+
+	start, end := cfg.tocLevels()
+	var headings []*Heading
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if h.Level >= start && h.Level <= end {
+			var title bytes.Buffer
+			writeText(&title, h, content)
+			entry := &Heading{Title: strings.TrimSpace(string(ph.resolve(title.Bytes()))), Level: h.Level}
+			if id, ok := h.AttributeString("id"); ok {
+				if b, ok := id.([]byte); ok {
+					entry.ID = string(b)
+				}
+			}
+			headings = append(headings, entry)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	p.toc = nestHeadings(headings)
+
+	var plain bytes.Buffer
+	writeText(&plain, doc, content)
+	words := strings.Fields(string(ph.resolve(plain.Bytes())))
+	p.words = len(words)
+	wpm := cfg.WordsPerMinute
+	if wpm <= 0 {
+		wpm = 200
+	}
+	p.minutes = (p.words + wpm - 1) / wpm
+
+	if p.summary != "" {
+		return
+	}
+	if more >= 0 {
+		var buf bytes.Buffer
+		pc := parseContext()
+		pc.Set(refSourceKey, s)
+		if err := md.Convert(content[:more], &buf, parser.WithContext(pc)); err != nil {
+			log.Println("summary", s.Local, err)
+			return
+		}
+		p.summary = template.HTML(bytes.TrimSpace(ph.resolve(buf.Bytes())))
+		p.truncated = len(bytes.TrimSpace(content[more:])) > 0
+		return
+	}
+	n := cfg.SummaryWords
+	if n <= 0 {
+		n = 70
+	}
+	if len(words) > n {
+		words = words[:n]
+		p.truncated = true
+	}
+	p.summary = template.HTML(template.HTMLEscapeString(strings.Join(words, " ")))
+}
+
+// tocLevels are the heading levels included in tables of contents.
+func (c MarkdownConfig) tocLevels() (int, int) {
+	start, end := c.TOCStartLevel, c.TOCEndLevel
+	if start <= 0 {
+		start = 2
+	}
+	if end <= 0 {
+		end = 3
+	}
+	return start, end
+}
+
+// nestHeadings turns a flat heading list into a tree; a heading nests under
+// the closest preceding heading of a lower level.
+func nestHeadings(headings []*Heading) TableOfContents {
+	var (
+		toc   TableOfContents
+		stack []*Heading
+	)
+	for _, h := range headings {
+		for len(stack) > 0 && stack[len(stack)-1].Level >= h.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			toc = append(toc, h)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, h)
+		}
+		stack = append(stack, h)
+	}
+	return toc
+}
+
+// writeText writes the plain text of n, leaving out code blocks and raw HTML.
+// Blocks are separated by a space.
+func writeText(buf *bytes.Buffer, n ast.Node, src []byte) {
+	switch n := n.(type) {
+	case *ast.FencedCodeBlock, *ast.CodeBlock, *ast.HTMLBlock, *ast.RawHTML:
+		return
+	case *ast.Text:
+		buf.Write(n.Segment.Value(src))
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			buf.WriteByte(' ')
+		}
+		return
+	case *ast.String:
+		buf.Write(n.Value)
+		return
+	case *ast.AutoLink:
+		buf.Write(n.Label(src))
+		return
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		writeText(buf, c, src)
+	}
+	if n.Type() == ast.TypeBlock {
+		buf.WriteByte(' ')
+	}
+}
@@ -0,0 +1,85 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// renderTOC builds page with a layout printing tpl instead of the content.
+func renderTOC(t *testing.T, tpl, page string) string {
+	t.Helper()
+	dir := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "toc.html"), []byte(tpl), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "page.md"), []byte("---\ntemplate: toc.html\n"+page), 0644)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestTableOfContents(t *testing.T) {
+	got := renderTOC(t, `{{.TableOfContents.HTML}}`, "markdown: {headingIDs: true}\n---\n"+
+		"# Title\n\n## Install & run\n\n### Linux\n\n#### Deep\n\n### Mac\n\n## Usage\n")
+	want := `<nav id="TableOfContents"><ul>` +
+		`<li><a href="#install--run">Install &amp; run</a><ul>` +
+		`<li><a href="#linux">Linux</a></li><li><a href="#mac">Mac</a></li></ul></li>` +
+		`<li><a href="#usage">Usage</a></li></ul></nav>`
+	if got != want {
+		t.Errorf("toc = %s\nwant %s", got, want)
+	}
+
+	deep := renderTOC(t, `{{range .TableOfContents}}{{.Title}}:{{len .Children}}{{end}}`,
+		"markdown: {tocStartLevel: 1, tocEndLevel: 4}\n---\n# A\n\n## B\n\n#### C\n\n## D\n")
+	if deep != "A:2" {
+		t.Errorf("configured depth toc = %q", deep)
+	}
+}
+
+func TestWordCountAndSummary(t *testing.T) {
+	dir := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src", "blog"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "blog", "long.md"), []byte("---\ntitle: Long\n---\n"+
+		"# Heading\n\n"+strings.Repeat("word ", 400)+"\n\n```go\nnot counted at all\n```\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "blog", "more.md"), []byte("---\ntitle: More\n---\n"+
+		"Intro with **bold** text.\n\n<!--more-->\n\nThe rest.\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "blog", "fm.md"), []byte("---\ntitle: FM\nsummary: Given <here>\n---\nBody.\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "list.html"), []byte(
+		`{{range sort "Meta.title" "asc" (sources "Path" "/blog/*")}}[{{.Meta.title}}|{{.WordCount}}|{{.ReadingTime}}|{{.Truncated}}|{{.Summary}}]{{end}}`), 0644)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, Markdown: &MarkdownConfig{SummaryWords: 3}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "list", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"[FM|1|1|true|Given &lt;here&gt;]",
+		"[Long|401|3|true|Heading word word]",
+		"[More|6|1|true|<p>Intro with <strong>bold</strong> text.</p>]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	page, err := os.ReadFile(filepath.Join(pub, "blog", "more", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(page), "more") || strings.Contains(string(page), "omitted") {
+		t.Errorf("summary marker left in page:\n%s", page)
+	}
+}
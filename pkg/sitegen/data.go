@@ -0,0 +1,168 @@
+package sitegen
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// data.go reads the files of the data dir: JSON, YAML, TOML and CSV, the
+// latter as a list of maps keyed by the header row. They are loaded one at a
+// time by the data func and all together, once per build, as the .Site.Data
+// tree, where a file is keyed by its name without extension and a directory
+// becomes a nested map. The data func serves the files of that build.
+
+// dataExts are the extensions of the data file formats.
+var dataExts = map[string]bool{".json": true, ".yaml": true, ".yml": true, ".toml": true, ".csv": true}
+
+// dataPath is the data dir; a page whose templates read .Site.Data depends on
+// it as a whole.
+func (sg *SiteGen) dataPath() string {
+	return filepath.Join(sg.SitePath, sg.DataDir)
+}
+
+// loadData reads and decodes the data file name, relative to the data dir.
+func (sg *SiteGen) loadData(name string) (interface{}, error) {
+	path := filepath.Join(sg.dataPath(), name)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := decodeData(fileExt(path), b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return d, nil
+}
+
+// dataFile returns the data file name as decoded for the current build,
+// reading it only when no build loaded it, such as before the first build or
+// when it failed to decode.
+func (sg *SiteGen) dataFile(name string) (interface{}, error) {
+	if d, ok := sg.dataFiles[filepath.ToSlash(filepath.Clean(name))]; ok {
+		return d, nil
+	}
+	return sg.loadData(name)
+}
+
+// decodeData decodes data in the format of the file extension ext.
+func decodeData(ext string, b []byte) (interface{}, error) {
+	var d interface{}
+	switch ext {
+	case ".json":
+		if err := json.Unmarshal(b, &d); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &d); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		m := map[string]interface{}{}
+		if err := toml.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		d = m
+	case ".csv":
+		return decodeCSV(b)
+	default:
+		return nil, fmt.Errorf("unsupported data format %q", ext)
+	}
+	return d, nil
+}
+
+// decodeCSV decodes CSV into one map per row, keyed by the header row.
+func decodeCSV(b []byte) (interface{}, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(b, []byte("\ufeff"))))
+	header, err := r.Read()
+	if err == io.EOF {
+		return []interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows := []interface{}{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, h := range header {
+			row[strings.TrimSpace(h)] = rec[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Data is the tree of the data dir's files. Reading it makes the page being
+// rendered depend on every data file.
+func (s Site) Data() map[string]interface{} {
+	if s.page != nil && s.page.deps != nil {
+		s.page.deps[s.page.sg.dataPath()] = true
+	}
+	return s.data
+}
+
+// pageSite is the .Site of the renders of s.
+func (sg *SiteGen) pageSite(s *Source) Site {
+	site := sg.Site
+	site.page = s
+	return site
+}
+
+// loadDataTree reads every data file into the .Site.Data tree, and returns
+// the decoded files by slash path too. Files that fail to decode are logged
+// and left out.
+func (sg *SiteGen) loadDataTree() (map[string]interface{}, map[string]interface{}) {
+	tree := map[string]interface{}{}
+	files := map[string]interface{}{}
+	root := sg.dataPath()
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		ext := fileExt(path)
+		if !dataExts[ext] {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err == nil {
+			var d interface{}
+			if d, err = decodeData(ext, b); err == nil {
+				files[filepath.ToSlash(rel)] = d
+				parts := strings.Split(filepath.ToSlash(strings.TrimSuffix(rel, ext)), "/")
+				m := tree
+				for _, dir := range parts[:len(parts)-1] {
+					sub, ok := m[dir].(map[string]interface{})
+					if !ok {
+						sub = map[string]interface{}{}
+						m[dir] = sub
+					}
+					m = sub
+				}
+				m[parts[len(parts)-1]] = d
+				return nil
+			}
+		}
+		log.Println("data", path, err)
+		return nil
+	})
+	return tree, files
+}
@@ -0,0 +1,118 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeData(t *testing.T) {
+	for _, c := range []struct {
+		ext, in string
+		want    interface{}
+	}{
+		{".json", `{"a":[1,"x"]}`, map[string]interface{}{"a": []interface{}{1.0, "x"}}},
+		{".yaml", "a:\n  - 1\n  - x\n", map[string]interface{}{"a": []interface{}{1, "x"}}},
+		{".yml", "- name: A\n", []interface{}{map[string]interface{}{"name": "A"}}},
+		{".toml", "title = \"T\"\n[owner]\nname = \"O\"\n", map[string]interface{}{"title": "T", "owner": map[string]interface{}{"name": "O"}}},
+		{".csv", "\ufeffplan, price\nBasic,5\n\"Pro, yearly\",50\n", []interface{}{
+			map[string]interface{}{"plan": "Basic", "price": "5"},
+			map[string]interface{}{"plan": "Pro, yearly", "price": "50"},
+		}},
+		{".csv", "", []interface{}{}},
+	} {
+		got, err := decodeData(c.ext, []byte(c.in))
+		if err != nil {
+			t.Errorf("%s %q: %v", c.ext, c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %q = %#v, want %#v", c.ext, c.in, got, c.want)
+		}
+	}
+	if _, err := decodeData(".csv", []byte("a,b\n1\n")); err == nil {
+		t.Error("ragged csv decoded")
+	}
+	if _, err := decodeData(".xml", nil); err == nil {
+		t.Error("unsupported format decoded")
+	}
+}
+
+func TestSiteData(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/main.html": `{{template "content" .}}|{{.Site.Data.team.lead.name}}`,
+		"data/pricing.csv":    "plan,price\nBasic,5\nPro,50\n",
+		"data/config.yaml":    "support: help@example.com\n",
+		"data/team/lead.toml": "name = \"Ada\"\n",
+		"data/broken.json":    "{",
+		"src/pricing.html": "---\ntemplate: main.html\n---\n" +
+			`{{define "content"}}{{range .Site.Data.pricing}}{{.plan}}={{.price}} {{end}}{{(data "config.yaml").support}}{{end}}`,
+		"src/plain.html": `plain`,
+		// A partial given .Site reads the data too.
+		"templates/lead.html": `{{define "lead"}}{{.Data.team.lead.name}}{{end}}`,
+		"src/team.html":       `{{template "lead" .Site}}`,
+	})
+	pub := t.TempDir()
+
+	build := func() {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Incremental: true})
+		if _, err := sg.BuildAll(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(rel string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(pub, rel, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	build()
+	if got := read("pricing"); got != "Basic=5 Pro=50 help@example.com|Ada" {
+		t.Errorf("pricing = %q", got)
+	}
+
+	// The manifest notices any data file changing.
+	writeFiles(t, site, map[string]string{"data/team/lead.toml": "name = \"Grace\"\n"})
+	build()
+	if got := read("pricing"); !strings.HasSuffix(got, "|Grace") {
+		t.Errorf("pricing after data edit = %q", got)
+	}
+
+	// So does the watcher, through BuildAffected.
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, site, map[string]string{"data/pricing.csv": "plan,price\nFree,0\n"})
+	n, err := sg.BuildAffected(filepath.Join(site, "data", "pricing.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("rebuilt %d sources, want only the pages reading .Site.Data", n)
+	}
+	if got := read("pricing"); got != "Free=0 help@example.com|Grace" {
+		t.Errorf("pricing after watcher rebuild = %q", got)
+	}
+	if got := read("team"); got != "Grace" {
+		t.Errorf("team after watcher rebuild = %q", got)
+	}
+
+	// The data func serves the files loaded for the build rather than
+	// reading them again.
+	writeFiles(t, site, map[string]string{"data/config.yaml": "support: new@example.com\n"})
+	if got := sg.Data("config.yaml").(map[string]interface{})["support"]; got != "help@example.com" {
+		t.Errorf("data during build = %v, want the build's copy", got)
+	}
+	if _, err := sg.BuildAffected(filepath.Join(site, "data", "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if got := sg.Data("config.yaml").(map[string]interface{})["support"]; got != "new@example.com" {
+		t.Errorf("data after reload = %v", got)
+	}
+}
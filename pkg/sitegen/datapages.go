@@ -0,0 +1,195 @@
+package sitegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// datapages.go renders one page per item of a data file. A source declares
+//
+//	pages:
+//	  data: products.json
+//	  path: /products/{{.slug}}
+//
+// in its frontmatter and, instead of a page of its own, gets a virtual source
+// per item at the path the pattern gives for it, rendered from its content
+// with the item as .Item. Generated pages list, paginate and cache like any
+// other page; they are recreated whenever the source or the data changes.
+
+// DataPages is the pages: frontmatter of a source generating pages from data.
+type DataPages struct {
+	// Data is the data file holding the list of items, relative to the data
+	// dir.
+	Data string `yaml:"data"`
+	// Path is a template giving each item's page path, e.g. /products/{{.slug}}.
+	Path string `yaml:"path"`
+}
+
+// dataPagesConfig parses the pages: frontmatter of s.
+func (sg *SiteGen) dataPagesConfig(s *Source) (DataPages, error) {
+	var cfg DataPages
+	b, err := yaml.Marshal(s.Meta["pages"])
+	if err == nil {
+		err = yaml.UnmarshalStrict(b, &cfg)
+	}
+	if err == nil && (cfg.Data == "" || cfg.Path == "") {
+		err = fmt.Errorf("data and path are required")
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("%s: pages frontmatter: %w", s.Local, err)
+	}
+	cfg.Data = strings.TrimPrefix(filepath.ToSlash(cfg.Data), filepath.ToSlash(sg.DataDir)+"/")
+	return cfg, nil
+}
+
+// isPageTemplate reports whether s only renders the pages generated from its
+// pages: data rather than a page of its own.
+func (s *Source) isPageTemplate() bool {
+	_, ok := s.Meta["pages"]
+	return ok && s.pageOf == nil
+}
+
+// Item is the data item a generated page was rendered for (see datapages.go),
+// nil for other sources.
+func (s *Source) Item() interface{} {
+	return s.item
+}
+
+// syncDataPages replaces the pages generated from data to match the current
+// sources and data files. A page backed by a file wins over a generated one at
+// the same path. Pages that disappeared are removed from the public dir. It
+// returns the keys of the pages that are new or changed. The caller must hold
+// sg.Mu and no build may be running.
+func (sg *SiteGen) syncDataPages() []string {
+	oldHash := map[string]string{}
+	for k, s := range sg.sources {
+		if s.pageOf != nil {
+			oldHash[k] = s.hash
+			delete(sg.sources, k)
+		}
+	}
+	old := sg.dataPages
+	sg.dataPages = map[string]string{}
+
+	taken := map[string]bool{}
+	var keys []string
+	for k, s := range sg.sources {
+		if s.isPageTemplate() {
+			keys = append(keys, k)
+		} else {
+			taken[s.Path] = true
+		}
+	}
+	sort.Strings(keys)
+	var changed []string
+	for _, k := range keys {
+		s := sg.sources[k]
+		s.pagesErr = nil
+		if s.Err != nil || !sg.Published(s) {
+			continue
+		}
+		pages, err := sg.dataPageSources(s)
+		if err != nil {
+			s.pagesErr = err
+			continue
+		}
+		for _, p := range pages {
+			if taken[p.Path] {
+				log.Println("pages", sg.rel(s.Local), "skipped", p.Path+": a page is built there")
+				continue
+			}
+			key := s.Local + "#" + p.Meta["path"].(string)
+			sg.sources[key] = p
+			sg.dataPages[key] = sg.sourcePath(p)
+			if oldHash[key] != p.hash {
+				changed = append(changed, key)
+			}
+		}
+	}
+	sort.Strings(changed)
+	for k, out := range old {
+		if _, ok := sg.dataPages[k]; !ok {
+			removeOutput(out)
+		}
+	}
+	return changed
+}
+
+// dataPageSources creates the pages of the page template s, one per item of
+// its data file.
+func (sg *SiteGen) dataPageSources(s *Source) ([]*Source, error) {
+	cfg, err := sg.dataPagesConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	d, err := sg.dataFile(cfg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: pages: %w", s.Local, err)
+	}
+	items, ok := d.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: pages: %s is not a list", s.Local, cfg.Data)
+	}
+	pathTpl, err := texttemplate.New("path").Option("missingkey=error").Funcs(sg.tplFuncs()).Parse(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: pages: path: %w", s.Local, err)
+	}
+	sum := sha256.Sum256(s.content)
+	base := fmt.Sprintf("%x %v", sum, s.Meta)
+
+	var pages []*Source
+	seen := map[string]int{}
+	for i, item := range items {
+		var buf bytes.Buffer
+		if err := pathTpl.Execute(&buf, item); err != nil {
+			return nil, fmt.Errorf("%s: pages: item %d: path: %w", s.Local, i, err)
+		}
+		p := strings.Trim(strings.TrimSpace(buf.String()), "/")
+		if p == "" {
+			return nil, fmt.Errorf("%s: pages: item %d: empty path", s.Local, i)
+		}
+		if j, ok := seen[p]; ok {
+			return nil, fmt.Errorf("%s: pages: items %d and %d both have path %s", s.Local, j, i, p)
+		}
+		seen[p] = i
+		meta := make(map[string]interface{}, len(s.Meta))
+		for k, v := range s.Meta {
+			meta[k] = v
+		}
+		delete(meta, "pages")
+		meta["path"] = p
+		js, _ := json.Marshal(item)
+		h := sha256.Sum256([]byte(base + "\n" + p + "\n" + string(js)))
+		page := &Source{
+			Name:       path.Base(p) + s.Ext,
+			Local:      s.Local,
+			Meta:       meta,
+			Ext:        s.Ext,
+			Ctype:      s.Ctype,
+			content:    s.content,
+			sg:         sg,
+			Lang:       s.Lang,
+			langSuffix: s.langSuffix,
+			lineOffset: s.lineOffset,
+			info:       &pageInfo{},
+			virtual:    true,
+			hash:       hex.EncodeToString(h[:]),
+			item:       item,
+			pageOf:     s,
+		}
+		page.Path = sg.LocalToPath(page)
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
@@ -0,0 +1,117 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newDataPagesSite(t *testing.T) (string, string) {
+	t.Helper()
+	site := writeSite(t, map[string]string{
+		"templates/main.html": `<html>{{template "content" .}}</html>`,
+		"data/products.json":  `[{"slug":"widget","name":"Widget"},{"slug":"gadget","name":"Gadget"}]`,
+		"src/product.html": "---\ntemplate: main.html\npages:\n  data: data/products.json\n  path: /products/{{.slug}}\n---\n" +
+			`{{define "content"}}<h1>{{.Item.name}}</h1>{{end}}`,
+		"src/sitemap.xml": "---\nparse: text\n---\n" +
+			`{{range sort "Path" "asc" (sources "Local" "**/src/**.{html,md}")}}{{.Path}} {{end}}`,
+	})
+	pub := t.TempDir()
+	return site, pub
+}
+
+func TestDataPages(t *testing.T) {
+	site, pub := newDataPagesSite(t)
+	build := func() map[string]int {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Incremental: true})
+		stats, err := sg.BuildAll(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+	read := func(rel string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(pub, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	build()
+	if got := read("products/widget/index.html"); got != "<html><h1>Widget</h1></html>" {
+		t.Errorf("widget page = %q", got)
+	}
+	if got := read("products/gadget/index.html"); got != "<html><h1>Gadget</h1></html>" {
+		t.Errorf("gadget page = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "product")); !os.IsNotExist(err) {
+		t.Errorf("page template built a page of its own: %v", err)
+	}
+	if got := strings.TrimSpace(read("sitemap.xml")); got != "/products/gadget /products/widget" {
+		t.Errorf("sitemap = %q", got)
+	}
+
+	if stats := build(); stats["cached"] != 4 {
+		t.Errorf("unchanged rebuild stats = %v", stats)
+	}
+
+	// Editing the data rebuilds what changed and drops removed items.
+	writeFiles(t, site, map[string]string{"data/products.json": `[{"slug":"widget","name":"Widget 2"}]`})
+	build()
+	if got := read("products/widget/index.html"); got != "<html><h1>Widget 2</h1></html>" {
+		t.Errorf("widget page after edit = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "products", "gadget", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("removed item still built: %v", err)
+	}
+	if got := strings.TrimSpace(read("sitemap.xml")); got != "/products/widget" {
+		t.Errorf("sitemap after edit = %q", got)
+	}
+}
+
+func TestDataPagesFollowDataEdits(t *testing.T) {
+	site, pub := newDataPagesSite(t)
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, site, map[string]string{"data/products.json": `[{"slug":"widget","name":"Widget"},{"slug":"doohickey","name":"Doohickey"}]`})
+	if _, err := sg.BuildAffected(filepath.Join(site, "data", "products.json")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(pub, "products", "doohickey", "index.html")); err != nil || !strings.Contains(string(b), "Doohickey") {
+		t.Errorf("new item page = %s, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "products", "gadget", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("removed item still built: %v", err)
+	}
+}
+
+func TestDataPagesErrors(t *testing.T) {
+	for name, c := range map[string]struct{ data, path, want string }{
+		"not a list":     {`{"a":1}`, "/p/{{.a}}", "products.json is not a list"},
+		"duplicate path": {`[{"a":1},{"a":1}]`, "/p/{{.a}}", "items 0 and 1 both have path p/1"},
+		"missing key":    {`[{"a":1}]`, "/p/{{.b}}", `item 0: path: template: path:1:5: executing "path" at <.b>: map has no entry for key "b"`},
+		"empty path":     {`[{"a":""}]`, "/{{.a}}/", "item 0: empty path"},
+		"missing file":   {"", "/p", "no such file"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			site, pub := newDataPagesSite(t)
+			if c.data == "" {
+				os.Remove(filepath.Join(site, "data", "products.json"))
+			} else {
+				writeFiles(t, site, map[string]string{"data/products.json": c.data})
+			}
+			writeFiles(t, site, map[string]string{"src/product.html": "---\npages: {data: products.json, path: \"" + c.path + "\"}\n---\nx"})
+			sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+			_, err := sg.BuildAll(false)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("error = %v, want %q", err, c.want)
+			}
+		})
+	}
+}
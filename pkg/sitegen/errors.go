@@ -0,0 +1,312 @@
+package sitegen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errors.go turns the error of a failed build into a BuildError pointing at
+// the file the mistake is in. Go template errors name the template and the
+// line in the text it was parsed from: for a page that is its content, which
+// is put back at its line in the file below the frontmatter, and for Markdown
+// the generated HTML, where the failing action is found again in the
+// Markdown. Errors in layouts, partials, shortcodes and render hooks point at
+// the template file instead.
+
+// BuildError is the error of a source that failed to build, located in the
+// file the error is in.
+type BuildError struct {
+	// Source is the path of the source that failed.
+	Source string
+	// File is the file the error is in, relative to the site, and Line and
+	// Column the 1-based position in it, 0 when unknown.
+	File   string
+	Line   int
+	Column int
+	// Template is the name of the template that failed, if any.
+	Template string
+	// Message is the error without its location.
+	Message string
+	// Excerpt holds the lines of File around Line.
+	Excerpt []ExcerptLine
+	Err     error
+}
+
+// ExcerptLine is one line of a BuildError's excerpt; Error marks the line
+// the error is on.
+type ExcerptLine struct {
+	Number int
+	Text   string
+	Error  bool
+}
+
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// Location formats the file, line and column of e as far as known.
+func (e *BuildError) Location() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			loc += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	return loc
+}
+
+// Snippet formats the excerpt with line numbers and a caret under the
+// column of the error.
+func (e *BuildError) Snippet() string {
+	if len(e.Excerpt) == 0 {
+		return ""
+	}
+	width := len(strconv.Itoa(e.Excerpt[len(e.Excerpt)-1].Number))
+	var b strings.Builder
+	for _, l := range e.Excerpt {
+		mark := " "
+		if l.Error {
+			mark = ">"
+		}
+		fmt.Fprintf(&b, "%s %*d | %s\n", mark, width, l.Number, l.Text)
+		if l.Error && e.Column > 0 {
+			// Keep the tabs before the column so the caret lines up.
+			pad := []rune{}
+			for i, r := range l.Text {
+				if i >= e.Column-1 {
+					break
+				}
+				if r == '\t' {
+					pad = append(pad, '\t')
+				} else {
+					pad = append(pad, ' ')
+				}
+			}
+			fmt.Fprintf(&b, "  %*s | %s^\n", width, "", string(pad))
+		}
+	}
+	return b.String()
+}
+
+// BuildErrors returns the build errors err is made of, as returned by Build,
+// BuildAll or BuildAffected. Errors not located in a file are returned with
+// only their message.
+func BuildErrors(err error) []*BuildError {
+	if err == nil {
+		return nil
+	}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []*BuildError
+		for _, e := range j.Unwrap() {
+			out = append(out, BuildErrors(e)...)
+		}
+		return out
+	}
+	var be *BuildError
+	if errors.As(err, &be) {
+		return []*BuildError{be}
+	}
+	return []*BuildError{{Message: err.Error(), Err: err}}
+}
+
+// Errors returns the errors of the sources whose last build failed, sorted
+// by source. The caller must hold sg.Mu.
+func (sg *SiteGen) Errors() []*BuildError {
+	var out []*BuildError
+	for _, s := range sg.sources {
+		if s.failed && s.buildErr != nil {
+			out = append(out, s.buildErr)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Source != out[j].Source {
+			return out[i].Source < out[j].Source
+		}
+		return out[i].Message < out[j].Message
+	})
+	return out
+}
+
+// renderError is a failed template parse or execute of a source's content,
+// carrying the text that was parsed as the source.
+type renderError struct {
+	err  error
+	text []byte
+}
+
+func (e *renderError) Error() string {
+	return e.err.Error()
+}
+
+func (e *renderError) Unwrap() error {
+	return e.err
+}
+
+var (
+	// templateErrRe matches the location text/template and html/template
+	// put in their errors: the template's parse name, line and column.
+	templateErrRe = regexp.MustCompile(`(?:html/)?template: ?([^\s:]+):(\d+)(?::(\d+))?: `)
+	// yamlErrRe matches the line of a frontmatter error.
+	yamlErrRe = regexp.MustCompile(`yaml: line (\d+): `)
+)
+
+// locate makes err, the error of building s, a BuildError. It uses the last
+// location in the message, which is the innermost: a shortcode's template
+// error is reported in the shortcode rather than the page calling it.
+func (sg *SiteGen) locate(s *Source, err error) *BuildError {
+	var be *BuildError
+	if errors.As(err, &be) {
+		return be
+	}
+	msg := err.Error()
+	be = &BuildError{Source: sg.rel(s.Local), File: sg.rel(s.Local), Message: msg, Err: err}
+	local := s.Local
+	at := -1
+	if m := templateErrRe.FindAllStringSubmatchIndex(msg, -1); m != nil {
+		last := m[len(m)-1]
+		at = last[0]
+		name := msg[last[2]:last[3]]
+		line, _ := strconv.Atoi(msg[last[4]:last[5]])
+		col := -1
+		if last[6] >= 0 {
+			col, _ = strconv.Atoi(msg[last[6]:last[7]])
+		}
+		be.Template = name
+		be.Message = msg[last[1]:]
+		if name == "base" {
+			be.Line, be.Column = sg.contentPosition(s, err, line, col)
+		} else if f := filepath.Join(sg.SitePath, sg.TemplateDir, filepath.FromSlash(name)); isFile(f) {
+			local = f
+			be.File = sg.rel(f)
+			be.Line, be.Column = line, col+1
+		}
+	}
+	// Shortcodes and references report their position in the source.
+	if i := strings.LastIndex(msg, s.Local+":"); i > at {
+		rest := msg[i+len(s.Local)+1:]
+		if j := strings.Index(rest, ": "); j > 0 {
+			if line, err := strconv.Atoi(rest[:j]); err == nil {
+				local = s.Local
+				be.File = sg.rel(s.Local)
+				be.Template = ""
+				be.Line, be.Column = line, 0
+				be.Message = rest[j+2:]
+			}
+		}
+	}
+	if s.Err != nil && errors.Is(err, s.Err) {
+		if m := yamlErrRe.FindStringSubmatch(msg); m != nil {
+			// Frontmatter lines are counted from the opening ---.
+			be.Line, _ = strconv.Atoi(m[1])
+		}
+		be.Message = strings.TrimPrefix(be.Message, s.Local+": ")
+	}
+	if be.Line > 0 {
+		be.Excerpt = excerpt(local, be.Line, 2)
+	}
+	return be
+}
+
+// contentPosition maps line and column col (0-based, -1 when unknown) of the
+// text parsed for s to its 1-based position in the source file.
+func (sg *SiteGen) contentPosition(s *Source, err error, line, col int) (int, int) {
+	var re *renderError
+	if errors.As(err, &re) && !bytes.Equal(re.text, s.content) {
+		var ok bool
+		if line, col, ok = actionPosition(re.text, s.content, line, col); !ok {
+			return 0, 0
+		}
+	}
+	if col < 0 {
+		return s.lineOffset + line, 0
+	}
+	// Content starts on the line closing the frontmatter, after the ---.
+	if line == 1 && s.lineOffset > 0 {
+		col += len("---")
+	}
+	return s.lineOffset + line, col + 1
+}
+
+var actionRe = regexp.MustCompile(`\{\{.*?\}\}`)
+
+// actionPosition finds the template action at line and col of generated,
+// text produced from content such as the HTML of a Markdown page, again in
+// content, and returns its line and column there.
+func actionPosition(generated, content []byte, line, col int) (int, int, bool) {
+	lines := bytes.SplitAfter(generated, []byte("\n"))
+	if line < 1 || line > len(lines) {
+		return 0, 0, false
+	}
+	start := 0
+	for _, l := range lines[:line-1] {
+		start += len(l)
+	}
+	acts := actionRe.FindAllIndex(lines[line-1], -1)
+	if len(acts) == 0 {
+		return 0, 0, false
+	}
+	act := acts[0]
+	for _, a := range acts {
+		if col >= a[0] && col < a[1] {
+			act = a
+			break
+		}
+	}
+	text := lines[line-1][act[0]:act[1]]
+	// The nth same action in generated is the nth in content.
+	n := bytes.Count(generated[:start+act[0]], text)
+	want := []byte(html.UnescapeString(string(text)))
+	off := -1
+	for i, from := 0, 0; i <= n; i++ {
+		j := bytes.Index(content[from:], want)
+		if j < 0 {
+			break
+		}
+		off = from + j
+		from = off + len(want)
+	}
+	if off < 0 {
+		return 0, 0, false
+	}
+	l := 1 + bytes.Count(content[:off], []byte("\n"))
+	c := off - (bytes.LastIndexByte(content[:off], '\n') + 1)
+	if col >= act[0] {
+		c += min(col-act[0], len(want)-1)
+	}
+	return l, c, true
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
+
+// excerpt reads the lines of file around line, context lines either side.
+func excerpt(file string, line, context int) []ExcerptLine {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if line > len(lines) {
+		return nil
+	}
+	var out []ExcerptLine
+	for n := max(1, line-context); n <= min(len(lines), line+context); n++ {
+		out = append(out, ExcerptLine{Number: n, Text: strings.TrimSuffix(lines[n-1], "\r"), Error: n == line})
+	}
+	return out
+}
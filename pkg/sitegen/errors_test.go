@@ -0,0 +1,74 @@
+package sitegen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildErrors(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/main.html":           "<main>\n{{template \"content\" .}}\n{{.title.Nope}}\n</main>",
+		"templates/page.html":           `<main>{{template "content" .}}</main>`,
+		"templates/shortcodes/bad.html": "<b>\n  {{index .Params 5}}</b>",
+		"src/layout.html":               "---\ntitle: x\ntemplate: main.html\n---\n{{define \"content\"}}ok{{end}}",
+		"src/exec.html":                 "---\ntitle: x\n---\n<p>one</p>\n<p>{{.title.Missing}}</p>\n",
+		"src/parse.html":                "<p>\n{{if .title}}\n</p>\n",
+		"src/post.md":                   "---\ntitle: x\ntemplate: page.html\n---\n# Post\n\nSome *text*.\n\nThe {{.title.Missing}} here.\n",
+		"src/code.md":                   "---\ntemplate: page.html\n---\nfirst\n\n{{< bad >}}\n",
+		"src/meta.md":                   "---\ntitle: [x\n---\nbody\n",
+		"src/fine.html":                 "<p>fine</p>",
+	})
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	_, err := sg.BuildAll(false)
+	if err == nil {
+		t.Fatal("want build errors")
+	}
+
+	got := map[string]*BuildError{}
+	for _, e := range BuildErrors(err) {
+		got[e.Source] = e
+	}
+	for _, tc := range []struct {
+		source, file string
+		line, col    int
+		tpl, msg     string
+	}{
+		{"src/layout.html", "templates/main.html", 3, 9, "main.html", "can't evaluate field Nope"},
+		{"src/exec.html", "src/exec.html", 5, 12, "base", "can't evaluate field Missing"},
+		{"src/parse.html", "src/parse.html", 4, 0, "base", "unexpected EOF"},
+		{"src/post.md", "src/post.md", 9, 13, "base", "can't evaluate field Missing"},
+		{"src/code.md", "templates/shortcodes/bad.html", 2, 5, "shortcodes/bad.html", "index"},
+		{"src/meta.md", "src/meta.md", 2, 0, "", "frontmatter error"},
+	} {
+		e := got[tc.source]
+		if e == nil {
+			t.Errorf("%s: no error in %v", tc.source, err)
+			continue
+		}
+		if e.File != tc.file || e.Line != tc.line || e.Column != tc.col || e.Template != tc.tpl || !strings.Contains(e.Message, tc.msg) {
+			t.Errorf("%s: got %s (%s) %q, want %s:%d:%d (%s) %q", tc.source, e.Location(), e.Template, e.Message, tc.file, tc.line, tc.col, tc.tpl, tc.msg)
+		}
+	}
+	if len(got) != 6 {
+		t.Errorf("got %d errors, want 6: %v", len(got), err)
+	}
+	if len(sg.Errors()) != 6 {
+		t.Errorf("Errors() = %d, want 6", len(sg.Errors()))
+	}
+
+	want := "  3 | ---\n  4 | <p>one</p>\n> 5 | <p>{{.title.Missing}}</p>\n    |            ^\n"
+	if s := got["src/exec.html"].Snippet(); s != want {
+		t.Errorf("snippet:\n%s\nwant:\n%s", s, want)
+	}
+
+	writeFiles(t, site, map[string]string{"src/exec.html": "<p>fixed</p>"})
+	sg.sources[filepath.Join(site, "src/exec.html")].ReloadContent()
+	if err := sg.Build(filepath.Join(site, "src/exec.html")); err != nil {
+		t.Fatal(err)
+	}
+	if len(sg.Errors()) != 5 {
+		t.Errorf("Errors() after fix = %d, want 5", len(sg.Errors()))
+	}
+}
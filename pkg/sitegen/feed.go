@@ -0,0 +1,537 @@
+package sitegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feed.go generates RSS 2.0, Atom and JSON Feed documents for the sections
+// configured under "feeds" in sitegen.yaml. Each format of each section is a
+// virtual source (like taxonomy pages), so feeds build in the worker pool,
+// are rebuilt whenever content changes and are pruned by the build manifest.
+// Items are the dated pages of the section, newest first; their bodies are
+// rendered from the page's content block, without the surrounding layout.
+
+// DefaultFeedLimit is the number of items a feed carries unless configured.
+const DefaultFeedLimit = 20
+
+// feedFiles maps each feed format to the file it is written to.
+var feedFiles = map[string]string{
+	"rss":  "index.xml",
+	"atom": "atom.xml",
+	"json": "feed.json",
+}
+
+// FeedConfig configures the feeds of one section. The map key in sitegen.yaml
+// is the section path relative to the source dir ("blog"), or "/" for every
+// page of the site.
+type FeedConfig struct {
+	// Title defaults to the site title.
+	Title       string `yaml:"title" json:"title"`
+	Description string `yaml:"description" json:"description"`
+	// Limit caps the number of items (default DefaultFeedLimit); a negative
+	// limit includes every item.
+	Limit int `yaml:"limit" json:"limit"`
+	// Formats lists the feeds to write: rss, atom and/or json (default all).
+	Formats []string `yaml:"formats" json:"formats"`
+	// Path is the public directory the feeds are written to (default the
+	// section path).
+	Path string `yaml:"path" json:"path"`
+}
+
+// feed is attached to the virtual source rendering one feed format.
+type feed struct {
+	FeedConfig
+	section string
+	format  string
+}
+
+// feedItem is one entry, already resolved to absolute URLs and parsed dates.
+type feedItem struct {
+	Title   string
+	URL     string
+	Summary string
+	Content string
+	Author  string
+	Date    time.Time
+	Updated time.Time
+}
+
+// syncFeeds replaces the virtual feed sources to match FeedConfig. A real
+// source file at a feed's location takes precedence. The caller must hold
+// sg.Mu and no build may be running.
+func (sg *SiteGen) syncFeeds() {
+	for k, s := range sg.sources {
+		if s.feed != nil {
+			delete(sg.sources, k)
+		}
+	}
+	sections := make([]string, 0, len(sg.FeedConfig))
+	for sec := range sg.FeedConfig {
+		sections = append(sections, sec)
+	}
+	sort.Strings(sections)
+	for _, sec := range sections {
+		cfg := sg.FeedConfig[sec]
+		section := strings.Trim(filepath.ToSlash(sec), "/")
+		if cfg.Path == "" {
+			cfg.Path = section
+		}
+		cfg.Path = strings.Trim(cfg.Path, "/")
+		if cfg.Title == "" {
+			cfg.Title = sg.Site.Title
+		}
+		if cfg.Limit == 0 {
+			cfg.Limit = DefaultFeedLimit
+		}
+		formats := cfg.Formats
+		if len(formats) == 0 {
+			formats = []string{"rss", "atom", "json"}
+		}
+		for _, format := range formats {
+			name, ok := feedFiles[format]
+			if !ok {
+				log.Println("feed", sec, "unknown format:", format)
+				continue
+			}
+			path := name
+			if cfg.Path != "" {
+				path = cfg.Path + "/" + name
+			}
+			local := filepath.Join(sg.SitePath, sg.SourceDir, filepath.FromSlash(path))
+			if _, ok := sg.sources[local]; ok {
+				continue
+			}
+			sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%+v", section, format, cfg)))
+			s := &Source{
+				Name:    name,
+				Local:   local,
+				Ext:     fileExt(name),
+				Meta:    map[string]interface{}{"title": cfg.Title, "path": path},
+				content: []byte{},
+				sg:      sg,
+				dynamic: true,
+				virtual: true,
+				hash:    hex.EncodeToString(sum[:]),
+				feed:    &feed{FeedConfig: cfg, section: section, format: format},
+				render:  sg.feedParser,
+			}
+			if ctype := mime.TypeByExtension(s.Ext); ctype != "" {
+				s.Ctype = strings.Split(ctype, ";")[0]
+			}
+			s.Path = sg.LocalToPath(s)
+			sg.sources[local] = s
+		}
+	}
+}
+
+// AbsURL joins a root-relative path that already carries the base path (a
+// source's .Path, or the result of the path func) onto the scheme and host of
+// the configured baseURL. Without a baseURL the path is returned unchanged.
+func (sg *SiteGen) AbsURL(path string) string {
+	u, err := url.Parse(sg.Site.BaseURL)
+	if err != nil || u.Host == "" {
+		return path
+	}
+	return u.Scheme + "://" + u.Host + "/" + strings.TrimLeft(path, "/")
+}
+
+// feedSources returns the pages a feed lists: dated .html/.md sources under
+// the section that don't opt out with feed: false, newest first.
+func (sg *SiteGen) feedSources(f *feed) []*Source {
+	prefix := ""
+	if f.section != "" {
+		prefix = f.section + "/"
+	}
+	var list []*Source
+	dates := map[*Source]time.Time{}
+	for _, s := range sg.sources {
+		if s.virtual || s.Err != nil || !sg.Published(s) {
+			continue
+		}
+		switch s.Ext {
+		case ".html", ".htm", ".md":
+		default:
+			continue
+		}
+		if !strings.HasPrefix(s.Value("RelPath"), prefix) {
+			continue
+		}
+		if v, ok := s.Meta["feed"].(bool); ok && !v {
+			continue
+		}
+		// Parameterized pages only exist through the page func.
+		if strings.Contains(string(s.content), " .Path") {
+			continue
+		}
+		d, ok := metaTime(s.Meta["date"])
+		if !ok {
+			continue
+		}
+		dates[s] = d
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		di, dj := dates[list[i]], dates[list[j]]
+		if !di.Equal(dj) {
+			return di.After(dj)
+		}
+		return list[i].Path < list[j].Path
+	})
+	if f.Limit > 0 && len(list) > f.Limit {
+		list = list[:f.Limit]
+	}
+	return list
+}
+
+// renderBody renders only the content block of s, as its layout would embed
+// it. It works on a throwaway copy so it is safe while s itself is being
+// built by another worker; the templates and data it read are returned.
+func (sg *SiteGen) renderBody(s *Source) ([]byte, map[string]bool, error) {
+	sp := &Source{
+		Name:    s.Name,
+		Local:   s.Local,
+		Path:    s.Path,
+		Meta:    s.Meta,
+		Ext:     s.Ext,
+		Ctype:   s.Ctype,
+		content: s.content,
+		sg:      sg,
+		info:    s.info,
+		deps:    map[string]bool{},
+		gen:     &genQueue{},
+
+		lineOffset: s.lineOffset,
+	}
+	content := sp.content
+	if sp.Ext == ".md" {
+		var err error
+		if content, err = sg.markdownHTML(sp, content); err != nil {
+			return nil, nil, err
+		}
+	}
+	body, err := sg.execute(sp, "html", content, contentBlock(sp))
+	return bytes.TrimSpace(body), sp.deps, err
+}
+
+// feedParser renders a virtual feed source.
+func (sg *SiteGen) feedParser(s *Source) ([]byte, error) {
+	f := s.feed
+	if sg.AbsURL("/") == "/" {
+		return nil, fmt.Errorf("feed %s: baseURL is not set in the site config", s.Path)
+	}
+	var items []feedItem
+	for _, src := range sg.feedSources(f) {
+		body, deps, err := sg.renderBody(src)
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %w", s.Path, err)
+		}
+		for d := range deps {
+			s.deps[d] = true
+		}
+		// Feed readers show items away from their page.
+		base, err := url.Parse(sg.AbsURL(pageDir(src)))
+		if err != nil {
+			return nil, fmt.Errorf("feed %s: %w", s.Path, err)
+		}
+		if body, err = absoluteURLs(body, base); err != nil {
+			return nil, fmt.Errorf("feed %s: %w", s.Path, err)
+		}
+		it := feedItem{
+			Title:   fmt.Sprint(src.Meta["title"]),
+			URL:     sg.AbsURL(src.Path),
+			Content: string(body),
+		}
+		if src.Meta["title"] == nil {
+			it.Title = src.Path
+		}
+		if v, ok := src.Meta["summary"]; ok {
+			it.Summary = fmt.Sprint(v)
+		} else if v, ok := src.Meta["description"]; ok {
+			it.Summary = fmt.Sprint(v)
+		}
+		if v, ok := src.Meta["author"]; ok {
+			it.Author = fmt.Sprint(v)
+		}
+		it.Date, _ = metaTime(src.Meta["date"])
+		it.Updated = it.Date
+		if u, ok := metaTime(src.Meta["updated"]); ok {
+			it.Updated = u
+		}
+		items = append(items, it)
+	}
+
+	var (
+		b     []byte
+		err   error
+		ctype string
+	)
+	switch f.format {
+	case "rss":
+		b, err = sg.rssFeed(s, items)
+		ctype = "text/xml"
+	case "atom":
+		b, err = sg.atomFeed(s, items)
+		ctype = "text/xml"
+	case "json":
+		b, err = sg.jsonFeed(s, items)
+		ctype = "application/json"
+	}
+	if err != nil {
+		return nil, fmt.Errorf("feed %s: %w", s.Path, err)
+	}
+	if sg.Minify != nil {
+		if m, err := sg.Minify.Bytes(ctype, b); err == nil {
+			b = m
+		}
+	}
+	return b, nil
+}
+
+// feedHome is the absolute URL of the page a feed belongs to.
+func (sg *SiteGen) feedHome(f *feed) string {
+	return sg.AbsURL(sg.Path(f.section))
+}
+
+// feedAuthor is the feed-level author: params.author, else the site title.
+func (sg *SiteGen) feedAuthor() string {
+	if a, ok := sg.Site.Params["author"]; ok {
+		return fmt.Sprint(a)
+	}
+	return sg.Site.Title
+}
+
+// feedUpdated is the newest item date, or now for an empty feed.
+func feedUpdated(items []feedItem) time.Time {
+	var t time.Time
+	for _, it := range items {
+		if it.Updated.After(t) {
+			t = it.Updated
+		}
+	}
+	if t.IsZero() {
+		t = time.Now().UTC()
+	}
+	return t
+}
+
+type (
+	rssDoc struct {
+		XMLName   xml.Name   `xml:"rss"`
+		Version   string     `xml:"version,attr"`
+		AtomNS    string     `xml:"xmlns:atom,attr"`
+		ContentNS string     `xml:"xmlns:content,attr"`
+		Channel   rssChannel `xml:"channel"`
+	}
+	rssChannel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		Language      string    `xml:"language,omitempty"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Self          atomLink  `xml:"atom:link"`
+		Items         []rssItem `xml:"item"`
+	}
+	rssItem struct {
+		Title       string  `xml:"title"`
+		Link        string  `xml:"link"`
+		GUID        rssGUID `xml:"guid"`
+		PubDate     string  `xml:"pubDate"`
+		Description string  `xml:"description,omitempty"`
+		Content     string  `xml:"content:encoded,omitempty"`
+	}
+	rssGUID struct {
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	}
+)
+
+func (sg *SiteGen) rssFeed(s *Source, items []feedItem) ([]byte, error) {
+	f := s.feed
+	doc := rssDoc{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          sg.feedHome(f),
+			Description:   f.Description,
+			Language:      sg.Site.Language,
+			LastBuildDate: feedUpdated(items).Format(time.RFC1123Z),
+			Self:          atomLink{Href: sg.AbsURL(s.Path), Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if doc.Channel.Description == "" {
+		doc.Channel.Description = f.Title
+	}
+	for _, it := range items {
+		ri := rssItem{
+			Title:       it.Title,
+			Link:        it.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: it.URL},
+			PubDate:     it.Date.Format(time.RFC1123Z),
+			Description: it.Summary,
+			Content:     it.Content,
+		}
+		if ri.Description == "" {
+			ri.Description = it.Content
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+	return marshalXML(doc)
+}
+
+type (
+	atomDoc struct {
+		XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		Lang     string      `xml:"xml:lang,attr,omitempty"`
+		Title    string      `xml:"title"`
+		Subtitle string      `xml:"subtitle,omitempty"`
+		ID       string      `xml:"id"`
+		Updated  string      `xml:"updated"`
+		Links    []atomLink  `xml:"link"`
+		Author   atomAuthor  `xml:"author"`
+		Entries  []atomEntry `xml:"entry"`
+	}
+	atomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+		Type string `xml:"type,attr,omitempty"`
+	}
+	atomAuthor struct {
+		Name string `xml:"name"`
+	}
+	atomEntry struct {
+		Title     string      `xml:"title"`
+		ID        string      `xml:"id"`
+		Link      atomLink    `xml:"link"`
+		Published string      `xml:"published"`
+		Updated   string      `xml:"updated"`
+		Author    *atomAuthor `xml:"author,omitempty"`
+		Summary   *atomText   `xml:"summary,omitempty"`
+		Content   atomText    `xml:"content"`
+	}
+	atomText struct {
+		Type string `xml:"type,attr"`
+		Body string `xml:",chardata"`
+	}
+)
+
+func (sg *SiteGen) atomFeed(s *Source, items []feedItem) ([]byte, error) {
+	f := s.feed
+	self := sg.AbsURL(s.Path)
+	doc := atomDoc{
+		Lang:     sg.Site.Language,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       self,
+		Updated:  feedUpdated(items).Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: sg.feedHome(f), Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomAuthor{Name: sg.feedAuthor()},
+	}
+	for _, it := range items {
+		e := atomEntry{
+			Title:     it.Title,
+			ID:        it.URL,
+			Link:      atomLink{Href: it.URL, Rel: "alternate", Type: "text/html"},
+			Published: it.Date.Format(time.RFC3339),
+			Updated:   it.Updated.Format(time.RFC3339),
+			Content:   atomText{Type: "html", Body: it.Content},
+		}
+		if it.Author != "" {
+			e.Author = &atomAuthor{Name: it.Author}
+		}
+		if it.Summary != "" {
+			e.Summary = &atomText{Type: "text", Body: it.Summary}
+		}
+		doc.Entries = append(doc.Entries, e)
+	}
+	return marshalXML(doc)
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+type (
+	jsonFeedDoc struct {
+		Version     string         `json:"version"`
+		Title       string         `json:"title"`
+		HomePageURL string         `json:"home_page_url"`
+		FeedURL     string         `json:"feed_url"`
+		Description string         `json:"description,omitempty"`
+		Language    string         `json:"language,omitempty"`
+		Authors     []jsonAuthor   `json:"authors,omitempty"`
+		Items       []jsonFeedItem `json:"items"`
+	}
+	jsonAuthor struct {
+		Name string `json:"name"`
+	}
+	jsonFeedItem struct {
+		ID            string       `json:"id"`
+		URL           string       `json:"url"`
+		Title         string       `json:"title"`
+		ContentHTML   string       `json:"content_html"`
+		Summary       string       `json:"summary,omitempty"`
+		DatePublished string       `json:"date_published"`
+		DateModified  string       `json:"date_modified,omitempty"`
+		Authors       []jsonAuthor `json:"authors,omitempty"`
+	}
+)
+
+func (sg *SiteGen) jsonFeed(s *Source, items []feedItem) ([]byte, error) {
+	f := s.feed
+	doc := jsonFeedDoc{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: sg.feedHome(f),
+		FeedURL:     sg.AbsURL(s.Path),
+		Description: f.Description,
+		Language:    sg.Site.Language,
+		Authors:     []jsonAuthor{{Name: sg.feedAuthor()}},
+		Items:       []jsonFeedItem{},
+	}
+	for _, it := range items {
+		ji := jsonFeedItem{
+			ID:            it.URL,
+			URL:           it.URL,
+			Title:         it.Title,
+			ContentHTML:   it.Content,
+			Summary:       it.Summary,
+			DatePublished: it.Date.Format(time.RFC3339),
+		}
+		if !it.Updated.Equal(it.Date) {
+			ji.DateModified = it.Updated.Format(time.RFC3339)
+		}
+		if it.Author != "" {
+			ji.Authors = []jsonAuthor{{Name: it.Author}}
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
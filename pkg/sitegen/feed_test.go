@@ -0,0 +1,165 @@
+package sitegen
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFeedSite(t *testing.T, site Site, feeds map[string]FeedConfig) (*SiteGen, string) {
+	t.Helper()
+	dir := writeSite(t, map[string]string{
+		"templates/main.html": `<html><nav>menu</nav>{{template "content" .}}</html>`,
+		"src/blog.html": "---\ntemplate: main.html\n---\n" +
+			`{{define "content"}}{{range sources "RelPath" "blog/*"}}[{{.Name}}]{{end}}{{end}}`,
+		"src/blog/one.md": "---\ntitle: Fish & Chips <1>\ndate: 2026-01-02\nsummary: Short & sweet\ntemplate: main.html\n---\n" +
+			"Hello **world** [next](../two) ![cat](/docs/img/cat.png) [mail](mailto:a@b.c)",
+		"src/blog/two.html": "---\ntitle: Two\ndate: 2026-01-03T10:30:00Z\nauthor: Bo\ntemplate: main.html\n---\n" +
+			`{{define "content"}}<p>{{.Meta.title}} body</p>{{end}}`,
+		"src/blog/three.md":   "---\ntitle: Old\ndate: 2025-12-01\ntemplate: main.html\n---\nOld",
+		"src/blog/hidden.md":  "---\ntitle: Hidden\ndate: 2026-02-01\nfeed: false\ntemplate: main.html\n---\nNo",
+		"src/blog/undated.md": "---\ntitle: Undated\ntemplate: main.html\n---\nNo date",
+		"src/about.md":        "---\ntitle: About\ndate: 2026-03-01\ntemplate: main.html\n---\nNot a post",
+	})
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, BasePath: "/docs/", Site: site, Feeds: feeds})
+	return sg, pub
+}
+
+func TestFeeds(t *testing.T) {
+	sg, pub := newFeedSite(t,
+		Site{Title: "Site & Co", BaseURL: "https://example.com/docs/", Language: "en"},
+		map[string]FeedConfig{"blog": {Limit: 2}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	read := func(rel string) []byte {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(pub, "docs", rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	var rss struct {
+		Channel struct {
+			Title string `xml:"title"`
+			Items []struct {
+				Title       string `xml:"title"`
+				Link        string `xml:"link"`
+				PubDate     string `xml:"pubDate"`
+				Description string `xml:"description"`
+				Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	rssDoc := read("blog/index.xml")
+	if err := xml.Unmarshal(rssDoc, &rss); err != nil {
+		t.Fatalf("rss: %v", err)
+	}
+	if rss.Channel.Title != "Site & Co" || !strings.Contains(string(rssDoc), "<link>https://example.com/docs/blog</link>") {
+		t.Errorf("rss channel = %s", rssDoc)
+	}
+	// Newest first, limited to 2; feed: false, undated and out-of-section
+	// pages are left out.
+	if len(rss.Channel.Items) != 2 {
+		t.Fatalf("rss items = %+v", rss.Channel.Items)
+	}
+	two, one := rss.Channel.Items[0], rss.Channel.Items[1]
+	if two.Title != "Two" || two.Link != "https://example.com/docs/blog/two" {
+		t.Errorf("rss item 0 = %+v", two)
+	}
+	if two.PubDate != "Sat, 03 Jan 2026 10:30:00 +0000" {
+		t.Errorf("pubDate = %q", two.PubDate)
+	}
+	if _, err := time.Parse(time.RFC1123Z, one.PubDate); err != nil {
+		t.Errorf("pubDate %q: %v", one.PubDate, err)
+	}
+	if one.Title != "Fish & Chips <1>" || one.Description != "Short & sweet" {
+		t.Errorf("rss item 1 = %+v", one)
+	}
+	// Bodies are the content block only, without the layout, with URLs
+	// made absolute.
+	body := `<p>Hello <strong>world</strong> <a href="https://example.com/docs/blog/two">next</a> <img src="https://example.com/docs/img/cat.png" alt="cat"> <a href="mailto:a@b.c">mail</a></p>`
+	if one.Content != body || two.Description != "<p>Two body</p>" {
+		t.Errorf("rss bodies = %q, %q", one.Content, two.Description)
+	}
+
+	var atom struct {
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			Title  string `xml:"title"`
+			Author struct {
+				Name string `xml:"name"`
+			} `xml:"author"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(read("blog/atom.xml"), &atom); err != nil {
+		t.Fatalf("atom: %v", err)
+	}
+	if atom.ID != "https://example.com/docs/blog/atom.xml" || atom.Updated != "2026-01-03T10:30:00Z" {
+		t.Errorf("atom = %+v", atom)
+	}
+	if len(atom.Entries) != 2 || atom.Entries[0].Author.Name != "Bo" {
+		t.Errorf("atom entries = %+v", atom.Entries)
+	}
+
+	var jf jsonFeedDoc
+	if err := json.Unmarshal(read("blog/feed.json"), &jf); err != nil {
+		t.Fatalf("json feed: %v", err)
+	}
+	if jf.FeedURL != "https://example.com/docs/blog/feed.json" || len(jf.Items) != 2 ||
+		jf.Items[1].DatePublished != "2026-01-02T00:00:00Z" || jf.Items[1].ContentHTML != body {
+		t.Errorf("json feed = %+v", jf)
+	}
+
+	// Feeds don't show up as content in listings.
+	if got := string(read("blog/index.html")); strings.Contains(got, ".xml") || strings.Contains(got, ".json") {
+		t.Errorf("listing includes feeds: %s", got)
+	}
+}
+
+func TestFeedFormatsAndPath(t *testing.T) {
+	sg, pub := newFeedSite(t,
+		Site{Title: "S", BaseURL: "https://example.com"},
+		map[string]FeedConfig{"/": {Formats: []string{"rss"}, Path: "feeds"}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "docs", "feeds", "index.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "<title>About</title>") {
+		t.Errorf("site feed misses pages outside blog: %s", b)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "docs", "feeds", "atom.xml")); !os.IsNotExist(err) {
+		t.Errorf("atom.xml written though not configured: %v", err)
+	}
+}
+
+func TestFeedRequiresBaseURL(t *testing.T) {
+	sg, _ := newFeedSite(t, Site{}, map[string]FeedConfig{"blog": {Formats: []string{"json"}}})
+	_, err := sg.BuildAll(false)
+	if err == nil || !strings.Contains(err.Error(), "baseURL") {
+		t.Errorf("err = %v, want missing baseURL", err)
+	}
+}
+
+func TestMetaTime(t *testing.T) {
+	want := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, v := range []interface{}{"2026-01-02", "2026-01-02 00:00:00", "2026-01-02T00:00:00Z", want} {
+		if got, ok := metaTime(v); !ok || !got.Equal(want) {
+			t.Errorf("metaTime(%v) = %v, %v", v, got, ok)
+		}
+	}
+	if _, ok := metaTime("soon"); ok {
+		t.Error("metaTime accepted an invalid date")
+	}
+}
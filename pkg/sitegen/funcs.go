@@ -0,0 +1,542 @@
+package sitegen
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math/rand/v2"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/parser"
+)
+
+// funcs.go is the general purpose part of the template func library: dates,
+// maps and lists, collection queries, strings and math. Like sort and limit,
+// funcs working on a list or string take it as their last argument so they
+// can end a pipeline: {{.Summary | plainify | truncate 80}}.
+
+// libFuncs returns the funcs of this file, merged into tplFuncs.
+func (sg *SiteGen) libFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"dateFormat":  dateFormat,
+		"now":         time.Now,
+		"dict":        dict,
+		"list":        list,
+		"append":      appendList,
+		"merge":       merge,
+		"where":       where,
+		"groupBy":     groupBy,
+		"first":       first,
+		"last":        last,
+		"uniq":        uniq,
+		"shuffle":     shuffle,
+		"truncate":    truncate,
+		"plainify":    plainify,
+		"urlize":      slugify,
+		"title":       titleCase,
+		"replaceRE":   replaceRE,
+		"markdownify": func(s interface{}) (template.HTML, error) { return sg.markdownify(nil, s) },
+		"add":         add,
+		"mul":         mul,
+		"mod":         mod,
+	}
+}
+
+// dateFormat formats a time or a frontmatter date string with a Go layout
+// ("Jan 2, 2006").
+func dateFormat(layout string, v interface{}) (string, error) {
+	t, ok := metaTime(v)
+	if !ok {
+		return "", fmt.Errorf("dateFormat: %v is not a date", v)
+	}
+	return t.Format(layout), nil
+}
+
+// dict builds a map from key/value pairs.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict: odd number of arguments")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[k] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// list builds a list of its arguments.
+func list(items ...interface{}) []interface{} {
+	if items == nil {
+		return []interface{}{}
+	}
+	return items
+}
+
+// toList copies a slice or array into a []interface{}.
+func toList(name string, v interface{}) ([]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%s: expected slice, got %s", name, rv.Kind())
+	}
+	out := make([]interface{}, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out, nil
+}
+
+// appendList returns the list given last with the other arguments added:
+// append 4 5 $list.
+func appendList(args ...interface{}) ([]interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("append: no list")
+	}
+	l, err := toList("append", args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	return append(l, args[:len(args)-1]...), nil
+}
+
+// merge combines maps from left to right; a key in a later map wins, and
+// nested maps are merged the same way.
+func merge(maps ...map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, m := range maps {
+		for k, v := range m {
+			a, aok := out[k].(map[string]interface{})
+			b, bok := v.(map[string]interface{})
+			if aok && bok {
+				v = merge(a, b)
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// lookup resolves a dotted key on a list item: Meta.<key> and the props of
+// Value on sources, Key and Value on select's pairs, map keys and exported
+// struct fields, one segment at a time.
+func lookup(key string, v interface{}) interface{} {
+	segs := strings.Split(key, ".")
+	for i, k := range segs {
+		switch x := v.(type) {
+		case nil:
+			return nil
+		case *Source:
+			if k != "Meta" {
+				return x.Value(strings.Join(segs[i:], "."))
+			}
+			v = x.Meta
+		case kv:
+			if k == "Key" {
+				v = x.Key
+			} else {
+				v = x.Value
+			}
+		case map[string]interface{}:
+			v = x[k]
+		default:
+			rv := reflect.Indirect(reflect.ValueOf(v))
+			switch rv.Kind() {
+			case reflect.Map:
+				if rv.Type().Key().Kind() != reflect.String {
+					return nil
+				}
+				e := rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()))
+				if !e.IsValid() {
+					return nil
+				}
+				v = e.Interface()
+			case reflect.Struct:
+				f := rv.FieldByName(k)
+				if !f.IsValid() || !f.CanInterface() {
+					return nil
+				}
+				v = f.Interface()
+			default:
+				return nil
+			}
+		}
+	}
+	return v
+}
+
+// toFloat converts numbers, and strings holding one, for comparison.
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compare orders a and b: as times when either is one, as numbers when both
+// are, else as strings.
+func compare(a, b interface{}) int {
+	_, at := a.(time.Time)
+	_, bt := b.(time.Time)
+	if at || bt {
+		ta, aok := metaTime(a)
+		tb, bok := metaTime(b)
+		if aok && bok {
+			return ta.Compare(tb)
+		}
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// equal is compare for equality, treating nil as equal only to nil.
+func equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return compare(a, b) == 0
+}
+
+// inList reports whether v equals an item of the list or, for a string,
+// is a substring of it.
+func inList(v, l interface{}) bool {
+	if s, ok := l.(string); ok {
+		return strings.Contains(s, fmt.Sprint(v))
+	}
+	items, _ := toList("in", l)
+	for _, item := range items {
+		if equal(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// where keeps the items of a list whose key matches a value. The operator
+// defaults to eq: where "Meta.draft" false $list, where "Meta.year" "ge" 2020
+// $list. Operators are eq, ne, gt, ge, lt, le, in, "not in" and intersect
+// (the key holds a list sharing an item with the value).
+func where(key string, args ...interface{}) ([]interface{}, error) {
+	var op string
+	var value, l interface{}
+	switch len(args) {
+	case 2:
+		op, value, l = "eq", args[0], args[1]
+	case 3:
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("where: operator %v is not a string", args[0])
+		}
+		op, value, l = s, args[1], args[2]
+	default:
+		return nil, fmt.Errorf("where: want key [op] value list, got %d arguments", len(args)+1)
+	}
+	var match func(v interface{}) bool
+	switch op {
+	case "eq", "=", "==":
+		match = func(v interface{}) bool { return equal(v, value) }
+	case "ne", "!=", "<>":
+		match = func(v interface{}) bool { return !equal(v, value) }
+	case "gt", ">":
+		match = func(v interface{}) bool { return v != nil && compare(v, value) > 0 }
+	case "ge", ">=":
+		match = func(v interface{}) bool { return v != nil && compare(v, value) >= 0 }
+	case "lt", "<":
+		match = func(v interface{}) bool { return v != nil && compare(v, value) < 0 }
+	case "le", "<=":
+		match = func(v interface{}) bool { return v != nil && compare(v, value) <= 0 }
+	case "in":
+		match = func(v interface{}) bool { return inList(v, value) }
+	case "not in":
+		match = func(v interface{}) bool { return !inList(v, value) }
+	case "intersect":
+		match = func(v interface{}) bool {
+			items, _ := toList("intersect", v)
+			for _, item := range items {
+				if inList(item, value) {
+					return true
+				}
+			}
+			return false
+		}
+	default:
+		return nil, fmt.Errorf("where: unknown operator %q", op)
+	}
+	items, err := toList("where", l)
+	if err != nil {
+		return nil, err
+	}
+	result := []interface{}{}
+	for _, item := range items {
+		if match(lookup(key, item)) {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// Group is one group of groupBy: the items sharing a key.
+type Group struct {
+	Key   string
+	Items []interface{}
+}
+
+// group splits a list by the key each item is given, keeping the order the
+// keys first appear in.
+func group(name string, l interface{}, keyOf func(interface{}) (string, error)) ([]Group, error) {
+	items, err := toList(name, l)
+	if err != nil {
+		return nil, err
+	}
+	var groups []Group
+	index := map[string]int{}
+	for _, item := range items {
+		k, err := keyOf(item)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, Group{Key: k})
+		}
+		groups[i].Items = append(groups[i].Items, item)
+	}
+	return groups, nil
+}
+
+// groupBy groups a list by the value of a key, in the order the values first
+// appear; sort the list first to order the groups.
+func groupBy(key string, l interface{}) ([]Group, error) {
+	return group("groupBy", l, func(item interface{}) (string, error) {
+		v := lookup(key, item)
+		if v == nil {
+			return "", nil
+		}
+		return fmt.Sprint(v), nil
+	})
+}
+
+// first returns the first n items of a list.
+func first(n int, l interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(l)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("first: expected slice, got %s", rv.Kind())
+	}
+	return rv.Slice(0, max(0, min(n, rv.Len()))).Interface(), nil
+}
+
+// last returns the last n items of a list.
+func last(n int, l interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(l)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("last: expected slice, got %s", rv.Kind())
+	}
+	return rv.Slice(rv.Len()-max(0, min(n, rv.Len())), rv.Len()).Interface(), nil
+}
+
+// uniq drops the repeated items of a list, keeping the first.
+func uniq(l interface{}) ([]interface{}, error) {
+	items, err := toList("uniq", l)
+	if err != nil {
+		return nil, err
+	}
+	result := []interface{}{}
+	for _, item := range items {
+		dup := false
+		for _, r := range result {
+			if reflect.DeepEqual(item, r) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// shuffle returns the items of a list in random order.
+func shuffle(l interface{}) ([]interface{}, error) {
+	items, err := toList("shuffle", l)
+	if err != nil {
+		return nil, err
+	}
+	rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+	return items, nil
+}
+
+var tagRe = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+
+// plainify strips the HTML tags from s.
+func plainify(s interface{}) string {
+	return tagRe.ReplaceAllString(fmt.Sprint(s), "")
+}
+
+// truncate shortens text to at most n characters, cutting at a word boundary
+// when there is one and adding an ellipsis ("…" unless given before the
+// text). HTML is plainified first.
+func truncate(n int, args ...interface{}) (string, error) {
+	ellipsis := "…"
+	var v interface{}
+	switch len(args) {
+	case 1:
+		v = args[0]
+	case 2:
+		ellipsis, v = fmt.Sprint(args[0]), args[1]
+	default:
+		return "", fmt.Errorf("truncate: want n [ellipsis] text")
+	}
+	if n < 0 {
+		return "", fmt.Errorf("truncate: negative length %d", n)
+	}
+	s := fmt.Sprint(v)
+	if _, ok := v.(template.HTML); ok {
+		s = plainify(s)
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s, nil
+	}
+	runes := []rune(s)
+	cut := string(runes[:n])
+	if !unicode.IsSpace(runes[n]) {
+		if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRightFunc(cut, unicode.IsSpace) + ellipsis, nil
+}
+
+// titleCase upper-cases the first letter of every word.
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		start := unicode.IsSpace(prev) || prev == '-'
+		prev = r
+		if start {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
+// replaceRE replaces the matches of a regexp in s; the replacement may refer
+// to groups as $1.
+func replaceRE(pattern, repl, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("replaceRE: %w", err)
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// markdownify renders inline markdown with the markdown settings of s (the
+// site's without one). A single paragraph loses its <p> wrapper.
+func (sg *SiteGen) markdownify(s *Source, v interface{}) (template.HTML, error) {
+	if s == nil {
+		s = &Source{Meta: map[string]interface{}{}}
+	}
+	md, err := sg.markdownFor(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(fmt.Sprint(v)), &buf, parser.WithContext(parseContext())); err != nil {
+		return "", fmt.Errorf("markdownify: %w", err)
+	}
+	b := bytes.TrimSpace(buf.Bytes())
+	if bytes.HasPrefix(b, []byte("<p>")) && bytes.HasSuffix(b, []byte("</p>")) && bytes.Count(b, []byte("<p>")) == 1 {
+		b = b[3 : len(b)-4]
+	}
+	return template.HTML(b), nil
+}
+
+// arith applies an operation to two numbers: on integers when both are,
+// else on floats.
+func arith(name string, a, b interface{}, ints func(x, y int) (int, error), floats func(x, y float64) (float64, error)) (interface{}, error) {
+	ia, aok := toInt(a)
+	ib, bok := toInt(b)
+	if aok && bok {
+		n, err := ints(ia, ib)
+		return n, err
+	}
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if !aok || !bok {
+		return nil, fmt.Errorf("%s: %v and %v are not numbers", name, a, b)
+	}
+	if floats == nil {
+		return nil, fmt.Errorf("%s: %v and %v are not integers", name, a, b)
+	}
+	f, err := floats(fa, fb)
+	return f, err
+}
+
+// toInt converts integers, and strings holding one.
+func toInt(v interface{}) (int, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint()), true
+	case reflect.String:
+		i, err := strconv.Atoi(strings.TrimSpace(rv.String()))
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func add(a, b interface{}) (interface{}, error) {
+	return arith("add", a, b,
+		func(x, y int) (int, error) { return x + y, nil },
+		func(x, y float64) (float64, error) { return x + y, nil })
+}
+
+func mul(a, b interface{}) (interface{}, error) {
+	return arith("mul", a, b,
+		func(x, y int) (int, error) { return x * y, nil },
+		func(x, y float64) (float64, error) { return x * y, nil })
+}
+
+func mod(a, b interface{}) (interface{}, error) {
+	return arith("mod", a, b,
+		func(x, y int) (int, error) {
+			if y == 0 {
+				return 0, fmt.Errorf("mod: division by zero")
+			}
+			return x % y, nil
+		}, nil)
+}
@@ -0,0 +1,330 @@
+package sitegen
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDateFormat(t *testing.T) {
+	tests := []struct {
+		layout string
+		in     interface{}
+		want   string
+	}{
+		{"Jan 2, 2006", "2026-03-05", "Mar 5, 2026"},
+		{"2006", time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), "2024"},
+		{"15:04", "2026-03-05T09:30:00Z", "09:30"},
+	}
+	for _, tt := range tests {
+		got, err := dateFormat(tt.layout, tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("dateFormat(%q, %v) = %q, %v, want %q", tt.layout, tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := dateFormat("2006", "yesterday"); err == nil {
+		t.Error("dateFormat of a non-date: want error")
+	}
+}
+
+func TestNow(t *testing.T) {
+	got := renderFunc(t, `{{(now).Year}}`, nil)
+	if want := time.Now().Format("2006"); got != want {
+		t.Errorf("now.Year = %q, want %q", got, want)
+	}
+}
+
+func TestDict(t *testing.T) {
+	m, err := dict("a", 1, "b", "two")
+	if err != nil || !reflect.DeepEqual(m, map[string]interface{}{"a": 1, "b": "two"}) {
+		t.Errorf("dict = %v, %v", m, err)
+	}
+	if _, err := dict("a"); err == nil {
+		t.Error("dict with odd arguments: want error")
+	}
+	if _, err := dict(1, 2); err == nil {
+		t.Error("dict with a non-string key: want error")
+	}
+}
+
+func TestList(t *testing.T) {
+	if got := list(1, "a"); !reflect.DeepEqual(got, []interface{}{1, "a"}) {
+		t.Errorf("list = %v", got)
+	}
+	if got := list(); got == nil || len(got) != 0 {
+		t.Errorf("empty list = %#v", got)
+	}
+	if got := renderFunc(t, `{{range list "x" "y"}}[{{.}}]{{end}}`, nil); got != "[x][y]" {
+		t.Errorf("list in template = %q", got)
+	}
+	// The builtin slice still slices.
+	if got := renderFunc(t, `{{slice "abcdef" 0 3}} {{slice (list 1 2 3) 1}}`, nil); got != "abc [2 3]" {
+		t.Errorf("builtin slice = %q", got)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	got, err := appendList(3, 4, []int{1, 2})
+	if err != nil || !reflect.DeepEqual(got, []interface{}{1, 2, 3, 4}) {
+		t.Errorf("append = %v, %v", got, err)
+	}
+	if got, err := appendList("a", nil); err != nil || !reflect.DeepEqual(got, []interface{}{"a"}) {
+		t.Errorf("append to nil = %v, %v", got, err)
+	}
+	if _, err := appendList(1, "x"); err == nil {
+		t.Error("append to a string: want error")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := map[string]interface{}{"x": 1, "n": map[string]interface{}{"p": 1, "q": 1}}
+	b := map[string]interface{}{"y": 2, "n": map[string]interface{}{"q": 2}}
+	want := map[string]interface{}{"x": 1, "y": 2, "n": map[string]interface{}{"p": 1, "q": 2}}
+	if got := merge(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("merge = %v, want %v", got, want)
+	}
+	if a["n"].(map[string]interface{})["q"] != 1 {
+		t.Error("merge modified its input")
+	}
+}
+
+func TestWhere(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{"name": "a", "year": 2019, "tags": []interface{}{"go"}},
+		map[string]interface{}{"name": "b", "year": 2021, "tags": []interface{}{"web", "css"}},
+		map[string]interface{}{"name": "c", "year": 2023, "draft": true},
+	}
+	names := func(l []interface{}) string {
+		var n []string
+		for _, i := range l {
+			n = append(n, i.(map[string]interface{})["name"].(string))
+		}
+		return strings.Join(n, ",")
+	}
+	tests := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{2021, items}, "b"},
+		{[]interface{}{"eq", "2021", items}, "b"},
+		{[]interface{}{"ne", 2021, items}, "a,c"},
+		{[]interface{}{"gt", 2019, items}, "b,c"},
+		{[]interface{}{"ge", 2021, items}, "b,c"},
+		{[]interface{}{"lt", 2021, items}, "a"},
+		{[]interface{}{"le", 2021, items}, "a,b"},
+		{[]interface{}{"in", []interface{}{2019, 2023}, items}, "a,c"},
+		{[]interface{}{"not in", []int{2019, 2023}, items}, "b"},
+	}
+	for _, tt := range tests {
+		got, err := where("year", tt.args...)
+		if err != nil || names(got) != tt.want {
+			t.Errorf("where year %v = %q, %v, want %q", tt.args[:len(tt.args)-1], names(got), err, tt.want)
+		}
+	}
+	if got, _ := where("tags", "intersect", []string{"css", "js"}, items); names(got) != "b" {
+		t.Errorf("where tags intersect = %q", names(got))
+	}
+	if got, _ := where("draft", true, items); names(got) != "c" {
+		t.Errorf("where draft true = %q", names(got))
+	}
+	if _, err := where("year", "like", 1, items); err == nil {
+		t.Error("unknown operator: want error")
+	}
+
+	// Sources are matched on Meta keys, dates as dates.
+	got := renderFunc(t, `{{range where "Meta.date" "ge" "2026-02-01" (sort "Meta.date" "asc" (sources "RelPath" "blog/*"))}}[{{.Meta.title}}]{{end}}`, blogPosts)
+	if got != "[Two][Three]" {
+		t.Errorf("where on sources = %q", got)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	items := []interface{}{
+		map[string]interface{}{"n": 1, "kind": "x"},
+		map[string]interface{}{"n": 2, "kind": "y"},
+		map[string]interface{}{"n": 3, "kind": "x"},
+		map[string]interface{}{"n": 4},
+	}
+	groups, err := groupBy("kind", items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, g := range groups {
+		got = append(got, g.Key+":"+strings.Repeat("*", len(g.Items)))
+	}
+	if want := "x:**,y:*,:*"; strings.Join(got, ",") != want {
+		t.Errorf("groupBy = %v, want %s", got, want)
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	l := []int{1, 2, 3}
+	if got, _ := first(2, l); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("first 2 = %v", got)
+	}
+	if got, _ := first(5, l); !reflect.DeepEqual(got, l) {
+		t.Errorf("first 5 = %v", got)
+	}
+	if got, _ := last(2, l); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("last 2 = %v", got)
+	}
+	if got, _ := last(0, l); !reflect.DeepEqual(got, []int{}) {
+		t.Errorf("last 0 = %v", got)
+	}
+	if _, err := first(1, "abc"); err == nil {
+		t.Error("first of a string: want error")
+	}
+}
+
+func TestUniq(t *testing.T) {
+	got, err := uniq([]interface{}{"a", 1, "a", 2, 1})
+	if err != nil || !reflect.DeepEqual(got, []interface{}{"a", 1, 2}) {
+		t.Errorf("uniq = %v, %v", got, err)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	in := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	got, err := shuffle(in)
+	if err != nil || len(got) != len(in) {
+		t.Fatalf("shuffle = %v, %v", got, err)
+	}
+	var ints []int
+	for _, v := range got {
+		ints = append(ints, v.(int))
+	}
+	sort.Ints(ints)
+	if !reflect.DeepEqual(ints, in) {
+		t.Errorf("shuffle lost items: %v", got)
+	}
+	if !reflect.DeepEqual(in, []int{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Error("shuffle modified its input")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n    int
+		args []interface{}
+		want string
+	}{
+		{20, []interface{}{"short"}, "short"},
+		{10, []interface{}{"hello brave new world"}, "hello…"},
+		{11, []interface{}{"hello brave new world"}, "hello brave…"},
+		{5, []interface{}{"abcdefgh"}, "abcde…"},
+		{8, []interface{}{" [more]", "hello brave new world"}, "hello [more]"},
+		{9, []interface{}{template.HTML("<p>héllo <b>wörld</b></p>")}, "héllo…"},
+	}
+	for _, tt := range tests {
+		got, err := truncate(tt.n, tt.args...)
+		if err != nil || got != tt.want {
+			t.Errorf("truncate(%d, %v) = %q, %v, want %q", tt.n, tt.args, got, err, tt.want)
+		}
+	}
+	if _, err := truncate(-1, "hello"); err == nil {
+		t.Error("truncate -1: want error")
+	}
+}
+
+func TestPlainify(t *testing.T) {
+	if got := plainify(template.HTML(`<p>Hi <a href="/x">there</a><!-- c --></p>`)); got != "Hi there" {
+		t.Errorf("plainify = %q", got)
+	}
+}
+
+func TestUrlize(t *testing.T) {
+	if got := renderFunc(t, `{{urlize "Hello, World & Go!"}}`, nil); got != "hello-world-go" {
+		t.Errorf("urlize = %q", got)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	if got := titleCase("the quick-brown fox"); got != "The Quick-Brown Fox" {
+		t.Errorf("title = %q", got)
+	}
+}
+
+func TestReplaceRE(t *testing.T) {
+	got, err := replaceRE(`(\d+)-(\d+)`, "$2/$1", "call 555-1234")
+	if err != nil || got != "call 1234/555" {
+		t.Errorf("replaceRE = %q, %v", got, err)
+	}
+	if _, err := replaceRE(`(`, "", "x"); err == nil {
+		t.Error("replaceRE with a bad pattern: want error")
+	}
+}
+
+func TestMarkdownify(t *testing.T) {
+	got := renderFunc(t, `{{markdownify "Some *emphasis*"}}|{{markdownify "one\n\ntwo"}}`, nil)
+	if want := "Some <em>emphasis</em>|<p>one</p>\n<p>two</p>"; got != want {
+		t.Errorf("markdownify = %q, want %q", got, want)
+	}
+}
+
+func TestMath(t *testing.T) {
+	tests := []struct {
+		fn   func(a, b interface{}) (interface{}, error)
+		a, b interface{}
+		want interface{}
+	}{
+		{add, 1, 2, 3},
+		{add, 1, 0.5, 1.5},
+		{add, 5, "2", 7},
+		{mul, 3, 4, 12},
+		{mul, 1.5, 2, 3.0},
+		{mod, 7, 3, 1},
+	}
+	for _, tt := range tests {
+		got, err := tt.fn(tt.a, tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("%v op %v = %v, %v, want %v", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+	for _, bad := range []func() (interface{}, error){
+		func() (interface{}, error) { return mod(1, 0) },
+		func() (interface{}, error) { return mod(1.5, 2) },
+		func() (interface{}, error) { return add("x", 1) },
+	} {
+		if _, err := bad(); err == nil {
+			t.Error("want error")
+		}
+	}
+	// Results feed funcs taking an int.
+	if got := renderFunc(t, `{{range limit (add 1 1) (list "a" "b" "c")}}{{.}}{{end}}`, nil); got != "ab" {
+		t.Errorf("limit (add 1 1) = %q", got)
+	}
+}
+
+// blogPosts are a few dated posts for tests querying sources.
+var blogPosts = map[string]string{
+	"src/blog/one.md":   "---\ntitle: One\ndate: 2025-12-01\ntemplate: main.html\n---\nOne",
+	"src/blog/two.md":   "---\ntitle: Two\ndate: 2026-02-01\ntemplate: main.html\n---\nTwo",
+	"src/blog/three.md": "---\ntitle: Three\ndate: 2026-03-01\ntemplate: main.html\n---\nThree",
+}
+
+// renderFunc builds a site of files plus a page running tpl and returns the
+// page's output.
+func renderFunc(t *testing.T, tpl string, files map[string]string) string {
+	t.Helper()
+	site := writeSite(t, map[string]string{
+		"templates/main.html": `{{template "content" .}}`,
+		"src/funcs.html":      tpl,
+	})
+	writeFiles(t, site, files)
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "funcs", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,213 @@
+package sitegen
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// highlight.go renders code with chroma at build time: fenced markdown code
+// blocks (unless the page turns markdown highlight off) and the highlight
+// template func. Fences accept chroma attributes after the language, e.g.
+// ```go {hl_lines=[3,"5-7"] linenos=table}. With Classes set, tokens carry CSS
+// classes instead of inline styles and the matching stylesheet is generated.
+
+// DefaultHighlightStyle is the chroma style used unless configured.
+const DefaultHighlightStyle = "github"
+
+// HighlightConfig configures syntax highlighting site-wide.
+type HighlightConfig struct {
+	// Style is a chroma style name (github, monokai, dracula, ...).
+	Style string `yaml:"style" json:"style"`
+	// LineNumbers numbers every code block.
+	LineNumbers bool `yaml:"lineNumbers" json:"lineNumbers"`
+	// Classes emits CSS classes instead of inline styles and writes the
+	// style's rules to Stylesheet.
+	Classes bool `yaml:"classes" json:"classes"`
+	// Stylesheet is where the class stylesheet is written, relative to the
+	// public root (default "css/chroma.css").
+	Stylesheet string `yaml:"stylesheet" json:"stylesheet"`
+}
+
+func (c HighlightConfig) style() *chroma.Style {
+	name := c.Style
+	if name == "" {
+		name = DefaultHighlightStyle
+	}
+	return styles.Get(name)
+}
+
+func (c HighlightConfig) formatOptions() []chromahtml.Option {
+	return []chromahtml.Option{
+		chromahtml.WithClasses(c.Classes),
+		chromahtml.WithLineNumbers(c.LineNumbers),
+	}
+}
+
+// highlightOptions configure the goldmark renderer highlighting fenced code.
+func (sg *SiteGen) highlightOptions() []highlighting.Option {
+	return []highlighting.Option{
+		highlighting.WithCustomStyle(sg.Highlight.style()),
+		highlighting.WithFormatOptions(sg.Highlight.formatOptions()...),
+	}
+}
+
+// HighlightCode renders code in lang with the site's highlight settings.
+// Options are Hugo-style comma separated key=value pairs: linenos
+// (true, false, table, inline), linenostart, hl_lines ("2 4-6" or [2,"4-6"])
+// and style.
+// Exposed to templates as highlight "go" .code ["linenos=table,hl_lines=2"].
+func (sg *SiteGen) HighlightCode(lang, code string, options ...string) (template.HTML, error) {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	style := sg.Highlight.style()
+	opts := sg.Highlight.formatOptions()
+	for _, o := range splitOptions(strings.Join(options, ",")) {
+		k, v, _ := strings.Cut(strings.TrimSpace(o), "=")
+		switch k {
+		case "":
+		case "linenos":
+			opts = append(opts, chromahtml.WithLineNumbers(v != "false"))
+			if v == "table" || v == "inline" {
+				opts = append(opts, chromahtml.LineNumbersInTable(v == "table"))
+			}
+		case "linenostart":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return "", fmt.Errorf("highlight: linenostart %q: %w", v, err)
+			}
+			opts = append(opts, chromahtml.BaseLineNumber(n))
+		case "hl_lines":
+			ranges, err := lineRanges(v)
+			if err != nil {
+				return "", fmt.Errorf("highlight: %w", err)
+			}
+			opts = append(opts, chromahtml.HighlightLines(ranges))
+		case "style":
+			style = styles.Get(v)
+		default:
+			return "", fmt.Errorf("highlight: unknown option %q", k)
+		}
+	}
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return "", fmt.Errorf("highlight %s: %w", lang, err)
+	}
+	var buf bytes.Buffer
+	if err := chromahtml.New(opts...).Format(&buf, style, it); err != nil {
+		return "", fmt.Errorf("highlight %s: %w", lang, err)
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// lineRanges parses a list of lines and ranges, space or comma separated
+// and optionally bracketed and quoted ("2 4-6", [3,"5-7"]), into inclusive
+// line ranges.
+func lineRanges(s string) ([][2]int, error) {
+	var out [][2]int
+	fields := strings.FieldsFunc(strings.Trim(s, "[]"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	for _, f := range fields {
+		f = strings.Trim(f, "\"'")
+		lo, hi, isRange := strings.Cut(f, "-")
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("hl_lines %q: %w", s, err)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("hl_lines %q: %w", s, err)
+			}
+		}
+		out = append(out, [2]int{a, b})
+	}
+	return out, nil
+}
+
+// splitOptions splits comma separated highlight options, leaving the commas
+// of lists like hl_lines=[3,5] alone.
+func splitOptions(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth = max(0, depth-1)
+		case ',':
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+// syncHighlight registers the virtual source writing the class stylesheet
+// when Classes is on. A real source file at its location takes precedence.
+// The caller must hold sg.Mu and no build may be running.
+func (sg *SiteGen) syncHighlight() {
+	for k, s := range sg.sources {
+		if s.virtual && s.Ext == ".css" && s.render != nil {
+			delete(sg.sources, k)
+		}
+	}
+	if !sg.Highlight.Classes {
+		return
+	}
+	path := strings.Trim(sg.Highlight.Stylesheet, "/")
+	if path == "" {
+		path = "css/chroma.css"
+	}
+	local := filepath.Join(sg.SitePath, sg.SourceDir, filepath.FromSlash(path))
+	if _, ok := sg.sources[local]; ok {
+		return
+	}
+	s := &Source{
+		Name:    filepath.Base(local),
+		Local:   local,
+		Ext:     ".css",
+		Ctype:   "text/css",
+		Meta:    map[string]interface{}{"path": path},
+		content: []byte{},
+		sg:      sg,
+		virtual: true,
+		hash:    fmt.Sprintf("highlight:%+v", sg.Highlight),
+		render:  sg.highlightCSS,
+	}
+	s.Path = sg.LocalToPath(s)
+	sg.sources[local] = s
+}
+
+// highlightCSS renders the stylesheet for the configured style.
+func (sg *SiteGen) highlightCSS(s *Source) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := chromahtml.New(sg.Highlight.formatOptions()...).WriteCSS(&buf, sg.Highlight.style()); err != nil {
+		return nil, fmt.Errorf("highlight stylesheet: %w", err)
+	}
+	b := buf.Bytes()
+	if sg.Minify != nil {
+		if m, err := sg.Minify.Bytes("text/css", b); err == nil {
+			b = m
+		} else {
+			log.Println("minify", s.Path, err)
+		}
+	}
+	return b, nil
+}
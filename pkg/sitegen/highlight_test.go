@@ -0,0 +1,113 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHighlightFences(t *testing.T) {
+	page := "---\ntemplate: main.html\n---\n" +
+		"```go {hl_lines=[2]}\npackage main\nfunc main() {}\n```\n\n```\nplain\n```\n"
+	got, err := renderMarkdown(t, &MarkdownConfig{Highlight: true}, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Inline styles by default, with the requested line highlighted.
+	if !strings.Contains(got, `<span style="`) || !strings.Contains(got, "package") {
+		t.Errorf("go fence not highlighted:\n%s", got)
+	}
+	if !strings.Contains(got, "background-color") {
+		t.Errorf("hl_lines not applied:\n%s", got)
+	}
+	if !strings.Contains(got, "<pre><code>plain") {
+		t.Errorf("fence without language changed:\n%s", got)
+	}
+
+	off, err := renderMarkdown(t, nil, "---\ntemplate: main.html\nmarkdown: {highlight: false}\n---\n```go\nx := 1\n```\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(off, `<code class="language-go">`) {
+		t.Errorf("highlight: false still highlighted:\n%s", off)
+	}
+}
+
+func TestHighlightClassesAndStylesheet(t *testing.T) {
+	dir := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "code.html"),
+		[]byte(`{{highlight "go" "package main\nvar x = 1" "linenos=table,hl_lines=2"}}`), 0644)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, Highlight: HighlightConfig{Style: "monokai", Classes: true}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "code", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{`class="chroma"`, `<span class="kn">package</span>`, `class="lntable"`, `class="hl"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "style=") {
+		t.Errorf("inline styles emitted with classes on:\n%s", got)
+	}
+	css, err := os.ReadFile(filepath.Join(pub, "css", "chroma.css"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(css), ".chroma .kn") || !strings.Contains(string(css), "#f92672") {
+		t.Errorf("stylesheet missing monokai rules:\n%s", css)
+	}
+	if n := len(sg.GetSources("Ext", ".css")); n != 0 {
+		t.Errorf("generated stylesheet listed as content (%d)", n)
+	}
+}
+
+func TestHighlightCodeOptions(t *testing.T) {
+	sg := &SiteGen{}
+	if _, err := sg.HighlightCode("go", "x", "bogus=1"); err == nil {
+		t.Error("unknown option accepted")
+	}
+	got, err := sg.HighlightCode("nosuchlang", "<b>", "linenos=inline,linenostart=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "&lt;b&gt;") || !strings.Contains(string(got), ">5") {
+		t.Errorf("fallback output = %s", got)
+	}
+
+	// The commas of a hl_lines list don't separate options.
+	got, err = sg.HighlightCode("text", "a\nb\nc\nd\ne\n", `hl_lines=[1,"3-4"],linenos=inline`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(got), "display:flex; background-color"); n != 3 {
+		t.Errorf("%d lines highlighted, want 3:\n%s", n, got)
+	}
+}
+
+func TestLineRanges(t *testing.T) {
+	got, err := lineRanges("2 4-6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]int{{2, 2}, {4, 6}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lineRanges = %v, want %v", got, want)
+	}
+	for _, s := range []string{"[2,4-6]", `[2, "4-6"]`} {
+		if got, _ := lineRanges(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("lineRanges(%s) = %v, want %v", s, got, want)
+		}
+	}
+	if _, err := lineRanges("x"); err == nil {
+		t.Error("invalid range accepted")
+	}
+}
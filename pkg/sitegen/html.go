@@ -3,19 +3,40 @@ package sitegen
 import (
 	"bytes"
 	"io"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
-func rewriteHTMLImages(body []byte, useWebp bool) ([]byte, error) {
-	if !useWebp {
+// imageRewrite configures rewriteHTMLImages.
+type imageRewrite struct {
+	// webp wraps JPEG and PNG images in a <picture> offering the WebP.
+	webp bool
+	// size, when not nil, gives the width and height of an image, added to
+	// tags that give neither.
+	size func(src string) (int, int, bool)
+	// lazy adds loading="lazy" and decoding="async" to all images but the
+	// first, which is likely the one above the fold.
+	lazy bool
+	// placeholder, when not nil, gives the CSS background to show while an
+	// image loads, "" for none.
+	placeholder func(src string) string
+}
+
+// rewriteHTMLImages points the <img> tags of body at the other versions
+// processImage published and adds the attributes opts asks for. Attributes
+// a tag already has are kept, so loading="eager" opts out of lazy loading.
+func rewriteHTMLImages(body []byte, opts imageRewrite) ([]byte, error) {
+	if !opts.webp && opts.size == nil && !opts.lazy && opts.placeholder == nil {
 		return body, nil
 	}
 
 	r := bytes.NewReader(body)
 	z := html.NewTokenizer(r)
 	var buf bytes.Buffer
+	first := true
 
 	for {
 		tt := z.Next()
@@ -31,16 +52,44 @@ func rewriteHTMLImages(body []byte, useWebp bool) ([]byte, error) {
 
 		if tok.Type == html.StartTagToken || tok.Type == html.SelfClosingTagToken {
 			if tok.Data == "img" {
-				var src string
+				attrs := map[string]string{}
 				for _, attr := range tok.Attr {
-					if attr.Key == "src" {
-						src = attr.Val
-						break
+					if _, ok := attrs[attr.Key]; !ok {
+						attrs[attr.Key] = attr.Val
+					}
+				}
+				src := attrs["src"]
+				add := func(key, val string) {
+					if _, ok := attrs[key]; !ok {
+						tok.Attr = append(tok.Attr, html.Attribute{Key: key, Val: val})
+					}
+				}
+
+				if _, ok := attrs["width"]; !ok && opts.size != nil && src != "" {
+					if _, ok := attrs["height"]; !ok {
+						if w, h, ok := opts.size(src); ok {
+							add("width", strconv.Itoa(w))
+							add("height", strconv.Itoa(h))
+						}
 					}
 				}
+				if opts.placeholder != nil && src != "" {
+					if bg := opts.placeholder(src); bg != "" {
+						setStyle(&tok, "background:"+bg)
+					}
+				}
+				if opts.lazy && !first {
+					add("loading", "lazy")
+					add("decoding", "async")
+				}
+				first = false
 
 				lowerSrc := strings.ToLower(src)
 				if strings.HasSuffix(lowerSrc, ".jpg") || strings.HasSuffix(lowerSrc, ".jpeg") || strings.HasSuffix(lowerSrc, ".png") {
+					if !opts.webp {
+						buf.WriteString(tok.String())
+						continue
+					}
 					webpSrc := src[:strings.LastIndex(src, ".")] + ".webp"
 
 					buf.WriteString("<picture>")
@@ -53,6 +102,8 @@ func rewriteHTMLImages(body []byte, useWebp bool) ([]byte, error) {
 					buf.WriteString("</picture>")
 					continue
 				}
+				buf.WriteString(tok.String())
+				continue
 			}
 		}
 
@@ -61,3 +112,71 @@ func rewriteHTMLImages(body []byte, useWebp bool) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// urlAttrs are the attributes absoluteURLs resolves.
+var urlAttrs = map[string]bool{"href": true, "src": true, "poster": true, "srcset": true}
+
+// absoluteURLs resolves the relative URLs in the links, images and media of
+// body against base, for HTML shown away from its page, like feed items.
+func absoluteURLs(body []byte, base *url.URL) ([]byte, error) {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	var buf bytes.Buffer
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			buf.Write(z.Raw())
+			continue
+		}
+		tok := z.Token()
+		for i, a := range tok.Attr {
+			if !urlAttrs[a.Key] {
+				continue
+			}
+			if a.Key != "srcset" {
+				tok.Attr[i].Val = resolveURL(base, a.Val)
+				continue
+			}
+			// A srcset is a list of URLs, each with an optional width or
+			// density.
+			cands := strings.Split(a.Val, ",")
+			for j, c := range cands {
+				f := strings.Fields(c)
+				if len(f) > 0 {
+					f[0] = resolveURL(base, f[0])
+					cands[j] = strings.Join(f, " ")
+				}
+			}
+			tok.Attr[i].Val = strings.Join(cands, ", ")
+		}
+		buf.WriteString(tok.String())
+	}
+	return buf.Bytes(), nil
+}
+
+// resolveURL resolves ref against base, leaving absolute URLs, other
+// schemes (mailto:, data:) and what does not parse as they are.
+func resolveURL(base *url.URL, ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// setStyle puts decl in front of the style of the tag, so the tag's own
+// declarations win.
+func setStyle(tok *html.Token, decl string) {
+	for i, a := range tok.Attr {
+		if a.Key == "style" {
+			tok.Attr[i].Val = decl + ";" + a.Val
+			return
+		}
+	}
+	tok.Attr = append(tok.Attr, html.Attribute{Key: "style", Val: decl})
+}
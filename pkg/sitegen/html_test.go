@@ -7,7 +7,7 @@ import (
 
 func TestRewriteHTMLImages_WebpDisabled(t *testing.T) {
 	body := []byte(`<html><body><img src="/img/photo.jpg"></body></html>`)
-	got, err := rewriteHTMLImages(body, false)
+	got, err := rewriteHTMLImages(body, imageRewrite{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -18,7 +18,7 @@ func TestRewriteHTMLImages_WebpDisabled(t *testing.T) {
 
 func TestRewriteHTMLImages_NoImages(t *testing.T) {
 	body := []byte(`<html><body><p>Hello world</p></body></html>`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,7 +32,7 @@ func TestRewriteHTMLImages_NoImages(t *testing.T) {
 
 func TestRewriteHTMLImages_JPG(t *testing.T) {
 	body := []byte(`<img src="/img/photo.jpg">`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,7 +53,7 @@ func TestRewriteHTMLImages_JPG(t *testing.T) {
 
 func TestRewriteHTMLImages_JPEG(t *testing.T) {
 	body := []byte(`<img src="/img/photo.jpeg">`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,7 +68,7 @@ func TestRewriteHTMLImages_JPEG(t *testing.T) {
 
 func TestRewriteHTMLImages_PNG(t *testing.T) {
 	body := []byte(`<img src="/img/icon.png">`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -82,7 +82,7 @@ func TestRewriteHTMLImages_PNG(t *testing.T) {
 
 func TestRewriteHTMLImages_SVG_Unchanged(t *testing.T) {
 	body := []byte(`<img src="/img/logo.svg">`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,7 +93,7 @@ func TestRewriteHTMLImages_SVG_Unchanged(t *testing.T) {
 
 func TestRewriteHTMLImages_GIF_Unchanged(t *testing.T) {
 	body := []byte(`<img src="/img/anim.gif">`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -104,7 +104,7 @@ func TestRewriteHTMLImages_GIF_Unchanged(t *testing.T) {
 
 func TestRewriteHTMLImages_MixedImages(t *testing.T) {
 	body := []byte(`<div><img src="/a.jpg"><img src="/b.svg"><img src="/c.png"></div>`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -117,7 +117,7 @@ func TestRewriteHTMLImages_MixedImages(t *testing.T) {
 
 func TestRewriteHTMLImages_CaseInsensitive(t *testing.T) {
 	body := []byte(`<img src="/img/PHOTO.JPG">`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -128,7 +128,7 @@ func TestRewriteHTMLImages_CaseInsensitive(t *testing.T) {
 
 func TestRewriteHTMLImages_PreservesOtherHTML(t *testing.T) {
 	body := []byte(`<html><head><title>Test</title></head><body><p>Hello</p><img src="/x.jpg"><a href="/">Link</a></body></html>`)
-	got, err := rewriteHTMLImages(body, true)
+	got, err := rewriteHTMLImages(body, imageRewrite{webp: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -143,3 +143,51 @@ func TestRewriteHTMLImages_PreservesOtherHTML(t *testing.T) {
 		t.Error("expected link preserved")
 	}
 }
+
+func TestRewriteHTMLImages_Attributes(t *testing.T) {
+	size := func(src string) (int, int, bool) {
+		if src == "/remote.jpg" {
+			return 0, 0, false
+		}
+		return 640, 480, true
+	}
+	body := []byte(`<img src="/hero.jpg"><img src="/a.png" width="10"><img src="/logo.svg" loading="eager"><img src="/remote.jpg" decoding="sync">`)
+	got, err := rewriteHTMLImages(body, imageRewrite{size: size, lazy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<img src="/hero.jpg" width="640" height="480">` +
+		`<img src="/a.png" width="10" loading="lazy" decoding="async">` +
+		`<img src="/logo.svg" loading="eager" width="640" height="480" decoding="async">` +
+		`<img src="/remote.jpg" decoding="sync" loading="lazy">`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	got, err = rewriteHTMLImages([]byte(`<p>x</p><img src="/a.jpg"><img src="/b.jpg">`), imageRewrite{webp: true, lazy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<p>x</p><picture><source srcset="/a.webp" type="image/webp"><img src="/a.jpg"></picture>` +
+		`<picture><source srcset="/b.webp" type="image/webp"><img src="/b.jpg" loading="lazy" decoding="async"></picture>`
+	if string(got) != want {
+		t.Errorf("with webp got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRewriteHTMLImages_Placeholder(t *testing.T) {
+	bg := func(src string) string {
+		if src == "/a.jpg" {
+			return "#123456"
+		}
+		return ""
+	}
+	got, err := rewriteHTMLImages([]byte(`<img src="/a.jpg"><img src="/a.jpg" style="background:red"><img src="/b.jpg">`), imageRewrite{placeholder: bg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<img src="/a.jpg" style="background:#123456"><img src="/a.jpg" style="background:#123456;background:red"><img src="/b.jpg">`
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -2,11 +2,19 @@ package sitegen
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	"image/png"
 	"log"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/gen2brain/webp"
 	xdraw "golang.org/x/image/draw"
@@ -17,68 +25,237 @@ func resizeImage(img image.Image, newWidth int) image.Image {
 	srcWidth := bounds.Dx()
 	srcHeight := bounds.Dy()
 	newHeight := srcHeight * newWidth / srcWidth
-	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
-	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, xdraw.Over, nil)
+	return scaleImage(img, newWidth, newHeight)
+}
+
+// scaleImage scales img to exactly width x height.
+func scaleImage(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Over, nil)
 	return dst
 }
 
-func (sg *SiteGen) processImage(src []byte, pubPath string, ext string) error {
-	img, _, err := image.Decode(bytes.NewReader(src))
+// maxImageWidth is the width minify caps images at.
+const maxImageWidth = 1920
+
+// processImage writes the image src to pubPath, upright, without the
+// metadata the site does not keep and capped at maxImageWidth when minifying,
+// plus, with Webp, a .webp copy. Encoded images come from the image cache
+// when there, so src is only decoded when one is missing. It returns the
+// files it wrote besides pubPath.
+func (sg *SiteGen) processImage(src []byte, pubPath string, ext string) ([]string, error) {
+	orientation := parseExif(src).orientation
+	if sg.Minify == nil && !sg.Webp && orientation < 2 {
+		return nil, os.WriteFile(pubPath, StripMetadata(src, sg.KeepMetadata), os.ModePerm)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(src))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Calculate if we need to resize
-	bounds := img.Bounds()
-	width := bounds.Dx()
-
-	optimized := false
-
+	// Phones store photos as shot and the orientation in the EXIF.
+	optimized := orientation > 1
+	width := cfg.Width
+	if orientation >= 5 {
+		width = cfg.Height
+	}
 	// Resize if minify is turned on and width > 1920
-	if sg.Minify != nil && width > 1920 {
-		img = resizeImage(img, 1920)
+	if sg.Minify != nil && width > maxImageWidth {
+		width = maxImageWidth
 		optimized = true
 	}
 
-	// Always write the original (or resized) image
-	if optimized {
-		out, err := os.Create(pubPath)
-		if err != nil {
-			return err
+	sum := sha256.Sum256(src)
+	hash := hex.EncodeToString(sum[:])
+	meta := keptMetadata(src, sg.KeepMetadata)
+	var full image.Image
+	sized := map[int]image.Image{}
+	// decoded returns the image upright at width w, decoding src once.
+	decoded := func(w int) (image.Image, error) {
+		if img, ok := sized[w]; ok {
+			return img, nil
 		}
-
-		if ext == ".png" {
-			err = png.Encode(out, img)
-		} else {
-			// Save using JPEG backend
-			err = jpeg.Encode(out, img, &jpeg.Options{Quality: 85})
+		if full == nil {
+			img, _, err := image.Decode(bytes.NewReader(src))
+			if err != nil {
+				return nil, err
+			}
+			full = orient(img, orientation)
+		}
+		img := full
+		if w != full.Bounds().Dx() {
+			img = resizeImage(full, w)
 		}
-		out.Close()
-		if err != nil {
-			return err
+		sized[w] = img
+		return img, nil
+	}
+	write := func(p string, w int) error {
+		webp := strings.HasSuffix(p, ".webp")
+		params := fmt.Sprintf("process %t", webp)
+		if !webp {
+			params += fmt.Sprint(" ", sg.KeepMetadata)
+		}
+		return sg.cachedImage(p, hash, w, params, func(path string) error {
+			img, err := decoded(w)
+			if err != nil {
+				return err
+			}
+			if webp {
+				return writeWebp(path, img, 0)
+			}
+			return encodeImage(path, img, ext, 0, meta)
+		})
+	}
+
+	// Always write the original (or resized) image
+	if optimized {
+		if err := write(pubPath, width); err != nil {
+			return nil, err
 		}
 	} else {
 		// Just write original bytes if not resized to save quality & time
-		if err := os.WriteFile(pubPath, src, os.ModePerm); err != nil {
-			return err
+		if err := os.WriteFile(pubPath, StripMetadata(src, sg.KeepMetadata), os.ModePerm); err != nil {
+			return nil, err
 		}
 	}
 
+	var outs []string
 	// Generate WebP if requested
 	if sg.Webp {
 		webpPath := pubPath[:len(pubPath)-len(ext)] + ".webp"
-		outWebp, err := os.Create(webpPath)
-		if err != nil {
-			return err
+		if err := write(webpPath, width); err != nil {
+			return nil, err
 		}
-		defer outWebp.Close()
+		outs = append(outs, webpPath)
+	}
+	return outs, nil
+}
 
-		err = webp.Encode(outWebp, img, webp.Options{Quality: 80})
-		if err != nil {
-			log.Println("failed to encode webp", webpPath, err)
-			return err
-		}
+// encodeImage encodes img to path as PNG or, for any other ext, JPEG of the
+// given quality, 85 when 0, with meta, the metadata kept from the source.
+func encodeImage(path string, img image.Image, ext string, quality int, meta *imageMetadata) error {
+	if quality == 0 {
+		quality = 85
+	}
+	var buf bytes.Buffer
+	var err error
+	if ext == ".png" {
+		err = png.Encode(&buf, img)
+	} else {
+		// Save using JPEG backend
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, meta.into(buf.Bytes()), 0666)
+}
 
+// writeWebp encodes img to path as WebP of the given quality, 80 when 0.
+func writeWebp(path string, img image.Image, quality int) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if quality == 0 {
+		quality = 80
+	}
+	if err := webp.Encode(out, img, webp.Options{Quality: quality}); err != nil {
+		log.Println("failed to encode webp", path, err)
+		return err
+	}
 	return nil
 }
+
+// imageRewrite returns how rewriteHTMLImages rewrites the images on the page
+// of s, looking up the site's own images among the sources and the
+// derivatives of the image func, and others in the source and public dirs.
+func (sg *SiteGen) imageRewrite(s *Source) imageRewrite {
+	r := imageRewrite{webp: sg.Webp, lazy: sg.ImageAttributes}
+	if sg.ImageAttributes {
+		r.size = func(src string) (int, int, bool) {
+			p, ok := sg.imageURLPath(s, src)
+			if !ok {
+				return 0, 0, false
+			}
+			if img, ok := sg.siteImage(p); ok {
+				return img.Width(), img.Height(), true
+			}
+			for _, dir := range []string{filepath.Join(sg.SitePath, sg.SourceDir), sg.PublicPath} {
+				f, err := os.Open(filepath.Join(dir, filepath.FromSlash(p)))
+				if err != nil {
+					continue
+				}
+				cfg, _, err := image.DecodeConfig(f)
+				f.Close()
+				if err == nil {
+					return cfg.Width, cfg.Height, true
+				}
+			}
+			return 0, 0, false
+		}
+	}
+	if sg.ImagePlaceholder != "" {
+		r.placeholder = func(src string) string {
+			p, ok := sg.imageURLPath(s, src)
+			if !ok {
+				return ""
+			}
+			img, ok := sg.siteImage(p)
+			if !ok {
+				return ""
+			}
+			ph, err := img.placeholder()
+			if err != nil || ph.color == "transparent" {
+				return ""
+			}
+			if sg.ImagePlaceholder == "blur" {
+				return ph.color + " url(" + ph.dataURI + ") center/cover no-repeat"
+			}
+			return ph.color
+		}
+	}
+	return r
+}
+
+// siteImage returns the source image or image func derivative published at
+// p, relative to the public dir.
+func (sg *SiteGen) siteImage(p string) (*Image, bool) {
+	if img, err := sg.Image(p); err == nil {
+		return img, true
+	}
+	c := sg.images()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img, ok := c.derived[strings.TrimLeft(p, "/")]
+	return img, ok
+}
+
+// imageURLPath resolves the src of an image on the page of s to its path in
+// the site, false when it is not on the site.
+// pageDir is the directory relative URLs on the page s resolve against, with
+// a trailing slash: pages are served as directories, unless named like a
+// file.
+func pageDir(s *Source) string {
+	dir := s.Path
+	if ext := path.Ext(dir); ext == ".html" || ext == ".htm" {
+		dir = path.Dir(dir)
+	}
+	return strings.TrimSuffix(path.Join("/", dir), "/") + "/"
+}
+
+func (sg *SiteGen) imageURLPath(s *Source, src string) (string, bool) {
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(pageDir(s), p)
+	}
+	if !strings.HasPrefix(p, sg.BasePath) {
+		return "", false
+	}
+	return strings.TrimPrefix(p, sg.BasePath), true
+}
@@ -7,6 +7,7 @@ import (
 	"image/png"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/tdewolff/minify/v2"
@@ -101,7 +102,7 @@ func TestProcessImage_NoResize(t *testing.T) {
 	sg := &SiteGen{Minify: nil, Webp: false}
 	src := createTestJPEG(t, 800, 600)
 
-	if err := sg.processImage(src, pubPath, ".jpg"); err != nil {
+	if _, err := sg.processImage(src, pubPath, ".jpg"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -122,7 +123,7 @@ func TestProcessImage_Resize(t *testing.T) {
 	sg := &SiteGen{Minify: &minify.M{}, Webp: false}
 	src := createTestJPEG(t, 2500, 1500)
 
-	if err := sg.processImage(src, pubPath, ".jpg"); err != nil {
+	if _, err := sg.processImage(src, pubPath, ".jpg"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -143,7 +144,7 @@ func TestProcessImage_ResizePNG(t *testing.T) {
 	sg := &SiteGen{Minify: &minify.M{}, Webp: false}
 	src := createTestPNG(t, 2500, 1500)
 
-	if err := sg.processImage(src, pubPath, ".png"); err != nil {
+	if _, err := sg.processImage(src, pubPath, ".png"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -163,7 +164,7 @@ func TestProcessImage_WebpGeneration(t *testing.T) {
 	sg := &SiteGen{Minify: nil, Webp: true}
 	src := createTestJPEG(t, 800, 600)
 
-	if err := sg.processImage(src, pubPath, ".jpg"); err != nil {
+	if _, err := sg.processImage(src, pubPath, ".jpg"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -187,7 +188,7 @@ func TestProcessImage_ResizeAndWebp(t *testing.T) {
 	sg := &SiteGen{Minify: &minify.M{}, Webp: true}
 	src := createTestJPEG(t, 3000, 2000)
 
-	if err := sg.processImage(src, pubPath, ".jpg"); err != nil {
+	if _, err := sg.processImage(src, pubPath, ".jpg"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -200,3 +201,34 @@ func TestProcessImage_ResizeAndWebp(t *testing.T) {
 		t.Errorf("webp file missing: %v", err)
 	}
 }
+
+func TestImageAttributes(t *testing.T) {
+	pub := t.TempDir()
+	site := writeSite(t, map[string]string{
+		"src/img/hero.jpg": string(createTestJPEG(t, 800, 400)),
+		"src/img/icon.png": string(createTestPNG(t, 32, 16)),
+		"src/page.html":    `<img src="../img/hero.jpg"><img src="{{(image "img/hero.jpg").Fill "300x200"}}"><img src="/img/icon.png"><img src="/img/gone.png">`,
+	})
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, ImageAttributes: true})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<img src="../img/hero.jpg" width="800" height="400">`,
+		`width="300" height="200" loading="lazy" decoding="async">`,
+		`<img src="/img/icon.png" width="32" height="16" loading="lazy" decoding="async">`,
+		`<img src="/img/gone.png" loading="lazy" decoding="async">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "picture") {
+		t.Errorf("rewritten for webp without -webp:\n%s", got)
+	}
+}
@@ -0,0 +1,130 @@
+package sitegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imagecache.go keeps every image the pipeline encodes in
+// <site>/.sitegen/images, named after the content hash of the source image,
+// the width and a digest of the other parameters it was encoded with:
+//
+//	3f9a0c1b2d4e5f60-480w-7c1e2a9b.webp
+//
+// Building the same image again, after -clean, under -serve or with
+// -no-cache, copies it from there instead of decoding and encoding the
+// source. Builds count the images taken from the cache and encoded in their
+// stats, and PruneImageCache drops the images of sources no longer in the
+// site.
+
+func (sg *SiteGen) imageCacheDir() string {
+	return filepath.Join(sg.CacheDir(), "images")
+}
+
+// cachedImage writes to dst the image of width w encoded from the source
+// with content hash per params: copied from the cache when there, otherwise
+// encoded by encode into the cache first. Without a site path it just
+// encodes to dst.
+func (sg *SiteGen) cachedImage(dst, hash string, w int, params string, encode func(path string) error) error {
+	if sg.SitePath == "" {
+		return encode(dst)
+	}
+	c := sg.images()
+	sum := sha256.Sum256([]byte(params))
+	name := fmt.Sprintf("%s-%dw-%s%s", hash[:16], w, hex.EncodeToString(sum[:4]), strings.ToLower(filepath.Ext(dst)))
+	cached := filepath.Join(sg.imageCacheDir(), name)
+	if err := copyFile(cached, dst); err == nil {
+		c.hits.Add(1)
+		return nil
+	}
+	c.encoded.Add(1)
+	if err := os.MkdirAll(sg.imageCacheDir(), os.ModePerm); err != nil {
+		return err
+	}
+	// Encoded under a temporary name so no build copies half an image.
+	tmp, err := os.CreateTemp(sg.imageCacheDir(), ".tmp-*"+filepath.Ext(name))
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err := encode(tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return copyFile(cached, dst)
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// PruneImageCache removes the cached images of JPEG and PNG files no longer
+// in the source dir, or changed since, and returns how many files it removed
+// and their size in bytes. Temporary files are left to the builds writing
+// them.
+func (sg *SiteGen) PruneImageCache() (int, int64, error) {
+	live := map[string]bool{}
+	err := filepath.WalkDir(filepath.Join(sg.SitePath, sg.SourceDir), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".jpg", ".jpeg", ".png":
+			if info, err := sg.imageInfo(p); err == nil {
+				live[info.hash[:16]] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := os.ReadDir(sg.imageCacheDir())
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	var n int
+	var size int64
+	for _, e := range entries {
+		hash, _, _ := strings.Cut(e.Name(), "-")
+		if e.IsDir() || live[hash] || strings.HasPrefix(e.Name(), ".tmp-") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(sg.imageCacheDir(), e.Name())); err != nil {
+			return n, size, err
+		}
+		n++
+		size += fi.Size()
+	}
+	return n, size, nil
+}
@@ -0,0 +1,63 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImageCache(t *testing.T) {
+	pub := t.TempDir()
+	site := writeSite(t, map[string]string{
+		"src/img/big.jpg":  string(createTestJPEG(t, 1200, 600)),
+		"src/img/logo.png": string(createTestPNG(t, 200, 200)),
+		"src/page.html":    `{{(image "img/big.jpg").Resize "300x webp"}}`,
+	})
+	build := func() map[string]int {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Clean: true, Webp: true})
+		stats, err := sg.BuildAll(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	// big.webp, logo.webp and the resized webp.
+	stats := build()
+	if stats["img encoded"] != 3 || stats["img cached"] != 0 {
+		t.Fatalf("first build: %v", stats)
+	}
+	files, _ := os.ReadDir(filepath.Join(site, cacheDir, "images"))
+	if len(files) != 3 {
+		t.Fatalf("%d images cached, want 3", len(files))
+	}
+
+	// A clean build copies every image from the cache.
+	stats = build()
+	if stats["img encoded"] != 0 || stats["img cached"] != 3 {
+		t.Fatalf("second build: %v", stats)
+	}
+	for _, name := range []string{"big.jpg", "big.webp", "logo.png", "logo.webp"} {
+		if _, err := os.Stat(filepath.Join(pub, "img", name)); err != nil {
+			t.Errorf("%s missing: %v", name, err)
+		}
+	}
+
+	// Pruning drops the images of big.jpg, keeping logo.png's and the
+	// temporary files of builds still encoding.
+	os.Remove(filepath.Join(site, "src", "img", "big.jpg"))
+	writeFiles(t, site, map[string]string{".sitegen/images/.tmp-123.webp": "x"})
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	n, size, err := sg.PruneImageCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || size == 0 {
+		t.Errorf("pruned %d files of %d bytes, want 2", n, size)
+	}
+	files, _ = os.ReadDir(filepath.Join(site, cacheDir, "images"))
+	if len(files) != 2 {
+		t.Errorf("%d images left, want logo.webp and the temporary file", len(files))
+	}
+}
@@ -0,0 +1,206 @@
+package sitegen
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lang.go adds multilingual sites. With "languages" configured in
+// sitegen.yaml, a page belongs to a language either by a suffix before its
+// extension (about.de.md) or by living under src/<lang>/. Pages of the default
+// language (Site.Language) build at the root, every other language under its
+// own /<lang>/ prefix. Pages sharing a translation key (their path without the
+// language marker, or an explicit translationKey: frontmatter) are
+// translations of each other.
+
+// LanguageConfig describes one configured language.
+type LanguageConfig struct {
+	// Name is the display name, e.g. for a language switcher.
+	Name string `yaml:"name" json:"name"`
+}
+
+// DefaultLang is the language of pages without a language marker: the site
+// language, else "en" when configured, else the first configured code.
+func (sg *SiteGen) DefaultLang() string {
+	if sg.Site.Language != "" || len(sg.Site.Languages) == 0 {
+		return sg.Site.Language
+	}
+	if _, ok := sg.Site.Languages["en"]; ok {
+		return "en"
+	}
+	codes := make([]string, 0, len(sg.Site.Languages))
+	for c := range sg.Site.Languages {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	return codes[0]
+}
+
+// detectLang sets the language and translation key of a page source from its
+// location under the source dir.
+func (sg *SiteGen) detectLang(s *Source) {
+	s.Lang = sg.DefaultLang()
+	switch s.Ext {
+	case ".html", ".htm", ".md":
+	default:
+		return
+	}
+	rel, err := filepath.Rel(filepath.Join(sg.SitePath, sg.SourceDir), s.Local)
+	if err != nil {
+		return
+	}
+	key := strings.TrimSuffix(filepath.ToSlash(rel), s.Ext)
+	if len(sg.Site.Languages) > 0 {
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			if _, ok := sg.Site.Languages[key[i+1:]]; ok {
+				s.Lang = key[i+1:]
+				s.langSuffix = true
+				key = key[:i]
+			}
+		}
+		if i := strings.Index(key, "/"); !s.langSuffix && i > 0 {
+			if _, ok := sg.Site.Languages[key[:i]]; ok {
+				s.Lang = key[:i]
+				key = key[i+1:]
+			}
+		}
+	}
+	s.transKey = key
+}
+
+// langPrefix is the path prefix a source's language adds: none for the
+// default language or for pages under src/<lang>/, whose path already has it.
+func (sg *SiteGen) langPrefix(s *Source) string {
+	if !s.langSuffix || s.Lang == sg.DefaultLang() {
+		return ""
+	}
+	return s.Lang + "/"
+}
+
+// translationKey links the language versions of one page.
+func (s *Source) translationKey() string {
+	if k, ok := s.Meta["translationKey"]; ok {
+		return fmt.Sprint(k)
+	}
+	return s.transKey
+}
+
+// Translations returns the other language versions of this page, sorted by
+// language code. Exposed to templates as .Translations.
+func (s *Source) Translations() []*Source {
+	key := s.translationKey()
+	if s.sg == nil || key == "" || len(s.sg.Site.Languages) == 0 {
+		return nil
+	}
+	var out []*Source
+	for _, o := range s.sg.translationIndex()[key] {
+		if o.Local != s.Local && o.Lang != s.Lang && s.sg.Published(o) {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// translationIndex groups the sources by translation key, sorted by
+// language code, again only once the sources changed.
+func (sg *SiteGen) translationIndex() map[string][]*Source {
+	sg.i18nMu.Lock()
+	defer sg.i18nMu.Unlock()
+	if sg.translations == nil {
+		sg.translations = map[string][]*Source{}
+		for _, o := range sg.sources {
+			if k := o.translationKey(); k != "" && !o.virtual {
+				sg.translations[k] = append(sg.translations[k], o)
+			}
+		}
+		for _, list := range sg.translations {
+			sort.Slice(list, func(i, j int) bool {
+				if list[i].Lang != list[j].Lang {
+					return list[i].Lang < list[j].Lang
+				}
+				return list[i].Local < list[j].Local
+			})
+		}
+	}
+	return sg.translations
+}
+
+// forgetTranslations drops the translation index after the sources changed.
+func (sg *SiteGen) forgetTranslations() {
+	sg.i18nMu.Lock()
+	sg.translations = nil
+	sg.i18nMu.Unlock()
+}
+
+// forgetI18n drops the translation tables read, for the next i18n call to
+// read them again.
+func (sg *SiteGen) forgetI18n() {
+	sg.i18nMu.Lock()
+	sg.i18n = nil
+	sg.i18nMu.Unlock()
+}
+
+// I18n looks key up in data/i18n/<lang>.json, falling back to the default
+// language's file and then to the key itself. Nested objects are addressed
+// with dots ("nav.home"). Exposed to templates as i18n "key".
+func (sg *SiteGen) I18n(lang, key string) string {
+	for _, l := range []string{lang, sg.DefaultLang()} {
+		if l == "" {
+			continue
+		}
+		if v, ok := lookupKey(sg.i18nTable(l), key); ok {
+			return fmt.Sprint(v)
+		}
+	}
+	return key
+}
+
+// i18nFile is the translation table of lang.
+func (sg *SiteGen) i18nFile(lang string) string {
+	return filepath.Join(sg.SitePath, sg.DataDir, "i18n", lang+".json")
+}
+
+// i18nTable returns the translation table of lang, read once per build.
+func (sg *SiteGen) i18nTable(lang string) map[string]interface{} {
+	sg.i18nMu.Lock()
+	defer sg.i18nMu.Unlock()
+	if m, ok := sg.i18n[lang]; ok {
+		return m
+	}
+	if sg.i18n == nil {
+		sg.i18n = map[string]map[string]interface{}{}
+	}
+	var m map[string]interface{}
+	if b, err := os.ReadFile(sg.i18nFile(lang)); err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			log.Println("i18n", sg.i18nFile(lang), "error", err)
+			m = nil
+		}
+	}
+	sg.i18n[lang] = m
+	return m
+}
+
+// lookupKey resolves a dotted key in nested maps.
+func lookupKey(m map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	parts := strings.Split(key, ".")
+	var cur interface{} = m
+	for _, p := range parts {
+		mm, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = mm[p]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
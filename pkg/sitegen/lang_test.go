@@ -0,0 +1,87 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMultilingual(t *testing.T) {
+	pub := t.TempDir()
+	page := `{{.Lang}}|{{i18n "hello"}}|{{i18n "nav.home"}}|{{i18n "missing"}}|{{range .Translations}}[{{.Lang}} {{.Path}}]{{end}}`
+	dir := writeSite(t, map[string]string{
+		"src/about.html":      page,
+		"src/about.de.html":   page,
+		"src/ja/about.html":   page,
+		"src/index.de.html":   "de home",
+		"src/contact.de.html": "---\npath: kontakt\n---\nnur deutsch",
+		"src/list.html":       `{{range sort "Path" "asc" (sources "Lang" "de")}}{{.Path}};{{end}}`,
+		"src/css/site.de.css": "body{}",
+		"data/i18n/en.json":   `{"hello": "Hello", "nav": {"home": "Home"}}`,
+		"data/i18n/de.json":   `{"hello": "Hallo"}`,
+	})
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, Site: Site{
+		Language:  "en",
+		Languages: map[string]LanguageConfig{"en": {}, "de": {Name: "Deutsch"}, "ja": {}},
+	}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	read := func(rel string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(pub, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(string(b), "<p>"), "</p>"))
+	}
+
+	tests := map[string]string{
+		"about/index.html":      "en|Hello|Home|missing|[de /de/about][ja /ja/about]",
+		"de/about/index.html":   "de|Hallo|Home|missing|[en /about][ja /ja/about]",
+		"ja/about/index.html":   "ja|Hello|Home|missing|[de /de/about][en /about]",
+		"de/index.html":         "de home",
+		"de/kontakt/index.html": "nur deutsch",
+		"list/index.html":       "/de/;/de/about;/de/kontakt;",
+	}
+	for rel, want := range tests {
+		if got := read(rel); !strings.HasPrefix(got, want) {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+	// Only pages carry a language; other files keep their name.
+	if _, err := os.Stat(filepath.Join(pub, "css", "site.de.css")); err != nil {
+		t.Errorf("static file renamed: %v", err)
+	}
+
+	// Translation tables are read once per build, and again once edited.
+	de := filepath.Join(dir, "data", "i18n", "de.json")
+	if err := os.WriteFile(de, []byte(`{"hello": "Servus"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sg.BuildAffected(de); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("de/about/index.html"); !strings.HasPrefix(got, "de|Servus|") {
+		t.Errorf("after i18n edit = %q", got)
+	}
+}
+
+func TestDefaultLang(t *testing.T) {
+	tests := []struct {
+		site Site
+		want string
+	}{
+		{Site{}, ""},
+		{Site{Language: "fr"}, "fr"},
+		{Site{Languages: map[string]LanguageConfig{"de": {}, "en": {}}}, "en"},
+		{Site{Languages: map[string]LanguageConfig{"ja": {}, "de": {}}}, "de"},
+	}
+	for _, tt := range tests {
+		sg := &SiteGen{Site: tt.site}
+		if got := sg.DefaultLang(); got != tt.want {
+			t.Errorf("DefaultLang(%+v) = %q, want %q", tt.site, got, tt.want)
+		}
+	}
+}
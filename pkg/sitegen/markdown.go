@@ -0,0 +1,135 @@
+package sitegen
+
+import (
+	"fmt"
+
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v2"
+)
+
+// markdown.go configures the goldmark converter used for .md sources. The
+// site-wide MarkdownConfig comes from the "markdown" section of sitegen.yaml;
+// a page can override single options with the same keys in its frontmatter
+// (markdown: {footnotes: true}).
+
+// MarkdownConfig selects goldmark extensions and renderer options.
+type MarkdownConfig struct {
+	// GFM enables GitHub Flavored Markdown: tables, strikethrough, task
+	// lists and autolinks.
+	GFM            bool `yaml:"gfm" json:"gfm"`
+	Footnotes      bool `yaml:"footnotes" json:"footnotes"`
+	DefinitionList bool `yaml:"definitionList" json:"definitionList"`
+	// Typographer turns straight quotes, dashes and ellipses into their
+	// typographic forms.
+	Typographer bool `yaml:"typographer" json:"typographer"`
+	// HeadingIDs gives every heading an id attribute derived from its text.
+	HeadingIDs bool `yaml:"headingIDs" json:"headingIDs"`
+	// Unsafe passes raw HTML in markdown through instead of omitting it.
+	Unsafe bool `yaml:"unsafe" json:"unsafe"`
+	// Highlight renders fenced code blocks with the site's syntax
+	// highlighting settings (see HighlightConfig).
+	Highlight bool `yaml:"highlight" json:"highlight"`
+	// TOCStartLevel and TOCEndLevel bound the heading levels listed in
+	// .TableOfContents (default 2 and 3).
+	TOCStartLevel int `yaml:"tocStartLevel" json:"tocStartLevel"`
+	TOCEndLevel   int `yaml:"tocEndLevel" json:"tocEndLevel"`
+	// SummaryWords is the length of a .Summary taken from the text when the
+	// page has no <!--more--> marker (default 70).
+	SummaryWords int `yaml:"summaryWords" json:"summaryWords"`
+	// WordsPerMinute is the reading speed behind .ReadingTime (default 200).
+	WordsPerMinute int `yaml:"wordsPerMinute" json:"wordsPerMinute"`
+}
+
+// DefaultMarkdown is used when the site config has no markdown section. It
+// enables nothing, so a site without one renders as plain goldmark did.
+var DefaultMarkdown = MarkdownConfig{}
+
+// markdownConfig is the site config with the page's markdown: frontmatter
+// applied on top.
+func (sg *SiteGen) markdownConfig(s *Source) (MarkdownConfig, error) {
+	cfg := sg.Markdown
+	over, ok := s.Meta["markdown"]
+	if !ok {
+		return cfg, nil
+	}
+	b, err := yaml.Marshal(over)
+	if err == nil {
+		err = yaml.UnmarshalStrict(b, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("%s: markdown frontmatter: %w", s.Local, err)
+	}
+	return cfg, nil
+}
+
+// markdownFor returns the converter for a source, shared by every source with
+// the same options.
+func (sg *SiteGen) markdownFor(s *Source) (goldmark.Markdown, error) {
+	cfg, err := sg.markdownConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	sg.mdMu.Lock()
+	defer sg.mdMu.Unlock()
+	if md, ok := sg.md[cfg]; ok {
+		return md, nil
+	}
+	md := sg.newMarkdown(cfg)
+	if sg.md == nil {
+		sg.md = map[MarkdownConfig]goldmark.Markdown{}
+	}
+	sg.md[cfg] = md
+	return md, nil
+}
+
+func (sg *SiteGen) newMarkdown(cfg MarkdownConfig) goldmark.Markdown {
+	var (
+		exts     []goldmark.Extender
+		parsers  []parser.Option
+		htmlOpts []html.Option
+		rendOpts []renderer.Option
+	)
+	if cfg.GFM {
+		exts = append(exts, extension.GFM)
+	}
+	if cfg.Footnotes {
+		exts = append(exts, extension.Footnote)
+	}
+	if cfg.DefinitionList {
+		exts = append(exts, extension.DefinitionList)
+	}
+	if cfg.Typographer {
+		exts = append(exts, extension.Typographer)
+	}
+	parsers = append(parsers,
+		parser.WithInlineParsers(util.Prioritized(wikiLinkParser{}, 199)),
+		parser.WithASTTransformers(util.Prioritized(refTransformer{}, 100)),
+	)
+	if cfg.HeadingIDs {
+		parsers = append(parsers, parser.WithAutoHeadingID())
+	}
+	if cfg.Unsafe {
+		unsafe := html.WithUnsafe()
+		htmlOpts = append(htmlOpts, unsafe)
+		rendOpts = append(rendOpts, unsafe)
+	}
+	// Render hooks take over links, images, headings and code blocks, and
+	// hand back to the renderers below (chroma for code when highlighting)
+	// when a page has no hook for them.
+	fallback := []renderer.NodeRenderer{html.NewRenderer(htmlOpts...)}
+	if cfg.Highlight {
+		fallback = append(fallback, highlighting.NewHTMLRenderer(sg.highlightOptions()...))
+	}
+	rendOpts = append(rendOpts, renderer.WithNodeRenderers(util.Prioritized(newHookRenderer(fallback...), 100)))
+	return goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(parsers...),
+		goldmark.WithRendererOptions(rendOpts...),
+	)
+}
@@ -0,0 +1,92 @@
+package sitegen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+func renderMarkdown(t *testing.T, cfg *MarkdownConfig, page string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	pub := t.TempDir()
+	src := filepath.Join(dir, "src", "page.md")
+	os.MkdirAll(filepath.Dir(src), 0755)
+	os.MkdirAll(filepath.Join(dir, "templates"), 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "main.html"), []byte(`{{template "content" .}}`), 0644)
+	if err := os.WriteFile(src, []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, Markdown: cfg})
+	if _, err := sg.BuildAll(false); err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	return string(b), err
+}
+
+func TestMarkdownDefaults(t *testing.T) {
+	body := "## Hello World\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n~~old~~ - [x] done https://example.com\n\nnote[^1]\n\n[^1]: the note\n"
+	page := "---\ntemplate: main.html\n---\n" + body + "\n<b>raw</b>\n"
+	// Without a markdown section pages render as bare goldmark did.
+	got, err := renderMarkdown(t, nil, "---\ntemplate: main.html\n---\n"+body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	if err := goldmark.Convert([]byte(body), &want); err != nil {
+		t.Fatal(err)
+	}
+	if got != want.String() {
+		t.Errorf("default markdown = %q\nwant %q", got, want.String())
+	}
+
+	got, err = renderMarkdown(t, &MarkdownConfig{GFM: true, HeadingIDs: true}, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<h2 id="hello-world">`, "<table>", "<del>old</del>", `<a href="https://example.com">`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "<b>raw</b>") {
+		t.Errorf("raw HTML rendered though unsafe is off:\n%s", got)
+	}
+	if strings.Contains(got, "footnote") {
+		t.Errorf("footnotes rendered though disabled:\n%s", got)
+	}
+}
+
+func TestMarkdownPageOverride(t *testing.T) {
+	page := "---\ntemplate: main.html\nmarkdown:\n  footnotes: true\n  unsafe: true\n  typographer: true\n  headingIDs: false\n---\n" +
+		"## Title\n\n<b>raw</b> -- \"quoted\"\n\nnote[^1]\n\n[^1]: the note\n"
+	got, err := renderMarkdown(t, &MarkdownConfig{}, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<h2>Title</h2>", "<b>raw</b>", "&ndash;", "&ldquo;quoted&rdquo;", `class="footnotes"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	if _, err := renderMarkdown(t, nil, "---\nmarkdown: {footnote: true}\n---\nx"); err == nil ||
+		!strings.Contains(err.Error(), "markdown frontmatter") {
+		t.Errorf("unknown markdown option: err = %v", err)
+	}
+}
+
+func TestMarkdownDefinitionList(t *testing.T) {
+	got, err := renderMarkdown(t, &MarkdownConfig{DefinitionList: true}, "---\ntemplate: main.html\n---\nTerm\n: Definition\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "<dl>") || !strings.Contains(got, "<dd>Definition</dd>") {
+		t.Errorf("definition list not rendered:\n%s", got)
+	}
+}
@@ -0,0 +1,370 @@
+package sitegen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markup.go runs templates from inside the markdown conversion: shortcodes
+// (shortcode.go) and render hooks. A render hook is an optional template in
+// templates/_markup that replaces goldmark's HTML for one kind of node:
+// render-link.html, render-image.html, render-heading.html and
+// render-codeblock-<lang>.html. Template output is held back behind
+// placeholders and only put in once the page template has executed.
+
+// RenderHook is the data of a render hook template.
+type RenderHook struct {
+	// Destination and Title are a link's or image's URL and title.
+	Destination string
+	Title       string
+	// Text is the rendered content of a link or heading, or an image's alt
+	// text; PlainText is the same without markup.
+	Text      template.HTML
+	PlainText string
+	// Level and Anchor are a heading's level and id.
+	Level  int
+	Anchor string
+	// Type and Inner are a code block's language and code.
+	Type  string
+	Inner string
+	// Attributes are the node's attributes, for code blocks those given
+	// after the language (```go {linenos=true}).
+	Attributes map[string]interface{}
+	// Source is the page being rendered.
+	Source *Source
+	Site   Site
+}
+
+// placeholders holds the template outputs standing in a markdown render.
+type placeholders struct {
+	outputs []string
+}
+
+// hold stores out and returns the placeholder for it. kind SHORTCODE
+// placeholders standing alone in a paragraph replace the paragraph.
+func (p *placeholders) hold(kind, out string) string {
+	p.outputs = append(p.outputs, out)
+	return fmt.Sprintf("SG%s%dX", kind, len(p.outputs)-1)
+}
+
+// placeholderRe matches a placeholder, with the paragraph goldmark wraps
+// around a shortcode standing on its own line.
+var placeholderRe = regexp.MustCompile(`<p>SGSHORTCODE(\d+)X</p>|SG(?:SHORTCODE|HOOK)(\d+)X`)
+
+// resolve replaces the placeholders in b with their outputs.
+func (p *placeholders) resolve(b []byte) []byte {
+	if p == nil || len(p.outputs) == 0 {
+		return b
+	}
+	return placeholderRe.ReplaceAllFunc(b, func(m []byte) []byte {
+		sub := placeholderRe.FindSubmatch(m)
+		n := sub[1]
+		if n == nil {
+			n = sub[2]
+		}
+		i, err := strconv.Atoi(string(n))
+		if err != nil || i >= len(p.outputs) {
+			return m
+		}
+		return []byte(p.outputs[i])
+	})
+}
+
+// placeholderIDs generates heading ids ignoring placeholders.
+type placeholderIDs struct {
+	parser.IDs
+}
+
+func (ids placeholderIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	return ids.IDs.Generate(placeholderRe.ReplaceAll(value, nil), kind)
+}
+
+// parseContext is the goldmark parser context for markdown that may hold
+// placeholders.
+func parseContext() parser.Context {
+	return parser.NewContext(parser.WithIDs(placeholderIDs{parser.NewContext().IDs()}))
+}
+
+// markupRender is one markdown render of a source: the shortcode and render
+// hook templates it runs, loaded on demand, and the outputs they produced.
+type markupRender struct {
+	sg      *SiteGen
+	s       *Source
+	md      goldmark.Markdown
+	ph      *placeholders
+	tpls    map[string]*Template
+	missing map[string]bool
+	// base is the offset in the source's content of the markdown being
+	// converted, errs the broken references found in it.
+	base int
+	errs []error
+}
+
+// markupMeta is the document meta key carrying the markupRender to the hook
+// renderer.
+const markupMeta = "sitegen.render"
+
+func (sg *SiteGen) newMarkupRender(s *Source, md goldmark.Markdown) *markupRender {
+	if s.deps == nil {
+		s.deps = map[string]bool{}
+	}
+	return &markupRender{sg: sg, s: s, md: md, ph: &placeholders{}, tpls: map[string]*Template{}, missing: map[string]bool{}}
+}
+
+func (r *markupRender) file(name string) string {
+	return filepath.Join(r.sg.SitePath, r.sg.TemplateDir, filepath.FromSlash(name))
+}
+
+// hookTemplate is a render hook or shortcode template, parsed along with the
+// site's HTML templates, and the template files a render of it reads. tpl is
+// nil when its file does not exist.
+type hookTemplate struct {
+	tpl  *Template
+	deps []string
+}
+
+// cachedHook returns the template file name below templates/, read and
+// parsed only once until ClearCache, so pages of sites without it do not
+// look for it again.
+func (sg *SiteGen) cachedHook(name string) (*hookTemplate, error) {
+	sg.tplMu.Lock()
+	defer sg.tplMu.Unlock()
+	if h, ok := sg.hooks[name]; ok {
+		return h, nil
+	}
+	if _, ok := sg.TplCache["html"]; !ok {
+		if err := sg.loadTemplate("html"); err != nil {
+			return nil, err
+		}
+	}
+	base, files := sg.TplCache["html"], sg.tplFiles["html"]
+	h := &hookTemplate{}
+	if t := base.Lookup(name); t != nil {
+		h.tpl, h.deps = base, templateDeps(t, files)
+	} else {
+		file := filepath.Join(sg.SitePath, sg.TemplateDir, filepath.FromSlash(name))
+		h.deps = []string{file}
+		if b, err := os.ReadFile(file); err == nil {
+			tpl, err := base.Clone()
+			if err != nil {
+				return nil, fmt.Errorf("template clone error: %w", err)
+			}
+			t, err := tpl.New(name).Parse(string(b))
+			if err != nil {
+				return nil, err
+			}
+			h.tpl = tpl
+			h.deps = append(h.deps, templateDeps(t, files)...)
+		}
+	}
+	if sg.hooks == nil {
+		sg.hooks = map[string]*hookTemplate{}
+	}
+	sg.hooks[name] = h
+	return h, nil
+}
+
+// template returns the template file name below templates/, parsed along
+// with the site's templates, or nil if it does not exist. Either way the
+// file becomes a dependency of the source, so creating it rebuilds.
+func (r *markupRender) template(name string) (*Template, error) {
+	if r.missing[name] {
+		return nil, nil
+	}
+	if t, ok := r.tpls[name]; ok {
+		return t, nil
+	}
+	h, err := r.sg.cachedHook(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range h.deps {
+		r.s.deps[f] = true
+	}
+	if h.tpl == nil {
+		r.missing[name] = true
+		return nil, nil
+	}
+	// Every template gets a clone of its own, as an html/template set takes
+	// no new templates once one of them has executed.
+	tpl, err := h.tpl.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("template clone error: %w", err)
+	}
+	t := tpl.Funcs(r.sg.pageFuncs(r.s)).Lookup(name)
+	r.tpls[name] = t
+	return t, nil
+}
+
+// convert renders markdown found at offset base of the source's content to
+// w, with the render hooks and references resolved.
+func (r *markupRender) convert(src []byte, w io.Writer, base int) error {
+	prev := r.base
+	r.base = base
+	defer func() { r.base = prev }()
+	pc := parseContext()
+	pc.Set(markupKey, r)
+	doc := r.md.Parser().Parse(text.NewReader(src), parser.WithContext(pc))
+	if len(r.errs) > 0 {
+		return errors.Join(r.errs...)
+	}
+	doc.OwnerDocument().AddMeta(markupMeta, r)
+	return r.md.Renderer().Render(w, src, doc)
+}
+
+// position formats the file and line of offset off in markdown src being
+// converted. Placeholders keep the line count of what they replace.
+func (r *markupRender) position(src []byte, off int) string {
+	line := r.s.lineOffset + 1 + bytes.Count(r.s.content[:min(r.base, len(r.s.content))], []byte("\n"))
+	return fmt.Sprintf("%s:%d", r.s.Local, line+bytes.Count(src[:min(off, len(src))], []byte("\n")))
+}
+
+// hookRenderer renders the nodes render hooks apply to. Without a hook
+// template, or outside a markupRender, it falls back to the renderers the
+// converter would otherwise use.
+type hookRenderer struct {
+	fallback rendererFuncs
+}
+
+// rendererFuncs records the funcs a NodeRenderer registers.
+type rendererFuncs map[ast.NodeKind]renderer.NodeRendererFunc
+
+func (f rendererFuncs) Register(k ast.NodeKind, fn renderer.NodeRendererFunc) {
+	f[k] = fn
+}
+
+// newHookRenderer falls back to the given renderers, later ones taking
+// precedence.
+func newHookRenderer(fallback ...renderer.NodeRenderer) *hookRenderer {
+	h := &hookRenderer{fallback: rendererFuncs{}}
+	for _, r := range fallback {
+		r.RegisterFuncs(h.fallback)
+	}
+	return h
+}
+
+func (h *hookRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	for _, k := range []ast.NodeKind{ast.KindLink, ast.KindImage, ast.KindHeading, ast.KindFencedCodeBlock} {
+		reg.Register(k, h.render)
+	}
+}
+
+// hookName is the hook template for node n.
+func hookName(n ast.Node, source []byte) string {
+	switch n := n.(type) {
+	case *ast.Link:
+		return "_markup/render-link.html"
+	case *ast.Image:
+		return "_markup/render-image.html"
+	case *ast.Heading:
+		return "_markup/render-heading.html"
+	case *ast.FencedCodeBlock:
+		if lang := n.Language(source); lang != nil {
+			return "_markup/render-codeblock-" + string(lang) + ".html"
+		}
+	}
+	return ""
+}
+
+func (h *hookRenderer) render(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	var t *Template
+	r, _ := n.OwnerDocument().Meta()[markupMeta].(*markupRender)
+	if name := hookName(n, source); r != nil && name != "" {
+		var err error
+		if t, err = r.template(name); err != nil {
+			return ast.WalkStop, fmt.Errorf("render hook %s: %w", name, err)
+		}
+	}
+	if t == nil {
+		if fn := h.fallback[n.Kind()]; fn != nil {
+			return fn(w, source, n, entering)
+		}
+		return ast.WalkContinue, nil
+	}
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	data := &RenderHook{
+		Attributes: map[string]interface{}{},
+		Source:     r.s,
+		Site:       r.sg.pageSite(r.s),
+	}
+	for _, a := range n.Attributes() {
+		data.Attributes[string(a.Name)] = attrValue(a.Value)
+	}
+	switch n := n.(type) {
+	case *ast.Link:
+		data.Destination, data.Title = string(n.Destination), string(n.Title)
+	case *ast.Image:
+		data.Destination, data.Title = string(n.Destination), string(n.Title)
+	case *ast.Heading:
+		data.Level = n.Level
+		if id, ok := data.Attributes["id"].(string); ok {
+			data.Anchor = id
+		}
+	case *ast.FencedCodeBlock:
+		data.Type = string(n.Language(source))
+		var code bytes.Buffer
+		for i := 0; i < n.Lines().Len(); i++ {
+			line := n.Lines().At(i)
+			code.Write(line.Value(source))
+		}
+		data.Inner = code.String()
+		if n.Info != nil {
+			info := n.Info.Segment.Value(source)
+			if i := bytes.IndexByte(info, '{'); i >= 0 {
+				if attrs, ok := parser.ParseAttributes(text.NewReader(info[i:])); ok {
+					for _, a := range attrs {
+						data.Attributes[string(a.Name)] = attrValue(a.Value)
+					}
+				}
+			}
+		}
+	}
+	if n.Kind() != ast.KindFencedCodeBlock {
+		var inner, plain bytes.Buffer
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			if err := r.md.Renderer().Render(&inner, source, c); err != nil {
+				return ast.WalkStop, err
+			}
+			writeText(&plain, c, source)
+		}
+		data.Text = template.HTML(r.ph.resolve(inner.Bytes()))
+		data.PlainText = string(r.ph.resolve(plain.Bytes()))
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return ast.WalkStop, fmt.Errorf("render hook %s: %w", t.Name(), err)
+	}
+	w.WriteString(r.ph.hold("HOOK", out.String()))
+	return ast.WalkSkipChildren, nil
+}
+
+// attrValue makes a parsed markdown attribute value template friendly.
+func attrValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = attrValue(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderHooks(t *testing.T) {
+	dir := t.TempDir()
+	pub := t.TempDir()
+	markup := filepath.Join(dir, "templates", "_markup")
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.MkdirAll(markup, 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "main.html"), []byte(`{{template "content" .}}`), 0644)
+	os.WriteFile(filepath.Join(markup, "render-link.html"),
+		[]byte(`<a href="{{replaceRE "^/" (path "/") .Destination}}"{{with .Title}} title="{{.}}"{{end}}>{{.Text}}</a>`), 0644)
+	os.WriteFile(filepath.Join(markup, "render-image.html"),
+		[]byte(`<img src="{{.Destination}}" alt="{{.PlainText}}" loading="lazy">`), 0644)
+	os.WriteFile(filepath.Join(markup, "render-heading.html"),
+		[]byte(`<h{{.Level}} id="{{.Anchor}}">{{.Text}} <a href="#{{.Anchor}}">#</a></h{{.Level}}>`), 0644)
+	os.WriteFile(filepath.Join(markup, "render-codeblock-mermaid.html"),
+		[]byte(`<div class="mermaid" data-theme="{{.Attributes.theme}}">{{.Inner}}</div>`), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "page.md"), []byte("---\ntitle: Hooks\ntemplate: main.html\n---\n"+
+		"## Hello *World*\n\n"+
+		"[docs](/docs/intro \"Intro\") and [ext](https://example.com) with ![a **cat**](cat.png)\n\n"+
+		"[![logo](logo.png)](/)\n\n"+
+		"```mermaid {theme=dark}\ngraph TD\n```\n\n```go\nx := 1\n```\n"), 0644)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, BasePath: "/base/"})
+	sg.Markdown.HeadingIDs = true
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "base", "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		`<h2 id="hello-world">Hello <em>World</em> <a href="#hello-world">#</a></h2>`,
+		`<a href="/base/docs/intro" title="Intro">docs</a>`,
+		`<a href="https://example.com">ext</a>`,
+		`<img src="cat.png" alt="a cat" loading="lazy">`,
+		`<a href="/base/"><img src="logo.png" alt="logo" loading="lazy"></a>`,
+		`<div class="mermaid" data-theme="dark">graph TD` + "\n</div>",
+		`<pre><code class="language-go">x := 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderHookAdded(t *testing.T) {
+	dir := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.MkdirAll(filepath.Join(dir, "templates", "_markup"), 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "main.html"), []byte(`{{template "content" .}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "page.md"), []byte("---\ntemplate: main.html\n---\n[a](b)\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "plain.md"), []byte("---\ntemplate: main.html\n---\nno links\n"), 0644)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	// Missing hooks are looked for once, not once per page.
+	if h := sg.hooks["_markup/render-link.html"]; h == nil || h.tpl != nil {
+		t.Errorf("missing hook not cached: %+v", h)
+	}
+	hook := filepath.Join(dir, "templates", "_markup", "render-link.html")
+	os.WriteFile(hook, []byte(`<a class="x" href="{{.Destination}}">{{.Text}}</a>`), 0644)
+	// Only the page with a link depends on the new hook.
+	if n, err := sg.BuildAffected(hook); err != nil || n != 1 {
+		t.Fatalf("BuildAffected = %d, %v", n, err)
+	}
+	b, _ := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if !strings.Contains(string(b), `<a class="x" href="b">a</a>`) {
+		t.Errorf("new hook not applied:\n%s", b)
+	}
+}
@@ -0,0 +1,662 @@
+package sitegen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"slices"
+	"strings"
+	"time"
+)
+
+// metadata.go reads and strips the metadata of JPEG and PNG images. Phone
+// photos are stored as shot, with an EXIF orientation telling viewers which
+// way up they are, and carry the camera, the time and often the place they
+// were taken. Images are published upright and with only the metadata kinds
+// KeepMetadata lists, by default just the color profile:
+//
+//	exif     the EXIF fields: camera, capture date, caption, ...
+//	gps      the location in the EXIF, kept only along with exif
+//	xmp      XMP packets
+//	iptc     IPTC records
+//	icc      the color profile
+//	comment  JPEG comments and PNG text
+//
+// The capture date, camera and caption are read from the EXIF of the source
+// image, whatever is kept, for templates to show.
+
+// MetadataKinds are the kinds of image metadata KeepMetadata may list.
+var MetadataKinds = []string{"exif", "gps", "xmp", "iptc", "icc", "comment"}
+
+// DefaultKeepMetadata is the image metadata kept when KeepMetadata is nil.
+var DefaultKeepMetadata = []string{"icc"}
+
+// exifInfo is what is read from the EXIF of an image.
+type exifInfo struct {
+	// orientation is the EXIF orientation, 1 (upright) to 8, 0 if none.
+	orientation int
+	date        time.Time
+	make        string
+	model       string
+	caption     string
+}
+
+// Date is the time the image was taken, zero when it has no EXIF date.
+func (i *Image) Date() time.Time { return i.info.exif.date }
+
+// Camera is the make and model of the camera that took the image.
+func (i *Image) Camera() string { return i.info.exif.camera() }
+
+// Caption is the image's EXIF description.
+func (i *Image) Caption() string { return i.info.exif.caption }
+
+func (e exifInfo) camera() string {
+	mk, model := e.make, e.model
+	switch {
+	case model == "":
+		return mk
+	case mk == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(strings.Fields(mk)[0])):
+		// "Canon" "Canon EOS R5", "NIKON CORPORATION" "NIKON Z 6"
+		return model
+	}
+	return mk + " " + model
+}
+
+// metaBlock is a JPEG segment or PNG chunk, kind naming the metadata it
+// holds: "" for image data, always kept, and "other" for what is always
+// dropped, such as the extra images phones append to a JPEG.
+type metaBlock struct {
+	kind string
+	raw  []byte
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// splitImage splits the JPEG or PNG b into its signature and blocks, false
+// for anything else.
+func splitImage(b []byte) ([]byte, []metaBlock, bool) {
+	if bytes.HasPrefix(b, pngSignature) {
+		blocks, ok := pngBlocks(b[len(pngSignature):])
+		return b[:len(pngSignature)], blocks, ok
+	}
+	if len(b) > 2 && b[0] == 0xFF && b[1] == 0xD8 {
+		blocks, ok := jpegBlocks(b[2:])
+		return b[:2], blocks, ok
+	}
+	return nil, nil, false
+}
+
+// jpegBlocks splits the segments of a JPEG after the SOI. The scans up to
+// the EOI make one block.
+func jpegBlocks(b []byte) ([]metaBlock, bool) {
+	var blocks []metaBlock
+	for len(b) > 0 {
+		if len(b) < 2 || b[0] != 0xFF {
+			return nil, false
+		}
+		m := b[1]
+		switch {
+		case m == 0xFF:
+			// Fill byte.
+			blocks = append(blocks, metaBlock{raw: b[:1]})
+			b = b[1:]
+			continue
+		case m == 0xDA || m == 0xD9:
+			// Entropy-coded data never holds FF D9, so the first is the EOI.
+			end := bytes.Index(b, []byte{0xFF, 0xD9})
+			if end < 0 {
+				return nil, false
+			}
+			blocks = append(blocks, metaBlock{raw: b[:end+2]})
+			if rest := b[end+2:]; len(rest) > 0 {
+				blocks = append(blocks, metaBlock{kind: "other", raw: rest})
+			}
+			return blocks, true
+		case m == 0x01 || m >= 0xD0 && m <= 0xD7:
+			blocks = append(blocks, metaBlock{raw: b[:2]})
+			b = b[2:]
+			continue
+		}
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := int(b[2])<<8 | int(b[3])
+		if n < 2 || 2+n > len(b) {
+			return nil, false
+		}
+		seg := b[:2+n]
+		blocks = append(blocks, metaBlock{kind: jpegKind(m, seg[4:]), raw: seg})
+		b = b[2+n:]
+	}
+	return blocks, true
+}
+
+func jpegKind(marker byte, payload []byte) string {
+	switch marker {
+	case 0xE0, 0xEE:
+		// JFIF and Adobe headers tell how to decode the image.
+		return ""
+	case 0xE1:
+		if bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return "exif"
+		}
+		if bytes.HasPrefix(payload, []byte("http://ns.adobe.com/")) {
+			return "xmp"
+		}
+	case 0xE2:
+		if bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")) {
+			return "icc"
+		}
+	case 0xED:
+		return "iptc"
+	case 0xFE:
+		return "comment"
+	}
+	if marker >= 0xE0 && marker <= 0xEF {
+		return "other"
+	}
+	return ""
+}
+
+// pngBlocks splits the chunks of a PNG after the signature.
+func pngBlocks(b []byte) ([]metaBlock, bool) {
+	var blocks []metaBlock
+	for len(b) > 0 {
+		if len(b) < 12 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		if n < 0 || n > len(b)-12 {
+			return nil, false
+		}
+		chunk := b[:12+n]
+		typ, data := string(chunk[4:8]), chunk[8:8+n]
+		kind := ""
+		switch typ {
+		case "eXIf":
+			kind = "exif"
+		case "iCCP":
+			kind = "icc"
+		case "iTXt":
+			kind = "comment"
+			if bytes.HasPrefix(data, []byte("XML:com.adobe.xmp\x00")) {
+				kind = "xmp"
+			}
+		case "tEXt", "zTXt":
+			kind = "comment"
+		case "tIME":
+			kind = "other"
+		}
+		blocks = append(blocks, metaBlock{kind: kind, raw: chunk})
+		b = b[12+n:]
+		if typ == "IEND" {
+			if len(b) > 0 {
+				blocks = append(blocks, metaBlock{kind: "other", raw: b})
+			}
+			break
+		}
+	}
+	return blocks, true
+}
+
+// keeps reports whether metadata of kind stays when keeping keep.
+func keeps(keep []string, kind string) bool {
+	switch kind {
+	case "":
+		return true
+	case "other":
+		return false
+	}
+	return slices.Contains(keep, kind)
+}
+
+// StripMetadata returns the JPEG or PNG b without the metadata whose kind
+// (see MetadataKinds) is not in keep. Other files are returned as they are.
+func StripMetadata(b []byte, keep []string) []byte {
+	head, blocks, ok := splitImage(b)
+	if !ok {
+		return b
+	}
+	png := len(head) == len(pngSignature)
+	out := append([]byte(nil), head...)
+	for _, bl := range blocks {
+		if keeps(keep, bl.kind) {
+			out = append(out, cleanBlock(bl, png, keep, false)...)
+		}
+	}
+	return out
+}
+
+// StripSourceMetadata is StripMetadata for source images saved into the
+// site, such as CMS uploads, which the build reads again later. Unless keep
+// has exif, their EXIF is cut down to what the build reads from it, the
+// orientation, capture date, camera and caption, rather than dropped.
+func StripSourceMetadata(b []byte, keep []string) []byte {
+	head, blocks, ok := splitImage(b)
+	if !ok {
+		return b
+	}
+	png := len(head) == len(pngSignature)
+	out := append([]byte(nil), head...)
+	for _, bl := range blocks {
+		switch {
+		case keeps(keep, bl.kind):
+			out = append(out, cleanBlock(bl, png, keep, false)...)
+		case bl.kind == "exif":
+			out = append(out, sourceExif(bl, png)...)
+		}
+	}
+	return out
+}
+
+// sourceExifTags are the IFD0 and EXIF IFD tags parseExif reads.
+var sourceExifTags = [2][]int{{0x010E, 0x010F, 0x0110, 0x0112, 0x0132}, {0x9003, 0x9011}}
+
+// sourceExif returns the EXIF block bl with only the sourceExifTags, nil
+// when it has none of them.
+func sourceExif(bl metaBlock, png bool) []byte {
+	data := bl.raw[4+len("Exif\x00\x00"):]
+	if png {
+		data = bl.raw[8 : len(bl.raw)-4]
+	}
+	t, ok := newTIFF(data)
+	if !ok {
+		return nil
+	}
+	tiff := t.subset(sourceExifTags[0], sourceExifTags[1])
+	if tiff == nil {
+		return nil
+	}
+	if png {
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+		chunk = append(append(chunk, "eXIf"...), tiff...)
+		return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	}
+	n := 2 + len("Exif\x00\x00") + len(tiff)
+	if n > 0xFFFF {
+		return nil
+	}
+	seg := append([]byte{0xFF, 0xE1, byte(n >> 8), byte(n)}, "Exif\x00\x00"...)
+	return append(seg, tiff...)
+}
+
+// cleanBlock returns the kept block bl, from its EXIF the location unless
+// keep has gps and, when upright, the orientation.
+func cleanBlock(bl metaBlock, png bool, keep []string, upright bool) []byte {
+	if bl.kind != "exif" {
+		return bl.raw
+	}
+	raw := bytes.Clone(bl.raw)
+	data := raw[8 : len(raw)-4]
+	if !png {
+		data = raw[4+len("Exif\x00\x00"):]
+	}
+	t, ok := newTIFF(data)
+	if !ok {
+		return bl.raw
+	}
+	if !slices.Contains(keep, "gps") {
+		t.removeGPS()
+	}
+	if upright {
+		if e := t.find(t.ifd0(), 0x0112); e > 0 && t.u16(e+2) == 3 {
+			t.put16(e+8, 1)
+		}
+	}
+	if png {
+		binary.BigEndian.PutUint32(raw[len(raw)-4:], crc32.ChecksumIEEE(raw[4:len(raw)-4]))
+	}
+	return raw
+}
+
+// imageMetadata is the metadata of a source image kept in the images
+// encoded from it.
+type imageMetadata struct {
+	png    bool
+	blocks [][]byte
+}
+
+// keptMetadata returns the metadata of the JPEG or PNG b that keep keeps,
+// with the orientation made upright, as the images encoded from it are.
+func keptMetadata(b []byte, keep []string) *imageMetadata {
+	head, blocks, ok := splitImage(b)
+	if !ok {
+		return nil
+	}
+	m := &imageMetadata{png: len(head) == len(pngSignature)}
+	for _, bl := range blocks {
+		if bl.kind != "" && keeps(keep, bl.kind) {
+			m.blocks = append(m.blocks, cleanBlock(bl, m.png, keep, true))
+		}
+	}
+	return m
+}
+
+// into adds the metadata to encoded, if it is an image of the same format.
+func (m *imageMetadata) into(encoded []byte) []byte {
+	if m == nil || len(m.blocks) == 0 {
+		return encoded
+	}
+	head, blocks, ok := splitImage(encoded)
+	if !ok || (len(head) == len(pngSignature)) != m.png {
+		return encoded
+	}
+	// After the PNG IHDR or JFIF header, which come first.
+	at := 0
+	if len(blocks) > 0 && (m.png || blocks[0].raw[1] == 0xE0) {
+		at = 1
+	}
+	out := append([]byte(nil), head...)
+	for i, bl := range blocks {
+		if i == at {
+			for _, mb := range m.blocks {
+				out = append(out, mb...)
+			}
+		}
+		out = append(out, bl.raw...)
+	}
+	return out
+}
+
+// parseExif reads the EXIF of the JPEG or PNG b.
+func parseExif(b []byte) exifInfo {
+	var info exifInfo
+	head, blocks, ok := splitImage(b)
+	if !ok {
+		return info
+	}
+	var t *tiff
+	for _, bl := range blocks {
+		if bl.kind != "exif" {
+			continue
+		}
+		data := bl.raw[4+len("Exif\x00\x00"):]
+		if len(head) == len(pngSignature) {
+			data = bl.raw[8 : len(bl.raw)-4]
+		}
+		if t, ok = newTIFF(data); ok {
+			break
+		}
+	}
+	if t == nil {
+		return info
+	}
+	ifd0 := t.ifd0()
+	if e := t.find(ifd0, 0x0112); e > 0 {
+		if o := t.uint(e); o >= 1 && o <= 8 {
+			info.orientation = o
+		}
+	}
+	info.make = t.str(t.find(ifd0, 0x010F))
+	info.model = t.str(t.find(ifd0, 0x0110))
+	info.caption = t.str(t.find(ifd0, 0x010E))
+	date, zone := t.str(t.find(ifd0, 0x0132)), ""
+	if e := t.find(ifd0, 0x8769); e > 0 {
+		sub := t.uint(e)
+		if d := t.str(t.find(sub, 0x9003)); d != "" {
+			date = d
+		}
+		zone = t.str(t.find(sub, 0x9011))
+	}
+	const layout = "2006:01:02 15:04:05"
+	if d, err := time.Parse(layout+"-07:00", date+zone); err == nil {
+		info.date = d
+	} else if d, err := time.Parse(layout, date); err == nil {
+		info.date = d
+	}
+	return info
+}
+
+// tiff reads and edits the TIFF structure EXIF is stored in.
+type tiff struct {
+	b  []byte
+	bo binary.ByteOrder
+}
+
+func newTIFF(b []byte) (*tiff, bool) {
+	if len(b) < 8 {
+		return nil, false
+	}
+	t := &tiff{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.bo = binary.LittleEndian
+	case "MM":
+		t.bo = binary.BigEndian
+	default:
+		return nil, false
+	}
+	if t.u16(2) != 42 {
+		return nil, false
+	}
+	return t, true
+}
+
+// in reports whether n bytes at off are within the data.
+func (t *tiff) in(off, n int) bool {
+	return off >= 0 && n >= 0 && off <= len(t.b) && n <= len(t.b)-off
+}
+
+func (t *tiff) u16(off int) int {
+	if !t.in(off, 2) {
+		return 0
+	}
+	return int(t.bo.Uint16(t.b[off:]))
+}
+
+func (t *tiff) u32(off int) int {
+	if !t.in(off, 4) {
+		return 0
+	}
+	return int(t.bo.Uint32(t.b[off:]))
+}
+
+func (t *tiff) put16(off, v int) {
+	if t.in(off, 2) {
+		t.bo.PutUint16(t.b[off:], uint16(v))
+	}
+}
+
+func (t *tiff) ifd0() int { return t.u32(4) }
+
+// entries returns the number of entries of the IFD at off, 0 if it is not
+// within the data.
+func (t *tiff) entries(off int) int {
+	n := t.u16(off)
+	if off < 8 || !t.in(off+2, 12*n+4) {
+		return 0
+	}
+	return n
+}
+
+// find returns the offset of the entry for tag in the IFD at off, 0 if
+// there is none.
+func (t *tiff) find(off, tag int) int {
+	for i := 0; i < t.entries(off); i++ {
+		if e := off + 2 + 12*i; t.u16(e) == tag {
+			return e
+		}
+	}
+	return 0
+}
+
+// typeSizes are the sizes of the TIFF field types.
+var typeSizes = map[int]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// value returns the data of the entry at e, nil if it is not within the
+// data.
+func (t *tiff) value(e int) []byte {
+	size := typeSizes[t.u16(e+2)]
+	count := t.u32(e + 4)
+	if size == 0 || count > len(t.b) {
+		return nil
+	}
+	n := size * count
+	off := e + 8
+	if n > 4 {
+		off = t.u32(e + 8)
+	}
+	if !t.in(off, n) {
+		return nil
+	}
+	return t.b[off : off+n]
+}
+
+// uint returns the value of the SHORT or LONG entry at e.
+func (t *tiff) uint(e int) int {
+	if e == 0 {
+		return 0
+	}
+	switch t.u16(e + 2) {
+	case 3:
+		return t.u16(e + 8)
+	case 4:
+		return t.u32(e + 8)
+	}
+	return 0
+}
+
+// str returns the value of the ASCII entry at e.
+func (t *tiff) str(e int) string {
+	if e == 0 || t.u16(e+2) != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(t.value(e)), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// subset returns a new TIFF in the same byte order holding only the IFD0
+// entries for tags and the EXIF IFD entries for exifTags, nil if there are
+// none.
+func (t *tiff) subset(tags, exifTags []int) []byte {
+	pick := func(ifd int, tags []int) []int {
+		var es []int
+		for _, tag := range tags {
+			if e := t.find(ifd, tag); e > 0 && t.value(e) != nil {
+				es = append(es, e)
+			}
+		}
+		return es
+	}
+	ifd0 := pick(t.ifd0(), tags)
+	var exif []int
+	if e := t.find(t.ifd0(), 0x8769); e > 0 {
+		exif = pick(t.uint(e), exifTags)
+	}
+	if len(ifd0)+len(exif) == 0 {
+		return nil
+	}
+	size := func(n int) int { return 2 + 12*n + 4 }
+	n0 := len(ifd0)
+	if len(exif) > 0 {
+		n0++
+	}
+	exifOff := 8 + size(n0)
+	out := make([]byte, exifOff, exifOff+len(t.b))
+	if len(exif) > 0 {
+		out = out[:exifOff+size(len(exif))]
+	}
+	copy(out, t.b[:4])
+	t.bo.PutUint32(out[4:], 8)
+	// write puts the entries es at off, their values past 4 bytes at the
+	// end of out; tags are in ascending order, as TIFF wants.
+	write := func(off int, es []int, exifPtr bool) {
+		n := len(es)
+		if exifPtr {
+			n++
+		}
+		t.bo.PutUint16(out[off:], uint16(n))
+		for i, e := range es {
+			p := off + 2 + 12*i
+			copy(out[p:p+8], t.b[e:e+8])
+			if v := t.value(e); len(v) <= 4 {
+				copy(out[p+8:p+12], v)
+			} else {
+				if len(out)%2 == 1 {
+					out = append(out, 0)
+				}
+				t.bo.PutUint32(out[p+8:], uint32(len(out)))
+				out = append(out, v...)
+			}
+		}
+		if exifPtr {
+			p := off + 2 + 12*len(es)
+			t.bo.PutUint16(out[p:], 0x8769)
+			t.bo.PutUint16(out[p+2:], 4)
+			t.bo.PutUint32(out[p+4:], 1)
+			t.bo.PutUint32(out[p+8:], uint32(exifOff))
+		}
+	}
+	write(8, ifd0, len(exif) > 0)
+	if len(exif) > 0 {
+		write(exifOff, exif, false)
+	}
+	return out
+}
+
+// removeGPS drops the GPS IFD from IFD0 and zeroes its data.
+func (t *tiff) removeGPS() {
+	ifd0 := t.ifd0()
+	e := t.find(ifd0, 0x8825)
+	if e == 0 {
+		return
+	}
+	if gps := t.uint(e); gps > 0 {
+		n := t.entries(gps)
+		for i := 0; i < n; i++ {
+			if ge := gps + 2 + 12*i; typeSizes[t.u16(ge+2)]*t.u32(ge+4) > 4 {
+				clear(t.value(ge))
+			}
+		}
+		if n > 0 {
+			clear(t.b[gps : gps+2+12*n+4])
+		}
+	}
+	// Close up the entries after it and the next IFD offset.
+	n := t.entries(ifd0)
+	end := ifd0 + 2 + 12*n + 4
+	copy(t.b[e:], t.b[e+12:end])
+	clear(t.b[end-12 : end])
+	t.put16(ifd0, n-1)
+}
+
+// orient turns img, stored with EXIF orientation o, upright.
+func orient(img image.Image, o int) image.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			switch o {
+			case 2:
+				dx = w - 1 - x
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dy = h - 1 - y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,337 @@
+package sitegen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// tiffEntry is an IFD entry for buildExif: an ASCII string, a SHORT or a
+// RATIONAL triple.
+type tiffEntry struct {
+	tag uint16
+	val interface{}
+}
+
+// buildExif encodes IFD0 and the EXIF and GPS IFDs it points at, when not
+// empty, as a little-endian TIFF.
+func buildExif(ifd0, exif, gps []tiffEntry) []byte {
+	le := binary.LittleEndian
+	size := func(n int) int { return 2 + 12*n + 4 }
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, tiffEntry{0x8769, uint32(0)})
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, tiffEntry{0x8825, uint32(0)})
+	}
+	offs := []int{8, 8 + size(len(ifd0)), 8 + size(len(ifd0)) + size(len(exif))}
+	data := offs[2] + size(len(gps))
+	b := make([]byte, data)
+	copy(b, "II*\x00\x08\x00\x00\x00")
+	for k, ifd := range [][]tiffEntry{ifd0, exif, gps} {
+		if len(ifd) == 0 {
+			continue
+		}
+		off := offs[k]
+		le.PutUint16(b[off:], uint16(len(ifd)))
+		for i, e := range ifd {
+			p := off + 2 + 12*i
+			le.PutUint16(b[p:], e.tag)
+			var typ uint16
+			var v []byte
+			switch val := e.val.(type) {
+			case string:
+				typ, v = 2, append([]byte(val), 0)
+			case uint16:
+				typ, v = 3, le.AppendUint16(nil, val)
+			case uint32:
+				typ, v = 4, le.AppendUint32(nil, val)
+				if e.tag == 0x8769 {
+					v = le.AppendUint32(nil, uint32(offs[1]))
+				} else if e.tag == 0x8825 {
+					v = le.AppendUint32(nil, uint32(offs[2]))
+				}
+			case [3]uint32:
+				typ = 5
+				for _, n := range val {
+					v = le.AppendUint32(le.AppendUint32(v, n), 1)
+				}
+			}
+			le.PutUint16(b[p+2:], typ)
+			count := len(v)
+			if typ == 3 {
+				count = 1
+			} else if typ == 4 || typ == 5 {
+				count = len(v) / map[uint16]int{4: 4, 5: 8}[typ]
+			}
+			le.PutUint32(b[p+4:], uint32(count))
+			if len(v) <= 4 {
+				copy(b[p+8:], v)
+			} else {
+				le.PutUint32(b[p+8:], uint32(len(b)))
+				b = append(b, v...)
+			}
+		}
+	}
+	return b
+}
+
+// withExif puts tiff in an APP1 segment after the SOI of the JPEG img.
+func withExif(img, tiff []byte, extra ...[]byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := append([]byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+	out := append([]byte(nil), img[:2]...)
+	out = append(out, seg...)
+	for _, e := range extra {
+		out = append(out, e...)
+	}
+	return append(out, img[2:]...)
+}
+
+// segment is a JPEG segment with the given marker and payload.
+func segment(marker byte, payload string) []byte {
+	return append([]byte{0xFF, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+}
+
+// phoneJPEG is a w x h JPEG, left half red and right half blue, with the
+// EXIF of a phone photo stored with orientation o.
+func phoneJPEG(t *testing.T, w, h int, o uint16, extra ...[]byte) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{220, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 220, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := buildExif(
+		[]tiffEntry{{0x010E, "Harbour at dawn"}, {0x010F, "Apple"}, {0x0110, "iPhone 15"}, {0x0112, o}},
+		[]tiffEntry{{0x9003, "2024:05:06 07:08:09"}, {0x9011, "+02:00"}},
+		[]tiffEntry{{0x0001, "N"}, {0x0002, [3]uint32{52, 22, 7}}},
+	)
+	return withExif(buf.Bytes(), tiff, extra...)
+}
+
+func hasGPS(b []byte) bool {
+	_, blocks, _ := splitImage(b)
+	for _, bl := range blocks {
+		if bl.kind == "exif" {
+			data := bl.raw[10:]
+			if bytes.HasPrefix(b, pngSignature) {
+				data = bl.raw[8 : len(bl.raw)-4]
+			}
+			t, _ := newTIFF(data)
+			return t.find(t.ifd0(), 0x8825) > 0 || bytes.Contains(bl.raw, []byte{52, 0, 0, 0, 1, 0, 0, 0, 22})
+		}
+	}
+	return false
+}
+
+func TestParseExif(t *testing.T) {
+	info := parseExif(phoneJPEG(t, 8, 4, 6))
+	want := exifInfo{
+		orientation: 6,
+		date:        time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("", 2*3600)),
+		make:        "Apple",
+		model:       "iPhone 15",
+		caption:     "Harbour at dawn",
+	}
+	if info.orientation != want.orientation || !info.date.Equal(want.date) || info.make != want.make || info.model != want.model || info.caption != want.caption {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+	if info := parseExif(createTestJPEG(t, 4, 4)); info != (exifInfo{}) {
+		t.Errorf("no EXIF: %+v", info)
+	}
+	if info := parseExif([]byte("\xFF\xD8\xFF\xE1\x00\x0AExif\x00\x00MM")); info != (exifInfo{}) {
+		t.Errorf("truncated EXIF: %+v", info)
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	src := phoneJPEG(t, 8, 4, 1,
+		segment(0xE1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"),
+		segment(0xE2, "ICC_PROFILE\x00\x01\x01profile"),
+		segment(0xED, "Photoshop 3.0\x00iptc"),
+		segment(0xFE, "a comment"))
+	src = append(src, "trailing gain map"...)
+
+	out := StripMetadata(src, DefaultKeepMetadata)
+	for _, gone := range []string{"Exif", "xmpmeta", "iptc", "a comment", "trailing"} {
+		if bytes.Contains(out, []byte(gone)) {
+			t.Errorf("default keeps %q", gone)
+		}
+	}
+	if !bytes.Contains(out, []byte("ICC_PROFILE")) {
+		t.Error("default drops the color profile")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped JPEG: %v", err)
+	}
+
+	out = StripMetadata(src, []string{"exif"})
+	if info := parseExif(out); info.camera() != "Apple iPhone 15" {
+		t.Errorf("exif kept: %+v", info)
+	}
+	if hasGPS(out) {
+		t.Error("exif without gps keeps the location")
+	}
+	if !hasGPS(StripMetadata(src, []string{"exif", "gps"})) {
+		t.Error("gps not kept")
+	}
+	if !hasGPS(src) {
+		t.Error("source has no location to strip")
+	}
+
+	// PNG keeps its EXIF in an eXIf chunk, whose CRC must stay right.
+	pngSrc := createTestPNG(t, 4, 4)
+	tiff := buildExif([]tiffEntry{{0x010F, "Apple"}}, nil, []tiffEntry{{0x0002, [3]uint32{52, 22, 7}}})
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+	chunk = append(append(chunk, "eXIf"...), tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	text := []byte("\x00\x00\x00\x07tEXtKey\x00val\x00\x00\x00\x00")
+	pngSrc = slices.Concat(pngSrc[:33], chunk, text, pngSrc[33:])
+	out = StripMetadata(pngSrc, []string{"exif"})
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped PNG: %v", err)
+	}
+	if parseExif(out).make != "Apple" || hasGPS(out) || bytes.Contains(out, []byte("tEXt")) {
+		t.Errorf("stripped PNG EXIF %+v, GPS %v", parseExif(out), hasGPS(out))
+	}
+
+	plain := createTestJPEG(t, 4, 4)
+	if !bytes.Equal(StripMetadata(plain, nil), plain) {
+		t.Error("JPEG without metadata changed")
+	}
+	if b := []byte("GIF89a"); !bytes.Equal(StripMetadata(b, nil), b) {
+		t.Error("GIF changed")
+	}
+}
+
+func TestStripSourceMetadata(t *testing.T) {
+	tiff := buildExif(
+		[]tiffEntry{{0x010E, "Harbour at dawn"}, {0x010F, "Apple"}, {0x0110, "iPhone 15"}, {0x0112, uint16(6)}, {0x013B, "Jane Owner"}},
+		[]tiffEntry{{0x9003, "2024:05:06 07:08:09"}, {0x9011, "+02:00"}, {0xA431, "SN-12345"}},
+		[]tiffEntry{{0x0001, "N"}, {0x0002, [3]uint32{52, 22, 7}}},
+	)
+	src := withExif(createTestJPEG(t, 8, 4), tiff)
+
+	out := StripSourceMetadata(src, DefaultKeepMetadata)
+	if got, want := parseExif(out), parseExif(src); got != want {
+		t.Errorf("EXIF read back = %+v, want %+v", got, want)
+	}
+	for _, gone := range []string{"Jane Owner", "SN-12345"} {
+		if bytes.Contains(out, []byte(gone)) {
+			t.Errorf("kept %q", gone)
+		}
+	}
+	if hasGPS(out) {
+		t.Error("kept the location")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped JPEG: %v", err)
+	}
+	if out := StripSourceMetadata(src, []string{"exif"}); !bytes.Contains(out, []byte("SN-12345")) || hasGPS(out) {
+		t.Error("exif kept: want the full EXIF without the location")
+	}
+	if plain := createTestJPEG(t, 4, 4); !bytes.Equal(StripSourceMetadata(plain, nil), plain) {
+		t.Error("JPEG without metadata changed")
+	}
+}
+
+func TestProcessImage_Orientation(t *testing.T) {
+	for _, keep := range [][]string{DefaultKeepMetadata, {"exif"}} {
+		pubPath := filepath.Join(t.TempDir(), "photo.jpg")
+		sg := &SiteGen{KeepMetadata: keep}
+		if _, err := sg.processImage(phoneJPEG(t, 40, 20, 6), pubPath, ".jpg"); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(pubPath)
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Turned clockwise: the red left half is now on top.
+		if s := img.Bounds().Size(); s != image.Pt(20, 40) {
+			t.Fatalf("%v: size %v, want 20x40", keep, s)
+		}
+		if r, _, bl, _ := img.At(10, 5).RGBA(); r < bl {
+			t.Errorf("%v: top is not red", keep)
+		}
+		info := parseExif(b)
+		if keep[0] == "icc" && info != (exifInfo{}) {
+			t.Errorf("default keeps EXIF %+v", info)
+		}
+		if keep[0] == "exif" && (info.orientation != 1 || info.caption == "" || hasGPS(b)) {
+			t.Errorf("%v: published EXIF %+v, GPS %v", keep, info, hasGPS(b))
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 3x2, numbered in reading order.
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		src.Set(i%3, i/3, color.RGBA{uint8(i), 0, 0, 255})
+	}
+	for o, want := range map[int][]uint8{
+		2: {2, 1, 0, 5, 4, 3},
+		3: {5, 4, 3, 2, 1, 0},
+		4: {3, 4, 5, 0, 1, 2},
+		5: {0, 3, 1, 4, 2, 5},
+		6: {3, 0, 4, 1, 5, 2},
+		7: {5, 2, 4, 1, 3, 0},
+		8: {2, 5, 1, 4, 0, 3},
+	} {
+		img := orient(src, o).(*image.RGBA)
+		w := img.Bounds().Dx()
+		var got []uint8
+		for i := range 6 {
+			got = append(got, img.RGBAAt(i%w, i/w).R)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("orientation %d: %v, want %v", o, got, want)
+		}
+	}
+}
+
+func TestImageMetadata(t *testing.T) {
+	site := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(site, "src", "img"), 0755)
+	if err := os.WriteFile(filepath.Join(site, "src", "img", "photo.jpg"), phoneJPEG(t, 40, 20, 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	page := `{{$p := image "img/photo.jpg"}}{{$p.Width}}x{{$p.Height}} {{$p.Camera}} {{$p.Date.UTC.Format "2006-01-02 15:04"}} {{$p.Caption}}
+{{with $p.Resize "10x"}}{{.Width}}x{{.Height}}{{end}}`
+	if err := os.WriteFile(filepath.Join(site, "src", "page.html"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if want := "20x40 Apple iPhone 15 2024-05-06 05:08 Harbour at dawn\n10x20"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+	b, _ = os.ReadFile(filepath.Join(pub, "img", "photo.jpg"))
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(b)); err != nil || cfg.Width != 20 || hasGPS(b) || parseExif(b).make != "" {
+		t.Errorf("published %dx%d %v, GPS %v, EXIF %+v", cfg.Width, cfg.Height, err, hasGPS(b), parseExif(b))
+	}
+}
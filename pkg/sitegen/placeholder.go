@@ -0,0 +1,285 @@
+package sitegen
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+)
+
+// placeholder.go computes what a page shows while an image loads, without
+// any script: a tiny blurred copy of the image as a data URI, its BlurHash
+// (https://blurha.sh) for sites decoding one client side, and its dominant
+// color. They are computed from the image as published, once per image and
+// operations, and are available on Image and, with imagePlaceholder set,
+// as the background of the img tags showing the site's images:
+//
+//	<img src="{{.}}" style="background:{{.Color}}">
+
+const (
+	// placeholderSize is the longest side of the blurred copy.
+	placeholderSize = 16
+	// sampleSize is the longest side of the copy the BlurHash and the
+	// color are computed from.
+	sampleSize = 32
+)
+
+// placeholder is the loading placeholders of one image.
+type placeholder struct {
+	dataURI  string
+	blurHash string
+	color    string
+}
+
+// placeholderJob computes the placeholders of one image once.
+type placeholderJob struct {
+	once sync.Once
+	p    placeholder
+	err  error
+}
+
+// Placeholder is a tiny blurred copy of the image as a PNG data URI, a few
+// hundred bytes to show stretched over the image's box while it loads.
+func (i *Image) Placeholder() (template.URL, error) {
+	p, err := i.placeholder()
+	return template.URL(p.dataURI), err
+}
+
+// BlurHash is the image's BlurHash, a short string blurhash libraries decode
+// to a blurred preview.
+func (i *Image) BlurHash() (string, error) {
+	p, err := i.placeholder()
+	return p.blurHash, err
+}
+
+// Color is the image's dominant color, as #rrggbb.
+func (i *Image) Color() (string, error) {
+	p, err := i.placeholder()
+	return p.color, err
+}
+
+// placeholder computes the image's placeholders, again only once the source
+// image or the operations change.
+func (i *Image) placeholder() (placeholder, error) {
+	key := fmt.Sprintf("%s %v", i.info.hash, i.ops)
+	c := i.sg.images()
+	c.mu.Lock()
+	job, ok := c.placeholders[key]
+	if !ok {
+		job = &placeholderJob{}
+		c.placeholders[key] = job
+	}
+	c.mu.Unlock()
+	job.once.Do(func() {
+		img, _, err := i.decode()
+		if err != nil {
+			job.err = fmt.Errorf("image %s: %w", i.rel, err)
+			return
+		}
+		job.p, job.err = newPlaceholder(img)
+	})
+	if job.err != nil {
+		c.mu.Lock()
+		delete(c.placeholders, key)
+		c.mu.Unlock()
+	}
+	return job.p, job.err
+}
+
+// newPlaceholder computes the placeholders of img.
+func newPlaceholder(img image.Image) (placeholder, error) {
+	var p placeholder
+	tiny := boxBlur(toNRGBA(thumbnail(img, placeholderSize)))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tiny); err != nil {
+		return p, err
+	}
+	p.dataURI = "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	sample := toNRGBA(thumbnail(img, sampleSize))
+	cx, cy := 4, 3
+	if b := sample.Bounds(); b.Dy() > b.Dx() {
+		cx, cy = 3, 4
+	}
+	p.blurHash = blurHash(sample, cx, cy)
+	p.color = dominantColor(sample)
+	return p, nil
+}
+
+// thumbnail scales img so its longest side is size, keeping the aspect
+// ratio.
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		w, h = min(size, w), scaled(h, min(size, w), w)
+	} else {
+		w, h = scaled(w, min(size, h), h), min(size, h)
+	}
+	return scaleImage(img, w, h)
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok {
+		return n
+	}
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.Set(x, y, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return out
+}
+
+// boxBlur averages every pixel of img with its neighbours.
+func boxBlur(img *image.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var sum [4]int
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if !image.Pt(x+dx, y+dy).In(b) {
+						continue
+					}
+					c := img.NRGBAAt(x+dx, y+dy)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+					n++
+				}
+			}
+			out.SetNRGBA(x, y, color.NRGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)})
+		}
+	}
+	return out
+}
+
+// dominantColor is the average of the most common colors of img, grouped
+// coarsely, leaving out what is mostly transparent.
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct{ r, g, b, n int }
+	buckets := map[int]*bucket{}
+	var best *bucket
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 128 {
+				continue
+			}
+			k := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			bk := buckets[k]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[k] = bk
+			}
+			bk.r += int(c.R)
+			bk.g += int(c.G)
+			bk.b += int(c.B)
+			bk.n++
+			if best == nil || bk.n > best.n {
+				best = bk
+			}
+		}
+	}
+	if best == nil {
+		return "transparent"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.n, best.g/best.n, best.b/best.n)
+}
+
+// blurHash encodes img with cx x cy components as described in
+// https://github.com/woltapp/blurhash/blob/master/Algorithm.md.
+func blurHash(img *image.NRGBA, cx, cy int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					c := img.NRGBAAt(b.Min.X+x, b.Min.Y+y)
+					f[0] += basis * srgbToLinear(c.R)
+					f[1] += basis * srgbToLinear(c.G)
+					f[2] += basis * srgbToLinear(c.B)
+				}
+			}
+			scale := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	encode83(&sb, (cx-1)+(cy-1)*9, 1)
+	maxAC := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, f := range factors[1:] {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantised := int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(quantised+1) / 166
+		encode83(&sb, quantised, 1)
+	} else {
+		encode83(&sb, 0, 1)
+	}
+	dc := factors[0]
+	encode83(&sb, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range factors[1:] {
+		q := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maxAC, 0.5)*9+9.5))))
+		}
+		encode83(&sb, q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return sb.String()
+}
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encode83 writes n as length base 83 digits.
+func encode83(sb *strings.Builder, n, length int) {
+	for i := length - 1; i >= 0; i-- {
+		d := n
+		for k := 0; k < i; k++ {
+			d /= 83
+		}
+		sb.WriteByte(base83[d%83])
+	}
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
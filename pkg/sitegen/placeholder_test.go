@@ -0,0 +1,63 @@
+package sitegen
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func fill(w, h int, c func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c(x, y))
+		}
+	}
+	return img
+}
+
+func TestBlurHash(t *testing.T) {
+	red := fill(8, 8, func(x, y int) color.NRGBA { return color.NRGBA{255, 0, 0, 255} })
+	// One component: the size flag, no AC maximum, then #ff0000.
+	if got := blurHash(red, 1, 1); got != "00TI:j" {
+		t.Errorf("1x1 = %q, want 00TI:j", got)
+	}
+	grad := fill(32, 18, func(x, y int) color.NRGBA { return color.NRGBA{uint8(x * 8), uint8(y * 14), 128, 255} })
+	got := blurHash(grad, 4, 3)
+	if len(got) != 2+4+2*11 || got[0] != 'L' {
+		t.Errorf("4x3 = %q", got)
+	}
+	if blurHash(grad, 4, 3) != got {
+		t.Error("not deterministic")
+	}
+}
+
+func TestNewPlaceholder(t *testing.T) {
+	// Mostly blue with a red stripe.
+	img := fill(200, 400, func(x, y int) color.NRGBA {
+		if x < 40 {
+			return color.NRGBA{200, 0, 0, 255}
+		}
+		return color.NRGBA{0, 0, 255, 255}
+	})
+	p, err := newPlaceholder(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.color != "#0000ff" {
+		t.Errorf("color = %s", p.color)
+	}
+	if !strings.HasPrefix(p.dataURI, "data:image/png;base64,") || len(p.dataURI) > 1000 {
+		t.Errorf("data URI = %s", p.dataURI)
+	}
+	// Portrait images get more vertical components.
+	if p.blurHash[0] != 'T' || len(p.blurHash) != 28 {
+		t.Errorf("blurhash = %s", p.blurHash)
+	}
+
+	clear := fill(4, 4, func(x, y int) color.NRGBA { return color.NRGBA{} })
+	if p, _ := newPlaceholder(clear); p.color != "transparent" {
+		t.Errorf("transparent color = %s", p.color)
+	}
+}
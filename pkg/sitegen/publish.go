@@ -0,0 +1,79 @@
+package sitegen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// publish.go decides which sources are part of a build. A page marked
+// draft: true, with a publishDate still in the future or an expiryDate already
+// past is left out of BuildAll, GetSources, taxonomies and feeds (and thereby
+// listings and the sitemap) unless SiteGen.Drafts, Future or Expired include
+// it. Dates are checked against the time of the build.
+
+// Published reports whether s belongs in the current build.
+func (sg *SiteGen) Published(s *Source) bool {
+	if s.virtual {
+		return true
+	}
+	if !sg.Drafts && s.Draft() {
+		return false
+	}
+	now := time.Now()
+	if !sg.Future {
+		if t, ok := metaTime(s.Meta["publishDate"]); ok && t.After(now) {
+			return false
+		}
+	}
+	if !sg.Expired {
+		if t, ok := metaTime(s.Meta["expiryDate"]); ok && !t.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// Draft reports whether the source's frontmatter marks it draft: true, e.g. to
+// show a banner while drafts are previewed under serve.
+func (s *Source) Draft() bool {
+	return strings.EqualFold(fmt.Sprint(s.Meta["draft"]), "true")
+}
+
+// unpublish deletes what an earlier build of s wrote, so a page that became a
+// draft or expired disappears without a clean build.
+func (sg *SiteGen) unpublish(s *Source) {
+	outs := append([]string{sg.sourcePath(s)}, s.outputs...)
+	for _, o := range outs {
+		removeOutput(o)
+	}
+	s.outputs = nil
+}
+
+// removeOutput deletes a public file, and its directory once empty.
+func removeOutput(o string) {
+	if os.Remove(o) == nil {
+		if empty, err := isDirEmpty(filepath.Dir(o)); err == nil && empty {
+			os.Remove(filepath.Dir(o))
+		}
+	}
+}
+
+// metaTime parses a frontmatter date. YAML may hand over a time.Time or a
+// string; date-only and space-separated forms are read as UTC.
+func metaTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
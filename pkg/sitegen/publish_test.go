@@ -0,0 +1,78 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnpublishedPagesAreSkipped(t *testing.T) {
+	pub := t.TempDir()
+	past := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	future := time.Now().AddDate(0, 0, 2).Format("2006-01-02")
+	dir := writeSite(t, map[string]string{
+		"src/live.md":    "---\ntitle: Live\nexpiryDate: " + future + "\n---\nlive",
+		"src/draft.md":   "---\ntitle: Draft\ndraft: true\n---\ndraft",
+		"src/later.md":   "---\ntitle: Later\npublishDate: " + future + "\n---\nlater",
+		"src/gone.md":    "---\ntitle: Gone\nexpiryDate: " + past + "\n---\ngone",
+		"src/due.md":     "---\ntitle: Due\npublishDate: " + past + "\n---\ndue",
+		"src/index.html": `{{range sort "Meta.title" "asc" (sources "Ext" ".md")}}[{{.Meta.title}}]{{end}}`,
+	})
+	built := func(sg *SiteGen) []string {
+		var out []string
+		for _, n := range []string{"live", "draft", "later", "gone", "due"} {
+			if _, err := os.Stat(filepath.Join(pub, n, "index.html")); err == nil {
+				out = append(out, n)
+			}
+		}
+		sort.Strings(out)
+		return out
+	}
+	index := func() string {
+		b, _ := os.ReadFile(filepath.Join(pub, "index.html"))
+		return string(b)
+	}
+
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub})
+	stats, err := sg.BuildAll(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(built(sg), ","); got != "due,live" {
+		t.Errorf("built %s, want due,live", got)
+	}
+	if stats["unpublished"] != 3 {
+		t.Errorf("stats = %v", stats)
+	}
+	if got := index(); got != "[Due][Live]" {
+		t.Errorf("listing = %q", got)
+	}
+
+	// Each flag brings back its own kind of page.
+	sg.Drafts, sg.Future, sg.Expired = true, true, true
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(built(sg), ","); got != "draft,due,gone,later,live" {
+		t.Errorf("built %s with all flags", got)
+	}
+	if got := index(); got != "[Draft][Due][Gone][Later][Live]" {
+		t.Errorf("listing with all flags = %q", got)
+	}
+
+	// Turning a page into a draft removes what was built for it.
+	sg.Drafts, sg.Future, sg.Expired = false, false, false
+	os.WriteFile(filepath.Join(dir, "src/live.md"), []byte("---\ntitle: Live\ndraft: true\n---\nlive"), 0644)
+	if _, err := sg.BuildAll(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(built(sg), ","); got != "due" {
+		t.Errorf("built %s after drafting live, want due", got)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "live")); !os.IsNotExist(err) {
+		t.Errorf("empty output dir left behind: %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package sitegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// redirect.go keeps old URLs working. A page lists the paths it used to live
+// at under aliases: frontmatter, and sitegen.yaml maps further old paths under
+// redirects. Every redirect gets a meta-refresh page at the old path, which
+// works on any static host, and the configured serverFiles turn the same table
+// into config that lets hosts answer with a real 301: Netlify's _redirects
+// (and _headers for the headers section), an nginx snippet or an Apache
+// .htaccess. The dev server redirects from the table directly.
+
+// serverFiles maps each serverFiles entry to the file it is written to.
+var serverFiles = map[string]string{
+	"netlify": "_redirects",
+	"headers": "_headers",
+	"nginx":   "redirects.nginx.conf",
+	"apache":  ".htaccess",
+}
+
+// Redirect is one entry of the site's redirect table. From and To carry the
+// base path; To may also be an absolute URL.
+type Redirect struct {
+	From   string
+	To     string
+	Status int
+	// Source is the page declaring From as an alias, nil for a redirect
+	// from the site config.
+	Source *Source
+}
+
+// Redirects returns the redirect table of the last build, sorted by From.
+// The caller must hold sg.Mu.
+func (sg *SiteGen) Redirects() []Redirect {
+	return sg.redirects
+}
+
+// parseRedirect reads a redirects: value, a target optionally followed by
+// its status ("/new 302"). The status defaults to 301.
+func parseRedirect(v string) (string, int, error) {
+	f := strings.Fields(v)
+	switch len(f) {
+	case 1:
+		return f[0], http.StatusMovedPermanently, nil
+	case 2:
+		code, err := strconv.Atoi(f[1])
+		if err != nil || code < 300 || code > 399 {
+			return "", 0, fmt.Errorf("invalid status %q", f[1])
+		}
+		return f[0], code, nil
+	}
+	return "", 0, fmt.Errorf("want \"target [status]\", got %q", v)
+}
+
+// redirectTarget puts a site path under the base path, leaving URLs alone.
+func (sg *SiteGen) redirectTarget(to string) string {
+	if strings.Contains(to, "://") || strings.HasPrefix(to, "//") {
+		return to
+	}
+	return sg.Path(to)
+}
+
+// syncRedirects rebuilds the redirect table from the aliases of the published
+// pages and the site config, and replaces the virtual sources writing the
+// redirect pages and server files to match. An old path taken by a page stays
+// the page's. Pages of redirects that disappeared are removed from the public
+// dir. It returns the keys of the sources that are new or changed. The caller
+// must hold sg.Mu and no build may be running.
+func (sg *SiteGen) syncRedirects() []string {
+	oldHash := map[string]string{}
+	for k, s := range sg.sources {
+		if s.redirect != nil || s.serverFile != "" {
+			oldHash[k] = s.hash
+			delete(sg.sources, k)
+		}
+	}
+	old := sg.redirectPages
+	sg.redirectPages = map[string]string{}
+	sg.redirects = nil
+
+	taken := map[string]bool{}
+	var keys []string
+	for k, s := range sg.sources {
+		taken[redirectKey(s.Path)] = true
+		if !s.virtual && s.Err == nil && s.Meta["aliases"] != nil && sg.Published(s) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	from := map[string]string{}
+	add := func(r Redirect, by string) {
+		k := redirectKey(r.From)
+		switch {
+		case taken[k]:
+			log.Println("redirect", r.From, "from", by, "skipped: a page is built there")
+		case from[k] != "":
+			log.Println("redirect", r.From, "from", by, "skipped: already redirected by", from[k])
+		default:
+			from[k] = by
+			sg.redirects = append(sg.redirects, r)
+		}
+	}
+	for _, k := range keys {
+		s := sg.sources[k]
+		for _, a := range metaList(s.Meta["aliases"]) {
+			add(Redirect{From: sg.Path(a), To: s.Path, Status: http.StatusMovedPermanently, Source: s}, sg.rel(s.Local))
+		}
+	}
+	olds := make([]string, 0, len(sg.RedirectConfig))
+	for f := range sg.RedirectConfig {
+		olds = append(olds, f)
+	}
+	sort.Strings(olds)
+	for _, f := range olds {
+		to, code, err := parseRedirect(sg.RedirectConfig[f])
+		if err != nil {
+			log.Println("redirect", f, err)
+			continue
+		}
+		add(Redirect{From: sg.Path(f), To: sg.redirectTarget(to), Status: code}, "the site config")
+	}
+	sort.Slice(sg.redirects, func(i, j int) bool { return sg.redirects[i].From < sg.redirects[j].From })
+
+	for i := range sg.redirects {
+		r := &sg.redirects[i]
+		rel := strings.TrimPrefix(r.From, sg.BasePath)
+		name := "index.html"
+		if ext := fileExt(rel); ext == ".html" || ext == ".htm" {
+			name = ""
+		}
+		local := filepath.Join(sg.SitePath, sg.SourceDir, filepath.FromSlash(rel), name)
+		s := &Source{
+			Name:     filepath.Base(local),
+			Local:    local,
+			Ext:      ".html",
+			Ctype:    "text/html",
+			Meta:     map[string]interface{}{"path": rel},
+			content:  []byte{},
+			sg:       sg,
+			virtual:  true,
+			hash:     fmt.Sprintf("redirect:%s %d", r.To, r.Status),
+			redirect: r,
+			render:   sg.redirectPage,
+		}
+		s.Path = sg.LocalToPath(s)
+		sg.sources[local] = s
+		sg.redirectPages[local] = sg.sourcePath(s)
+	}
+
+	for _, f := range sg.ServerFiles {
+		name, ok := serverFiles[f]
+		if !ok {
+			log.Println("serverFiles: unknown format:", f)
+			continue
+		}
+		local := filepath.Join(sg.SitePath, sg.SourceDir, name)
+		if _, ok := sg.sources[local]; ok {
+			continue
+		}
+		s := &Source{
+			Name:       name,
+			Local:      local,
+			Ctype:      "text/plain",
+			Meta:       map[string]interface{}{"path": name},
+			content:    []byte{},
+			sg:         sg,
+			virtual:    true,
+			serverFile: f,
+			render:     sg.serverFile,
+		}
+		s.Path = sg.LocalToPath(s)
+		b, _ := sg.serverFile(s)
+		sum := sha256.Sum256(b)
+		s.hash = hex.EncodeToString(sum[:])
+		sg.sources[local] = s
+		sg.redirectPages[local] = sg.sourcePath(s)
+	}
+
+	var changed []string
+	for k, s := range sg.sources {
+		if (s.redirect != nil || s.serverFile != "") && oldHash[k] != s.hash {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	for k, out := range old {
+		if _, ok := sg.redirectPages[k]; !ok {
+			removeOutput(out)
+		}
+	}
+	return changed
+}
+
+var redirectTpl = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html{{with .Lang}} lang="{{.}}"{{end}}>
+<head>
+<meta charset="utf-8">
+<title>{{.To}}</title>
+<link rel="canonical" href="{{.URL}}">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.To}}">
+</head>
+<body><p>This page has moved to <a href="{{.To}}">{{.To}}</a>.</p></body>
+</html>
+`))
+
+// redirectPage renders the meta-refresh page of a virtual redirect source.
+func (sg *SiteGen) redirectPage(s *Source) ([]byte, error) {
+	r := s.redirect
+	data := map[string]string{"To": r.To, "URL": r.To, "Lang": sg.Site.Language}
+	if strings.HasPrefix(r.To, "/") && !strings.HasPrefix(r.To, "//") {
+		data["URL"] = sg.AbsURL(r.To)
+	}
+	if r.Source != nil && r.Source.Lang != "" {
+		data["Lang"] = r.Source.Lang
+	}
+	var buf bytes.Buffer
+	if err := redirectTpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("redirect %s: %w", r.From, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// serverFile renders the virtual source of one serverFiles format.
+func (sg *SiteGen) serverFile(s *Source) ([]byte, error) {
+	var buf bytes.Buffer
+	switch s.serverFile {
+	case "netlify":
+		for _, r := range sg.redirects {
+			fmt.Fprintf(&buf, "%s %s %d\n", r.From, r.To, r.Status)
+		}
+	case "headers":
+		paths := make([]string, 0, len(sg.HeaderConfig))
+		for p := range sg.HeaderConfig {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Fprintln(&buf, sg.Path(p))
+			names := make([]string, 0, len(sg.HeaderConfig[p]))
+			for n := range sg.HeaderConfig[p] {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				fmt.Fprintf(&buf, "  %s: %s\n", n, sg.HeaderConfig[p][n])
+			}
+		}
+	case "nginx":
+		for _, r := range sg.redirects {
+			fmt.Fprintf(&buf, "location ~ ^%s/?$ { return %d %s; }\n", redirectPattern(r.From), r.Status, r.To)
+		}
+	case "apache":
+		buf.WriteString("RewriteEngine On\n")
+		for _, r := range sg.redirects {
+			// Rules match relative to the directory of the .htaccess.
+			fmt.Fprintf(&buf, "RewriteRule ^%s/?$ %s [R=%d,L]\n", redirectPattern(strings.TrimPrefix(r.From, sg.BasePath)), r.To, r.Status)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// redirectKey is the path a request for p matches, ignoring a trailing
+// slash as the dev server and the generated server files do.
+func redirectKey(p string) string {
+	if p != "/" {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+// redirectPattern quotes a redirect's old path for a server regexp, without
+// its trailing slash, which the pattern makes optional.
+func redirectPattern(from string) string {
+	return regexp.QuoteMeta(strings.TrimSuffix(from, "/"))
+}
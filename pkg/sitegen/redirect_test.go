@@ -0,0 +1,148 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newRedirectSite(t *testing.T) (*SiteGen, string) {
+	t.Helper()
+	dir := writeSite(t, map[string]string{
+		"templates/main.html": `{{template "content" .}}`,
+		"src/blog/new.md":     "---\ntitle: New\ntemplate: main.html\naliases: [/old-post, 2019/old.html]\n---\nHi",
+		"src/about.md":        "---\ntitle: About\ntemplate: main.html\naliases: /blog/new\n---\nAbout",
+	})
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{
+		SitePath:   dir,
+		PublicPath: pub,
+		BasePath:   "/docs/",
+		Site:       Site{BaseURL: "https://example.com/docs/"},
+		Redirects: map[string]string{
+			"/guide/": "/about 302",
+			"chat":    "https://chat.example.com/",
+			"/about":  "/elsewhere",
+			"/broken": "/x 200",
+		},
+		Headers:     map[string]map[string]string{"/*": {"X-Frame-Options": "DENY"}},
+		ServerFiles: []string{"netlify", "headers", "nginx", "apache"},
+	})
+	return sg, filepath.Join(pub, "docs")
+}
+
+func TestRedirects(t *testing.T) {
+	sg, pub := newRedirectSite(t)
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	read := func(rel string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(pub, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Paths taken by pages and invalid entries are skipped.
+	var got []string
+	for _, r := range sg.Redirects() {
+		got = append(got, r.From+" "+r.To)
+	}
+	want := []string{
+		"/docs/2019/old.html /docs/blog/new",
+		"/docs/chat https://chat.example.com/",
+		"/docs/guide/ /docs/about",
+		"/docs/old-post /docs/blog/new",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("redirects =\n%s", strings.Join(got, "\n"))
+	}
+
+	page := read("old-post/index.html")
+	for _, s := range []string{
+		`<meta http-equiv="refresh" content="0; url=/docs/blog/new">`,
+		`<link rel="canonical" href="https://example.com/docs/blog/new">`,
+		`<meta name="robots" content="noindex">`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("alias page missing %q:\n%s", s, page)
+		}
+	}
+	if page := read("2019/old.html"); !strings.Contains(page, "url=/docs/blog/new") {
+		t.Errorf("html alias page = %s", page)
+	}
+	if page := read("chat/index.html"); !strings.Contains(page, "url=https://chat.example.com/") {
+		t.Errorf("external redirect page = %s", page)
+	}
+	if page := read("about/index.html"); page != "<p>About</p>\n" {
+		t.Errorf("page overwritten by redirect: %q", page)
+	}
+
+	if got, want := read("_redirects"), "/docs/2019/old.html /docs/blog/new 301\n"+
+		"/docs/chat https://chat.example.com/ 301\n"+
+		"/docs/guide/ /docs/about 302\n"+
+		"/docs/old-post /docs/blog/new 301\n"; got != want {
+		t.Errorf("_redirects =\n%s", got)
+	}
+	if got, want := read("_headers"), "/docs/*\n  X-Frame-Options: DENY\n"; got != want {
+		t.Errorf("_headers =\n%s", got)
+	}
+	if got := read("redirects.nginx.conf"); !strings.Contains(got, "location ~ ^/docs/2019/old\\.html/?$ { return 301 /docs/blog/new; }\n") ||
+		!strings.Contains(got, "location ~ ^/docs/guide/?$ { return 302 /docs/about; }\n") {
+		t.Errorf("nginx =\n%s", got)
+	}
+	if got := read(".htaccess"); !strings.HasPrefix(got, "RewriteEngine On\n") ||
+		!strings.Contains(got, "RewriteRule ^old-post/?$ /docs/blog/new [R=301,L]\n") {
+		t.Errorf(".htaccess =\n%s", got)
+	}
+}
+
+func TestRedirectsFollowAliasEdits(t *testing.T) {
+	sg, pub := newRedirectSite(t)
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, sg.SitePath, map[string]string{"src/blog/new.md": "---\ntitle: New\ntemplate: main.html\npath: /posts/new\naliases: [/older]\n---\nHi"})
+	local := filepath.Join(sg.SitePath, "src", "blog", "new.md")
+	if _, err := sg.NewSource(local, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := sg.Build(local); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sg.BuildDependents(local); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "old-post", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("dropped alias page still exists: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(pub, "older", "index.html")); err != nil || !strings.Contains(string(b), "url=/docs/posts/new") {
+		t.Errorf("new alias page = %s, %v", b, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(pub, "_redirects")); !strings.Contains(string(b), "/docs/older /docs/posts/new 301\n") {
+		t.Errorf("_redirects not updated:\n%s", b)
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	for in, want := range map[string]string{
+		"/new":      "/new 301",
+		" /new 307": "/new 307",
+		"/new 200":  "error",
+		"/new 3x":   "error",
+		"":          "error",
+	} {
+		to, code, err := parseRedirect(in)
+		got := to + " " + strconv.Itoa(code)
+		if err != nil {
+			got = "error"
+		}
+		if got != want {
+			t.Errorf("parseRedirect(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package sitegen
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// ref.go resolves cross-page references by source file instead of URL, so
+// links follow a page when it moves or its path: frontmatter changes.
+// Templates use ref "blog/welcome.md" (absolute URL) and relref (site path);
+// markdown links use [text](ref:blog/welcome.md) or [[blog/welcome]] and
+// [[blog/welcome|text]]. A reference to a missing or unpublished source
+// fails the build of the referring page.
+
+// refPrefix marks a markdown link destination as a reference.
+var refPrefix = []byte("ref:")
+
+// lookupRef finds the source ref names, plus its "#fragment". The path is
+// relative to the referring source's directory or else to the source dir; a
+// leading "/" means the source dir only. The extension may be left out.
+func (sg *SiteGen) lookupRef(from *Source, ref string) (*Source, string, error) {
+	target, frag, _ := strings.Cut(ref, "#")
+	if frag != "" {
+		frag = "#" + frag
+	}
+	target = strings.TrimSpace(target)
+	if target == "" {
+		if from == nil {
+			return nil, "", fmt.Errorf("ref %q: no page to refer to", ref)
+		}
+		return from, frag, nil
+	}
+	var bases []string
+	if from != nil && !strings.HasPrefix(target, "/") {
+		bases = append(bases, filepath.Dir(from.Local))
+	}
+	bases = append(bases, filepath.Join(sg.SitePath, sg.SourceDir))
+	for _, base := range bases {
+		p := filepath.Join(base, filepath.FromSlash(target))
+		for _, c := range []string{p, p + ".md", p + ".html", p + ".htm", filepath.Join(p, "index.md"), filepath.Join(p, "index.html")} {
+			t, ok := sg.sources[c]
+			if !ok || t.virtual {
+				continue
+			}
+			if !sg.Published(t) {
+				return nil, "", fmt.Errorf("ref %q: %s is not published", ref, sg.rel(t.Local))
+			}
+			return t, frag, nil
+		}
+	}
+	return nil, "", fmt.Errorf("ref %q: no such source", ref)
+}
+
+// RelRef returns the site path of the source ref names, relative to from
+// when it is set. Exposed to templates as relref "path".
+func (sg *SiteGen) RelRef(from *Source, ref string) (string, error) {
+	t, frag, err := sg.lookupRef(from, ref)
+	if err != nil {
+		return "", err
+	}
+	if from != nil && from.deps != nil && t != from {
+		from.deps[t.Local] = true
+	}
+	return t.Path + frag, nil
+}
+
+// Ref is RelRef as an absolute URL. Exposed to templates as ref "path".
+func (sg *SiteGen) Ref(from *Source, ref string) (string, error) {
+	p, err := sg.RelRef(from, ref)
+	if err != nil {
+		return "", err
+	}
+	return sg.AbsURL(p), nil
+}
+
+// markupKey holds the markupRender in the goldmark parser context; outside
+// a page render (summaries), refSourceKey holds the source being parsed.
+var (
+	markupKey    = parser.NewContextKey()
+	refSourceKey = parser.NewContextKey()
+)
+
+// refTransformer resolves the ref: links of a markdown render, recording
+// the targets as dependencies and failures on the markupRender.
+type refTransformer struct{}
+
+func (refTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	from, _ := pc.Get(refSourceKey).(*Source)
+	r, _ := pc.Get(markupKey).(*markupRender)
+	if r != nil {
+		from = r.s
+	}
+	if from == nil {
+		return
+	}
+	src := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		var dest *[]byte
+		switch n := n.(type) {
+		case *ast.Link:
+			dest = &n.Destination
+		case *ast.Image:
+			dest = &n.Destination
+		}
+		if !entering || dest == nil || !bytes.HasPrefix(*dest, refPrefix) {
+			return ast.WalkContinue, nil
+		}
+		ref := string((*dest)[len(refPrefix):])
+		target, frag, err := from.sg.lookupRef(from, ref)
+		if err != nil {
+			if r != nil {
+				r.errs = append(r.errs, fmt.Errorf("%s: %w", r.position(src, nodeOffset(n)), err))
+			}
+			return ast.WalkContinue, nil
+		}
+		if r != nil && target != from {
+			from.deps[target.Local] = true
+		}
+		*dest = []byte(target.Path + frag)
+		// A link labelled with the bare reference ([[blog/welcome]]) shows
+		// the target's title instead.
+		if t, ok := n.FirstChild().(*ast.Text); ok && n.ChildCount() == 1 && string(t.Segment.Value(src)) == ref {
+			if title, ok := target.Meta["title"]; ok {
+				n.RemoveChildren(n)
+				n.AppendChild(n, ast.NewString([]byte(fmt.Sprint(title))))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// nodeOffset is the source offset of an inline node: where its text starts,
+// else where its block does.
+func nodeOffset(n ast.Node) int {
+	var off = -1
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			off = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	for p := n; off < 0 && p != nil; p = p.Parent() {
+		if p.Type() == ast.TypeBlock && p.Lines().Len() > 0 {
+			off = p.Lines().At(0).Start
+		}
+	}
+	if off < 0 {
+		off = 0
+	}
+	return off
+}
+
+// wikiLinkParser parses [[target]] and [[target|text]] into ref: links.
+type wikiLinkParser struct{}
+
+func (wikiLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, seg := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+	end := bytes.Index(line, []byte("]]"))
+	if end < 0 {
+		return nil
+	}
+	inner := line[2:end]
+	target, label := inner, inner
+	labelStart := seg.Start + 2
+	if i := bytes.IndexByte(inner, '|'); i >= 0 {
+		target, label = bytes.TrimSpace(inner[:i]), inner[i+1:]
+		labelStart += i + 1
+	}
+	if len(target) == 0 || len(label) == 0 {
+		return nil
+	}
+	link := ast.NewLink()
+	link.Destination = append(append([]byte{}, refPrefix...), target...)
+	link.AppendChild(link, ast.NewTextSegment(text.NewSegment(labelStart, labelStart+len(label))))
+	block.Advance(end + 2)
+	return link
+}
@@ -0,0 +1,96 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func refSite(t *testing.T) (string, string) {
+	t.Helper()
+	dir := writeSite(t, map[string]string{
+		"templates/main.html": `{{template "content" .}}`,
+		"src/blog/welcome.md": "---\ntitle: Welcome\ntemplate: main.html\n---\n# Intro\n\nSee [[../about|about us]] and [[draft]].\n",
+		"src/blog/draft.md":   "---\ntitle: Draft\ndraft: true\ntemplate: main.html\n---\nsoon\n",
+		"src/about.md": "---\ntitle: About\ntemplate: main.html\n---\n" +
+			"Read [the post](ref:blog/welcome.md#intro), [[blog/welcome]] and [top](ref:#top).\n",
+		"src/links.html": `{{relref "blog/welcome"}} {{ref "/about.md"}}`,
+	})
+	return dir, t.TempDir()
+}
+
+func TestRefs(t *testing.T) {
+	dir, pub := refSite(t)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub, Drafts: true,
+		Site: Site{BaseURL: "https://example.com/"}})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	read := func(p string) string {
+		b, err := os.ReadFile(filepath.Join(pub, p, "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	about := read("about")
+	for _, want := range []string{
+		`<a href="/blog/welcome#intro">the post</a>`,
+		`<a href="/blog/welcome">Welcome</a>`,
+		`<a href="/about#top">top</a>`,
+	} {
+		if !strings.Contains(about, want) {
+			t.Errorf("missing %q in:\n%s", want, about)
+		}
+	}
+	if got := read("blog/welcome"); !strings.Contains(got, `<a href="/about">about us</a> and <a href="/blog/draft">Draft</a>`) {
+		t.Errorf("wiki links = %s", got)
+	}
+	if got := read("links"); got != "/blog/welcome https://example.com/about" {
+		t.Errorf("ref funcs = %q", got)
+	}
+
+	// Moving the target rebuilds the pages referring to it.
+	welcome := filepath.Join(dir, "src", "blog", "welcome.md")
+	writeFiles(t, dir, map[string]string{"src/blog/welcome.md": "---\ntitle: Hello\npath: /hello\ntemplate: main.html\n---\nmoved\n"})
+	if _, err := sg.NewSource(welcome, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := sg.Build(welcome); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sg.BuildDependents(welcome); err != nil {
+		t.Fatal(err)
+	}
+	if about := read("about"); !strings.Contains(about, `<a href="/hello#intro">the post</a>`) || !strings.Contains(about, `<a href="/hello">Hello</a>`) {
+		t.Errorf("referring page not rebuilt:\n%s", about)
+	}
+	if got := read("links"); got != "/hello https://example.com/about" {
+		t.Errorf("ref funcs after move = %q", got)
+	}
+}
+
+func TestBrokenRefs(t *testing.T) {
+	dir, pub := refSite(t)
+	writeFiles(t, dir, map[string]string{
+		"src/broken.md": "---\ntitle: Broken\ntemplate: main.html\n---\n" +
+			"{{% note %}}\nx\n{{% /note %}}\n\nfine\n[gone](ref:missing.md)\n",
+		"templates/shortcodes/note.html": `{{.Inner}}`,
+		"src/bad.html":                   "\n{{relref \"nope.md\"}}",
+	})
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub})
+	_, err := sg.BuildAll(false)
+	if err == nil {
+		t.Fatal("broken references built")
+	}
+	for _, want := range []string{
+		`broken.md:10: ref "missing.md": no such source`,
+		`executing "base" at <relref "nope.md">: error calling relref: ref "nope.md": no such source`,
+		`welcome.md:7: ref "draft": src/blog/draft.md is not published`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package sitegen
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resource.go gives templates images to resize. The image func returns an
+// Image for a JPEG or PNG under the source dir, whose Resize, Fit, Fill and
+// Crop methods return new Images written next to the original under a name
+// fingerprinted with the source's content and the operations, like
+// hero_3f9a0c1b2d4e5f60.jpg:
+//
+//	{{with (image "img/hero.jpg").Fill "400x300 top"}}
+//	  <img src="{{.}}" width="{{.Width}}" height="{{.Height}}">
+//	{{end}}
+//
+// A spec is the size, WxH with either side left out where the aspect ratio
+// gives it, followed by any of an anchor for Fill and Crop (center, top,
+// bottomright, ...), an output format (jpg, png, webp) and a quality (q75).
+// A derivative already in the public dir is not encoded again.
+
+// Image is an image of the site, as published or transformed by one of its
+// methods.
+type Image struct {
+	sg *SiteGen
+	// page is the source whose render asked for the image, nil outside a
+	// page render; the files written count as its outputs.
+	page *Source
+	// file is the source image and info its size and content hash.
+	file string
+	info *imageInfo
+	// ops transform the source, in order, into an image of width x height
+	// published at rel, relative to the public dir.
+	ops     []imageOp
+	width   int
+	height  int
+	rel     string
+	ext     string
+	quality int
+}
+
+// imageInfo is what is known of a source image file. width and height are
+// its size upright.
+type imageInfo struct {
+	mod    time.Time
+	size   int64
+	hash   string
+	width  int
+	height int
+	exif   exifInfo
+}
+
+// imageOp is one transformation of an image to width x height.
+type imageOp struct {
+	kind   string
+	width  int
+	height int
+	anchor string
+}
+
+// imageJob writes one derivative once, however many pages ask for it.
+type imageJob struct {
+	once sync.Once
+	err  error
+}
+
+// imageCache is the site's record of source images and derivatives written.
+type imageCache struct {
+	mu    sync.Mutex
+	infos map[string]*imageInfo
+	jobs  map[string]*imageJob
+	// derived maps the path of each derivative made to it, for the img tags
+	// showing it, and placeholders holds what placeholder.go computed.
+	derived      map[string]*Image
+	placeholders map[string]*placeholderJob
+	// hits and encoded count the images of the build taken from the image
+	// cache and encoded into it.
+	hits, encoded atomic.Int64
+}
+
+// anchors are the points Fill and Crop keep, as fractions of the width and
+// height cut off.
+var anchors = map[string][2]float64{
+	"center":      {0.5, 0.5},
+	"top":         {0.5, 0},
+	"bottom":      {0.5, 1},
+	"left":        {0, 0.5},
+	"right":       {1, 0.5},
+	"topleft":     {0, 0},
+	"topright":    {1, 0},
+	"bottomleft":  {0, 1},
+	"bottomright": {1, 1},
+}
+
+// Image returns the image at p, relative to the source dir, as published.
+func (sg *SiteGen) Image(p string) (*Image, error) {
+	p = strings.TrimPrefix(p, sg.BasePath)
+	p = strings.TrimLeft(path.Clean("/"+filepath.ToSlash(p)), "/")
+	ext := strings.ToLower(path.Ext(p))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+		return nil, fmt.Errorf("image %s: not a JPEG or PNG", p)
+	}
+	file := filepath.Join(sg.SitePath, sg.SourceDir, filepath.FromSlash(p))
+	info, err := sg.imageInfo(file)
+	if err != nil {
+		return nil, fmt.Errorf("image %s: %w", p, err)
+	}
+	img := &Image{sg: sg, file: file, info: info, width: info.width, height: info.height, rel: p, ext: ext}
+	// Minify publishes big images scaled down.
+	if sg.Minify != nil && img.width > maxImageWidth {
+		img.width, img.height = maxImageWidth, scaled(img.height, maxImageWidth, img.width)
+	}
+	return img, nil
+}
+
+// imageInfo reads the size and content hash of the image file, again only
+// once it changed.
+func (sg *SiteGen) imageInfo(file string) (*imageInfo, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	c := sg.images()
+	c.mu.Lock()
+	info, ok := c.infos[file]
+	c.mu.Unlock()
+	if ok && info.mod.Equal(fi.ModTime()) && info.size == fi.Size() {
+		return info, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	info = &imageInfo{mod: fi.ModTime(), size: fi.Size(), hash: hex.EncodeToString(sum[:]), width: cfg.Width, height: cfg.Height, exif: parseExif(b)}
+	if info.exif.orientation >= 5 {
+		info.width, info.height = info.height, info.width
+	}
+	c.mu.Lock()
+	c.infos[file] = info
+	c.mu.Unlock()
+	return info, nil
+}
+
+// images returns the image cache, created on first use.
+func (sg *SiteGen) images() *imageCache {
+	sg.imgOnce.Do(func() {
+		sg.imgCache = &imageCache{
+			infos:        map[string]*imageInfo{},
+			jobs:         map[string]*imageJob{},
+			derived:      map[string]*Image{},
+			placeholders: map[string]*placeholderJob{},
+		}
+	})
+	return sg.imgCache
+}
+
+// forgetJobs makes the next request for each derivative look in the public
+// dir again, which a full build may have cleaned.
+func (c *imageCache) forgetJobs() {
+	c.mu.Lock()
+	c.jobs = map[string]*imageJob{}
+	c.mu.Unlock()
+}
+
+// Width and Height are the image's size in pixels.
+func (i *Image) Width() int  { return i.width }
+func (i *Image) Height() int { return i.height }
+
+// RelPermalink is the image's URL path, and Permalink its absolute URL when
+// the site has a baseURL.
+func (i *Image) RelPermalink() string { return i.sg.Path(i.rel) }
+func (i *Image) Permalink() string    { return i.sg.AbsURL(i.RelPermalink()) }
+
+// String is the image's URL path, so templates can print an image as is.
+func (i *Image) String() string { return i.RelPermalink() }
+
+// Resize scales the image to the spec's size, keeping the aspect ratio when
+// one side is left out.
+func (i *Image) Resize(spec string) (*Image, error) {
+	return i.transform("resize", spec)
+}
+
+// Fit scales the image down to fit within the spec's size, keeping the
+// aspect ratio.
+func (i *Image) Fit(spec string) (*Image, error) {
+	return i.transform("fit", spec)
+}
+
+// Fill scales the image to cover the spec's size and cuts off what is left
+// over around the anchor.
+func (i *Image) Fill(spec string) (*Image, error) {
+	return i.transform("fill", spec)
+}
+
+// Crop cuts the spec's size out of the image around the anchor, without
+// scaling.
+func (i *Image) Crop(spec string) (*Image, error) {
+	return i.transform("crop", spec)
+}
+
+// transform returns the image with op applied as given by spec and makes
+// sure it is published.
+func (i *Image) transform(kind, spec string) (*Image, error) {
+	op := imageOp{kind: kind, anchor: "center"}
+	out := *i
+	out.ops = append(append([]imageOp(nil), i.ops...), op)
+	for _, f := range strings.Fields(strings.ToLower(spec)) {
+		if _, ok := anchors[f]; ok {
+			op.anchor = f
+			continue
+		}
+		switch {
+		case strings.Contains(f, "x"):
+			w, h, ok := strings.Cut(f, "x")
+			var err error
+			if w != "" {
+				if op.width, err = strconv.Atoi(w); err != nil || op.width <= 0 {
+					return nil, fmt.Errorf("image %s: %s %q: bad width", i.rel, kind, spec)
+				}
+			}
+			if ok && h != "" {
+				if op.height, err = strconv.Atoi(h); err != nil || op.height <= 0 {
+					return nil, fmt.Errorf("image %s: %s %q: bad height", i.rel, kind, spec)
+				}
+			}
+		case f == "jpg" || f == "jpeg" || f == "png" || f == "webp":
+			out.ext = "." + f
+		case len(f) > 1 && f[0] == 'q':
+			q, err := strconv.Atoi(f[1:])
+			if err != nil || q < 1 || q > 100 {
+				return nil, fmt.Errorf("image %s: %s %q: bad quality", i.rel, kind, spec)
+			}
+			out.quality = q
+		default:
+			return nil, fmt.Errorf("image %s: %s %q: unknown option %q", i.rel, kind, spec, f)
+		}
+	}
+	if op.width == 0 && op.height == 0 || kind != "resize" && (op.width == 0 || op.height == 0) {
+		return nil, fmt.Errorf("image %s: %s %q: want a size like 600x400", i.rel, kind, spec)
+	}
+	switch kind {
+	case "resize":
+		if op.width == 0 {
+			op.width = scaled(i.width, op.height, i.height)
+		}
+		if op.height == 0 {
+			op.height = scaled(i.height, op.width, i.width)
+		}
+	case "fit":
+		// Never scaled up.
+		w, h := i.width, i.height
+		if w > op.width {
+			w, h = op.width, scaled(h, op.width, w)
+		}
+		if h > op.height {
+			w, h = scaled(w, op.height, h), op.height
+		}
+		op.width, op.height = max(w, 1), max(h, 1)
+	case "crop":
+		op.width, op.height = min(op.width, i.width), min(op.height, i.height)
+	}
+	out.ops[len(out.ops)-1] = op
+	out.width, out.height = op.width, op.height
+
+	// Minify caps the width the operations apply to, so it changes the
+	// pixels even when the operations are the same.
+	key := fmt.Sprintf("%s %v %s %d %v %t", i.info.hash, out.ops, out.ext, out.quality, i.sg.KeepMetadata, i.sg.Minify != nil)
+	sum := sha256.Sum256([]byte(key))
+	base := strings.TrimSuffix(i.rel, path.Ext(i.rel))
+	if len(i.ops) > 0 {
+		// A derivative of a derivative is named after the original.
+		base = base[:strings.LastIndex(base, "_")]
+	}
+	out.rel = base + "_" + hex.EncodeToString(sum[:8]) + out.ext
+	if err := out.publish(key); err != nil {
+		return nil, fmt.Errorf("image %s: %s %q: %w", i.rel, kind, spec, err)
+	}
+	d := out
+	d.page = nil
+	c := i.sg.images()
+	c.mu.Lock()
+	c.derived[out.rel] = &d
+	c.mu.Unlock()
+	return &out, nil
+}
+
+// publish writes the image to the public dir, plus a WebP of it with -webp,
+// unless already there, and records the files as outputs of the page.
+func (i *Image) publish(key string) error {
+	sg := i.sg
+	dst := filepath.Join(sg.PublicPath, filepath.FromSlash(i.rel))
+	files := []string{dst}
+	if sg.Webp && i.ext != ".webp" {
+		files = append(files, strings.TrimSuffix(dst, i.ext)+".webp")
+	}
+	if i.page != nil && i.page.deps != nil {
+		i.page.deps[i.file] = true
+		i.page.outputs = append(i.page.outputs, files...)
+	}
+	c := sg.images()
+	c.mu.Lock()
+	job, ok := c.jobs[key]
+	if !ok {
+		job = &imageJob{}
+		c.jobs[key] = job
+	}
+	c.mu.Unlock()
+	job.once.Do(func() {
+		done := true
+		for _, f := range files {
+			if _, err := os.Stat(f); err != nil {
+				done = false
+			}
+		}
+		if !done {
+			job.err = i.render(files)
+		}
+	})
+	if job.err != nil {
+		// Let a later build try again.
+		c.mu.Lock()
+		delete(c.jobs, key)
+		c.mu.Unlock()
+	}
+	return job.err
+}
+
+// decode decodes the source, upright, and applies the operations. It also
+// returns the source file's content.
+func (i *Image) decode() (image.Image, []byte, error) {
+	b, err := os.ReadFile(i.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	img = orient(img, parseExif(b).orientation)
+	// Operations apply to the image as published.
+	if i.sg.Minify != nil && img.Bounds().Dx() > maxImageWidth {
+		img = resizeImage(img, maxImageWidth)
+	}
+	for _, op := range i.ops {
+		img = op.apply(img)
+	}
+	return img, b, nil
+}
+
+// render encodes the image to files, with the metadata of the source the
+// site keeps, decoding it only for what the image cache does not have.
+func (i *Image) render(files []string) error {
+	if err := os.MkdirAll(filepath.Dir(files[0]), os.ModePerm); err != nil {
+		return err
+	}
+	var img image.Image
+	var src []byte
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f))
+		params := fmt.Sprintf("%v %s %d %t", i.ops, ext, i.quality, i.sg.Minify != nil)
+		if ext != ".webp" {
+			params += fmt.Sprint(" ", i.sg.KeepMetadata)
+		}
+		err := i.sg.cachedImage(f, i.info.hash, i.width, params, func(path string) error {
+			if img == nil {
+				var err error
+				if img, src, err = i.decode(); err != nil {
+					return err
+				}
+			}
+			if ext == ".webp" {
+				return writeWebp(path, img, i.quality)
+			}
+			return encodeImage(path, img, i.ext, i.quality, keptMetadata(src, i.sg.KeepMetadata))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (op imageOp) apply(img image.Image) image.Image {
+	b := img.Bounds()
+	switch op.kind {
+	case "resize", "fit":
+		return scaleImage(img, op.width, op.height)
+	case "fill":
+		// Scale to cover, then crop what sticks out.
+		w, h := op.width, scaled(b.Dy(), op.width, b.Dx())
+		if h < op.height {
+			w, h = scaled(b.Dx(), op.height, b.Dy()), op.height
+		}
+		img = scaleImage(img, w, h)
+	}
+	return cropImage(img, op.width, op.height, anchors[op.anchor])
+}
+
+// cropImage cuts w x h out of img, placed by anchor within what is cut off.
+func cropImage(img image.Image, w, h int, anchor [2]float64) image.Image {
+	b := img.Bounds()
+	w, h = min(w, b.Dx()), min(h, b.Dy())
+	x := b.Min.X + int(anchor[0]*float64(b.Dx()-w)+0.5)
+	y := b.Min.Y + int(anchor[1]*float64(b.Dy()-h)+0.5)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(x, y), draw.Src)
+	return dst
+}
+
+// scaled is n scaled by to/from, rounded and at least 1.
+func scaled(n, to, from int) int {
+	return max(1, (n*to+from/2)/from)
+}
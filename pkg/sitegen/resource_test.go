@@ -0,0 +1,170 @@
+package sitegen
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tdewolff/minify/v2"
+)
+
+func TestImageResource(t *testing.T) {
+	pub := t.TempDir()
+	site := writeSite(t, map[string]string{
+		"src/img/hero.jpg": string(createTestJPEG(t, 800, 400)),
+		"src/img/logo.png": string(createTestPNG(t, 200, 200)),
+		"src/page.html": `{{$h := image "img/hero.jpg"}}orig {{$h}} {{$h.Width}}x{{$h.Height}}
+resize {{with $h.Resize "400x"}}{{.}} {{.Width}}x{{.Height}}{{end}}
+fit {{with $h.Fit "300x300"}}{{.Width}}x{{.Height}}{{end}}
+fill {{with $h.Fill "100x100 left"}}{{.}} {{.Width}}x{{.Height}}{{end}}
+crop {{with $h.Crop "1000x50 bottomright webp q60"}}{{.}} {{.Width}}x{{.Height}}{{end}}
+chain {{with ($h.Resize "x200").Crop "50x50"}}{{.}} {{.Width}}x{{.Height}}{{end}}
+png {{(image "/img/logo.png").Resize "20x"}}`,
+	})
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	want := regexp.MustCompile(`^orig /img/hero.jpg 800x400
+resize (/img/hero_[0-9a-f]{16}\.jpg) 400x200
+fit 300x150
+fill (/img/hero_[0-9a-f]{16}\.jpg) 100x100
+crop (/img/hero_[0-9a-f]{16}\.webp) 800x50
+chain (/img/hero_[0-9a-f]{16}\.jpg) 50x50
+png (/img/logo_[0-9a-f]{16}\.png)$`)
+	m := want.FindStringSubmatch(got)
+	if m == nil {
+		t.Fatalf("got:\n%s", got)
+	}
+	dims := map[string][2]int{m[1]: {400, 200}, m[2]: {100, 100}, m[3]: {800, 50}, m[4]: {50, 50}, m[5]: {20, 20}}
+	for p, d := range dims {
+		f, err := os.Open(filepath.Join(pub, filepath.FromSlash(p)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != d[0] || cfg.Height != d[1] {
+			t.Errorf("%s: %dx%d %v, want %v", p, cfg.Width, cfg.Height, err, d)
+		}
+	}
+
+	// A rebuild reuses what is there; a changed image gets new names.
+	resized := filepath.Join(pub, filepath.FromSlash(m[1]))
+	fi, _ := os.Stat(resized)
+	os.Chtimes(resized, fi.ModTime().Add(-time.Hour), fi.ModTime().Add(-time.Hour))
+	fi, _ = os.Stat(resized)
+	if _, err := NewSiteGen(Options{SitePath: site, PublicPath: pub}).BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	if fi2, _ := os.Stat(resized); !fi2.ModTime().Equal(fi.ModTime()) {
+		t.Error("derivative written again")
+	}
+	writeFiles(t, site, map[string]string{"src/img/hero.jpg": string(createTestJPEG(t, 600, 400))})
+	sg.sources[filepath.Join(site, "src", "page.html")].ReloadContent()
+	if err := sg.Build(filepath.Join(site, "src", "page.html")); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if strings.Contains(string(b), m[1]) || !strings.Contains(string(b), "resize /img/hero_") {
+		t.Errorf("changed image kept its derivative names:\n%s", b)
+	}
+	if !sg.sources[filepath.Join(site, "src", "page.html")].deps[filepath.Join(site, "src", "img", "hero.jpg")] {
+		t.Error("page does not depend on the image")
+	}
+}
+
+func TestImageResourceErrors(t *testing.T) {
+	site := t.TempDir()
+	os.MkdirAll(filepath.Join(site, "src", "img"), 0755)
+	if err := os.WriteFile(filepath.Join(site, "src", "img", "a.jpg"), createTestJPEG(t, 100, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: t.TempDir()})
+	img, err := sg.Image("img/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		op   func(string) (*Image, error)
+		spec string
+		want string
+	}{
+		{img.Resize, "", "want a size"},
+		{img.Fill, "100x", "want a size"},
+		{img.Resize, "ax10", "bad width"},
+		{img.Resize, "10x10 q0", "bad quality"},
+		{img.Crop, "10x10 middle", `unknown option "middle"`},
+	} {
+		if _, err := c.op(c.spec); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: %v, want %q", c.spec, err, c.want)
+		}
+	}
+	if _, err := sg.Image("img/missing.jpg"); err == nil {
+		t.Error("missing image: no error")
+	}
+	if _, err := sg.Image("doc.pdf"); err == nil || !strings.Contains(err.Error(), "not a JPEG or PNG") {
+		t.Errorf("pdf: %v", err)
+	}
+}
+
+func TestImageResourceMinify(t *testing.T) {
+	site := t.TempDir()
+	os.MkdirAll(filepath.Join(site, "src", "img"), 0755)
+	if err := os.WriteFile(filepath.Join(site, "src", "img", "wide.jpg"), createTestJPEG(t, 2400, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The crop takes a different part of the image once minify scales it
+	// down, so it must not share a name with the unminified one.
+	names := map[string]bool{}
+	for _, m := range []*minify.M{nil, {}} {
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: t.TempDir(), Minify: m})
+		img, err := sg.Image("img/wide.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := img.Crop("100x100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[out.String()] = true
+	}
+	if len(names) != 2 {
+		t.Errorf("minify kept the derivative name: %v", names)
+	}
+}
+
+func TestImagePlaceholder(t *testing.T) {
+	pub := t.TempDir()
+	site := writeSite(t, map[string]string{
+		"src/img/hero.jpg": string(createTestJPEG(t, 400, 200)),
+		"src/page.html": `{{$h := image "img/hero.jpg"}}<img src="{{$h.Placeholder}}" data-hash="{{$h.BlurHash}}" style="background:{{$h.Color}}">
+{{with $h.Fill "50x50"}}<img src="{{.}}" style="width:50px">{{end}}
+<img src="/img/hero.jpg"><img src="/img/other.gif">`,
+	})
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, ImagePlaceholder: "blur"})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bg := `#[0-9a-f]{6} url\(data:image/png;base64,[A-Za-z0-9+/=]+\) center/cover no-repeat`
+	want := regexp.MustCompile(`^<img src="data:image/png;base64,[A-Za-z0-9+/=]+" data-hash="L.{27}" style="background:#[0-9a-f]{6}">
+<img src="/img/hero_[0-9a-f]{16}\.jpg" style="background:` + bg + `;width:50px">
+<img src="/img/hero.jpg" style="background:` + bg + `"><img src="/img/other.gif">$`)
+	if !want.Match(b) {
+		t.Errorf("got:\n%s", b)
+	}
+}
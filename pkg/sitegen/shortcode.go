@@ -0,0 +1,339 @@
+package sitegen
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+// shortcode.go lets markdown content call templates. A shortcode is written
+// {{< name key="value" >}} or {{< name "positional" >}} and rendered from
+// templates/shortcodes/<name>.html. Shortcodes may wrap content up to a
+// closing {{< /name >}}: with {{< >}} delimiters the inner text is passed
+// as is, with {{% %}} it is rendered as markdown first. {{</* name */>}}
+// prints the shortcode itself.
+//
+// Before goldmark runs, every shortcode is rendered and swapped for a
+// placeholder that survives the markdown conversion and the page's template
+// parse; the placeholders are replaced by the rendered output once the page
+// template has executed, so shortcode output is never mangled by goldmark nor
+// parsed as a template again.
+
+// Shortcode is the data of a shortcode template.
+type Shortcode struct {
+	Name string
+	// Params holds the named parameters, Args the positional ones.
+	Params map[string]string
+	Args   []string
+	// Inner is the content between the opening and closing tags.
+	Inner template.HTML
+	// Source is the page the shortcode is used in.
+	Source *Source
+	Site   Site
+}
+
+// Get returns a named parameter for a string key and a positional one for an
+// int, or "" when not given.
+func (sc *Shortcode) Get(key interface{}) string {
+	switch k := key.(type) {
+	case int:
+		if k >= 0 && k < len(sc.Args) {
+			return sc.Args[k]
+		}
+	case string:
+		return sc.Params[k]
+	}
+	return ""
+}
+
+// scTag is one shortcode tag in content.
+type scTag struct {
+	start, end int
+	name       string
+	markdown   bool // {{% %}} delimiters
+	closing    bool // {{< /name >}}
+	selfClose  bool // {{< name />}}
+	params     map[string]string
+	args       []string
+	// literal is the text an escaped {{</* name */>}} stands for.
+	literal string
+}
+
+// shortcodes expands the shortcodes of one content. render produces the
+// output of a tag given its expanded inner content, which ph holds on to.
+type shortcodes struct {
+	src    string
+	tags   []scTag
+	pairs  map[int]int
+	ph     *placeholders
+	render func(t scTag, inner string, paired bool) (string, error)
+}
+
+// hasShortcodes is a cheap check to skip parsing.
+func hasShortcodes(b []byte) bool {
+	return bytes.Contains(b, []byte("{{<")) || bytes.Contains(b, []byte("{{%"))
+}
+
+// parseShortcodes finds the shortcode tags of src and pairs opening and
+// closing tags. where formats an offset for error messages.
+func parseShortcodes(src string, where func(int) string) (*shortcodes, error) {
+	sc := &shortcodes{src: src, pairs: map[int]int{}}
+	for i := 0; ; {
+		n := strings.Index(src[i:], "{{")
+		if n < 0 {
+			break
+		}
+		start := i + n
+		if start+2 >= len(src) || (src[start+2] != '<' && src[start+2] != '%') {
+			i = start + 2
+			continue
+		}
+		t, err := scanShortcode(src, start)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where(start), err)
+		}
+		sc.tags = append(sc.tags, t)
+		i = t.end
+	}
+	claimed := map[int]bool{}
+	for i, t := range sc.tags {
+		if t.closing || t.selfClose || t.literal != "" {
+			continue
+		}
+		depth := 0
+		for j := i + 1; j < len(sc.tags); j++ {
+			o := sc.tags[j]
+			if o.name != t.name || o.literal != "" || claimed[j] {
+				continue
+			}
+			if !o.closing {
+				if !o.selfClose {
+					depth++
+				}
+				continue
+			}
+			if depth > 0 {
+				depth--
+				continue
+			}
+			sc.pairs[i] = j
+			claimed[j] = true
+			break
+		}
+	}
+	for j, t := range sc.tags {
+		if t.closing && !claimed[j] {
+			return nil, fmt.Errorf("%s: closing shortcode %q without opening", where(t.start), t.name)
+		}
+	}
+	return sc, nil
+}
+
+// scanShortcode parses the tag starting at src[start:].
+func scanShortcode(src string, start int) (scTag, error) {
+	t := scTag{start: start, markdown: src[start+2] == '%'}
+	delim := ">}}"
+	if t.markdown {
+		delim = "%}}"
+	}
+	i := skipSpace(src, start+3)
+	if strings.HasPrefix(src[i:], "/*") {
+		end := strings.Index(src[i:], "*/"+delim)
+		if end < 0 {
+			return t, fmt.Errorf("unterminated shortcode comment")
+		}
+		t.literal = src[start:start+3] + src[i+2:i+end] + delim
+		t.end = i + end + 2 + len(delim)
+		return t, nil
+	}
+	if i < len(src) && src[i] == '/' {
+		t.closing = true
+		i = skipSpace(src, i+1)
+	}
+	j := i
+	for j < len(src) && isShortcodeName(src[j]) {
+		j++
+	}
+	if j == i {
+		return t, fmt.Errorf("shortcode name expected")
+	}
+	t.name = src[i:j]
+	for {
+		i = skipSpace(src, j)
+		switch {
+		case i >= len(src):
+			return t, fmt.Errorf("shortcode %q: missing %s", t.name, delim)
+		case strings.HasPrefix(src[i:], delim):
+			t.end = i + len(delim)
+			return t, nil
+		case strings.HasPrefix(src[i:], "/"+delim) && !t.closing:
+			t.selfClose = true
+			t.end = i + 1 + len(delim)
+			return t, nil
+		case t.closing:
+			return t, fmt.Errorf("closing shortcode %q takes no parameters", t.name)
+		}
+		if src[i] == '"' || src[i] == '`' {
+			v, n, err := scanQuoted(src, i)
+			if err != nil {
+				return t, fmt.Errorf("shortcode %q: %w", t.name, err)
+			}
+			t.args = append(t.args, v)
+			j = n
+			continue
+		}
+		k := i
+		for k < len(src) && !isSpace(src[k]) && src[k] != '=' && !strings.HasPrefix(src[k:], delim) && !strings.HasPrefix(src[k:], "/"+delim) {
+			k++
+		}
+		word := src[i:k]
+		if k >= len(src) || src[k] != '=' {
+			t.args = append(t.args, word)
+			j = k
+			continue
+		}
+		k++
+		var v string
+		if k < len(src) && (src[k] == '"' || src[k] == '`') {
+			var err error
+			if v, k, err = scanQuoted(src, k); err != nil {
+				return t, fmt.Errorf("shortcode %q: %w", t.name, err)
+			}
+		} else {
+			e := k
+			for e < len(src) && !isSpace(src[e]) && !strings.HasPrefix(src[e:], delim) {
+				e++
+			}
+			v, k = src[k:e], e
+		}
+		if t.params == nil {
+			t.params = map[string]string{}
+		}
+		t.params[word] = v
+		j = k
+	}
+}
+
+// scanQuoted reads the "double quoted" or `raw` string at src[i:] and returns
+// its value and the offset after it.
+func scanQuoted(src string, i int) (string, int, error) {
+	q := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch {
+		case src[j] == '\\' && q == '"':
+			j++
+		case src[j] == q:
+			if q == '`' {
+				return src[i+1 : j], j + 1, nil
+			}
+			v, err := strconv.Unquote(src[i : j+1])
+			return v, j + 1, err
+		}
+	}
+	return "", i, fmt.Errorf("unterminated string")
+}
+
+func isShortcodeName(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+// expand returns the content with every top-level shortcode replaced by a
+// placeholder for its output.
+func (sc *shortcodes) expand() (string, error) {
+	return sc.expandRange(0, len(sc.src), 0, len(sc.tags))
+}
+
+func (sc *shortcodes) expandRange(lo, hi, from, to int) (string, error) {
+	var b strings.Builder
+	pos := lo
+	for i := from; i < to; i++ {
+		t := sc.tags[i]
+		b.WriteString(sc.src[pos:t.start])
+		var (
+			out string
+			err error
+		)
+		if j, ok := sc.pairs[i]; ok {
+			var inner string
+			if inner, err = sc.expandRange(t.end, sc.tags[j].start, i+1, j); err == nil {
+				out, err = sc.render(t, inner, true)
+			}
+			pos, i = sc.tags[j].end, j
+		} else {
+			out, err = sc.render(t, "", false)
+			pos = t.end
+		}
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(sc.ph.hold("SHORTCODE", out))
+		// Keep the line count, so later positions still map to the file.
+		b.WriteString(strings.Repeat("\n", strings.Count(sc.src[t.start:pos], "\n")))
+	}
+	b.WriteString(sc.src[pos:hi])
+	return b.String(), nil
+}
+
+// renderShortcodes renders the shortcodes of the content and returns it with
+// placeholders for their outputs.
+func (r *markupRender) renderShortcodes(content []byte) ([]byte, error) {
+	if !hasShortcodes(content) {
+		return content, nil
+	}
+	s := r.s
+	sc, err := parseShortcodes(string(content), s.position)
+	if err != nil {
+		return nil, err
+	}
+	sc.ph = r.ph
+	sc.render = func(t scTag, inner string, paired bool) (string, error) {
+		if t.literal != "" {
+			return template.HTMLEscapeString(t.literal), nil
+		}
+		st, err := r.template("shortcodes/" + t.name + ".html")
+		if err == nil && st == nil {
+			err = fmt.Errorf("template %s not found", r.file("shortcodes/"+t.name+".html"))
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: shortcode %q: %w", s.position(t.start), t.name, err)
+		}
+		if paired && t.markdown {
+			var buf bytes.Buffer
+			if err := r.convert([]byte(inner), &buf, t.end); err != nil {
+				return "", fmt.Errorf("%s: shortcode %q: %w", s.position(t.start), t.name, err)
+			}
+			inner = buf.String()
+		}
+		data := &Shortcode{
+			Name:   t.name,
+			Params: t.params,
+			Args:   t.args,
+			Inner:  template.HTML(r.ph.resolve([]byte(inner))),
+			Source: s,
+			Site:   r.sg.pageSite(s),
+		}
+		var buf bytes.Buffer
+		if err := st.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("%s: shortcode %q: %w", s.position(t.start), t.name, err)
+		}
+		return buf.String(), nil
+	}
+	out, err := sc.expand()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
@@ -0,0 +1,89 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShortcodes(t *testing.T) {
+	dir := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.MkdirAll(filepath.Join(dir, "templates", "shortcodes"), 0755)
+	os.WriteFile(filepath.Join(dir, "templates", "main.html"), []byte(`{{template "content" .}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "templates", "partials.html"), []byte(`{{define "frame"}}<iframe src="{{.}}"></iframe>{{end}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "templates", "shortcodes", "youtube.html"),
+		[]byte(`{{template "frame" (printf "https://youtube.com/embed/%s" (.Get "id"))}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "templates", "shortcodes", "note.html"),
+		[]byte(`<aside class="{{or (.Get 0) "info"}}">{{.Inner}}</aside>`), 0644)
+	os.WriteFile(filepath.Join(dir, "templates", "shortcodes", "title.html"), []byte(`{{.Source.Meta.title}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "page.md"), []byte("---\ntitle: \"Q&A\"\ntemplate: main.html\n---\n"+
+		"# {{< title >}}\n\n"+
+		"{{< youtube id=\"abc\" >}}\n\n"+
+		"{{% note warn %}}\nSome *markdown* with {{< youtube id=`x` />}} inside.\n{{% /note %}}\n\n"+
+		"Raw {{< note >}}<b>kept</b>{{< /note >}} and `{{</* youtube id=\"z\" */>}}`.\n"), 0644)
+	sg := NewSiteGen(Options{SitePath: dir, PublicPath: pub})
+	sg.Markdown.HeadingIDs = true
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"<h1 id=\"heading\">Q&amp;A</h1>",
+		"\n<iframe src=\"https://youtube.com/embed/abc\"></iframe>\n",
+		`<aside class="warn"><p>Some <em>markdown</em> with <iframe src="https://youtube.com/embed/x"></iframe> inside.</p>`,
+		`Raw <aside class="info"><b>kept</b></aside> and <code>{{&lt; youtube id=&#34;z&#34; &gt;}}</code>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+
+	// Editing a shortcode rebuilds the pages using it.
+	sc := filepath.Join(dir, "templates", "shortcodes", "title.html")
+	os.WriteFile(sc, []byte(`[{{.Source.Meta.title}}]`), 0644)
+	if n, err := sg.BuildAffected(sc); err != nil || n != 1 {
+		t.Fatalf("BuildAffected = %d, %v", n, err)
+	}
+	b, _ = os.ReadFile(filepath.Join(pub, "page", "index.html"))
+	if !strings.Contains(string(b), "[Q&amp;A]") {
+		t.Errorf("shortcode edit not rebuilt:\n%s", b)
+	}
+}
+
+func TestShortcodeErrors(t *testing.T) {
+	for page, want := range map[string]string{
+		"---\ntitle: x\n---\ntext\n\n{{< missing >}}\n": "page.md:6: shortcode \"missing\": template",
+		"---\ntitle: x\n---\n{{< note\n":                "page.md:4: shortcode \"note\": missing >}}",
+		"---\ntitle: x\n---\n\n{{% /note %}}\n":         "page.md:5: closing shortcode \"note\" without opening",
+	} {
+		_, err := renderMarkdown(t, nil, page)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestParseShortcodeParams(t *testing.T) {
+	sc, err := parseShortcodes(`{{< fig "a b" src=img.png alt="say \"hi\"" `+"`raw`"+` />}}`, func(int) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := sc.tags[0]
+	if !tag.selfClose || tag.name != "fig" {
+		t.Errorf("tag = %+v", tag)
+	}
+	if want := []string{"a b", "raw"}; !reflect.DeepEqual(tag.args, want) {
+		t.Errorf("args = %q, want %q", tag.args, want)
+	}
+	if want := map[string]string{"src": "img.png", "alt": `say "hi"`}; !reflect.DeepEqual(tag.params, want) {
+		t.Errorf("params = %q, want %q", tag.params, want)
+	}
+}
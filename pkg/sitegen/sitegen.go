@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -15,11 +16,12 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-	texttemplate "text/template"
 	"time"
 
 	"github.com/gobwas/glob"
@@ -56,9 +58,71 @@ type (
 
 		// CmdTimeout bounds how long a serve:/build: frontmatter command may
 		// run. It runs while the build lock is held, so an unbounded command
-		// would freeze all rebuilds. Zero disables the timeout.
+		// would freeze all rebuilds. Zero or less disables the timeout.
 		CmdTimeout time.Duration
 
+		// Jobs is the number of sources BuildAll renders concurrently. Values
+		// below 1 fall back to runtime.NumCPU().
+		Jobs int
+
+		// Incremental makes BuildAll consult the manifest in .sitegen/ and skip
+		// sources whose inputs and outputs are unchanged since the last run.
+		Incremental bool
+
+		// Drafts, Future and Expired include pages marked draft: true, with a
+		// future publishDate, or with a past expiryDate, which are otherwise
+		// left out of the build.
+		Drafts  bool
+		Future  bool
+		Expired bool
+
+		// Site holds the site-wide params from sitegen.yaml, exposed to
+		// templates as .Site.
+		Site Site
+
+		// TaxonomyConfig lists the taxonomies to collect and generate pages
+		// for, keyed by frontmatter key.
+		TaxonomyConfig map[string]TaxonomyConfig
+
+		// FeedConfig lists the sections to generate RSS, Atom and JSON feeds
+		// for, keyed by section path relative to the source dir.
+		FeedConfig map[string]FeedConfig
+
+		// Markdown selects the goldmark extensions for .md sources; pages
+		// may override it with markdown: frontmatter.
+		Markdown MarkdownConfig
+
+		// Highlight configures syntax highlighting of code blocks and the
+		// highlight template func.
+		Highlight HighlightConfig
+
+		// RedirectConfig maps old site paths to where they moved ("/new" or
+		// "/new 302"), on top of the aliases: frontmatter of pages.
+		RedirectConfig map[string]string
+		// HeaderConfig maps path patterns to the response headers written
+		// to the _headers server file.
+		HeaderConfig map[string]map[string]string
+		// ServerFiles lists the server configs to write the redirects to:
+		// netlify, headers, nginx and/or apache.
+		ServerFiles []string
+
+		// TextTemplates renders HTML sources and templates with
+		// text/template, which prints values as they are, instead of the
+		// escaping html/template.
+		TextTemplates bool
+
+		// ImageAttributes gives img tags the width and height of the image
+		// and, but for the first on a page, loading="lazy" and
+		// decoding="async".
+		ImageAttributes bool
+		// ImagePlaceholder shows a placeholder as the background of img tags
+		// while the image loads: "color" for its dominant color, "blur" for
+		// a blurred copy over it (see placeholder.go).
+		ImagePlaceholder string
+		// KeepMetadata lists the kinds of metadata (see MetadataKinds)
+		// published images keep; the rest is stripped.
+		KeepMetadata []string
+
 		// Mu serializes builds and source-map mutations. Callers that build or
 		// mutate sources (BuildAll, Build, NewSource, Remove, ClearCache from
 		// separate goroutines) must hold it. The low-level methods do NOT lock
@@ -67,8 +131,39 @@ type (
 		Mu sync.Mutex
 
 		sources    map[string]*Source
-		genSources []*Source
-		TplCache   map[string]*texttemplate.Template
+		taxonomies map[string]*Taxonomy
+		// redirects is the redirect table, and redirectPages maps each of
+		// its virtual sources to the public file to delete when it goes.
+		redirects     []Redirect
+		redirectPages map[string]string
+		// dataPages maps each page generated from data to its public file,
+		// to delete when it disappears.
+		dataPages map[string]string
+		// dataFiles holds the data files decoded for this build's .Site.Data
+		// tree, keyed by their slash path below the data dir, so the data
+		// func reads each only once.
+		dataFiles map[string]interface{}
+		// md caches one goldmark converter per distinct MarkdownConfig.
+		md       map[MarkdownConfig]goldmark.Markdown
+		mdMu     sync.Mutex
+		TplCache map[string]*Template
+		tplFiles map[string][]string
+		// hooks caches the render hook and shortcode templates (see
+		// markup.go).
+		hooks map[string]*hookTemplate
+		// tplMu guards TplCache, tplFiles and hooks, which concurrent
+		// BuildAll workers fill lazily.
+		tplMu sync.Mutex
+		// i18n caches the translation tables read this build and
+		// translations indexes the sources by translation key (see
+		// lang.go); i18nMu guards both.
+		i18n         map[string]map[string]interface{}
+		translations map[string][]*Source
+		i18nMu       sync.Mutex
+		// imgCache records the images read and derivatives written by the
+		// image func (see resource.go).
+		imgCache *imageCache
+		imgOnce  sync.Once
 	}
 
 	Parser func(*Source) ([]byte, error)
@@ -84,25 +179,132 @@ type (
 	}
 )
 
-func NewSiteGen(sitePath, tplDir, dataDir, sourceDir, pubPath, basePath string, min *minify.M, clean bool, dev bool, webp bool) *SiteGen {
-	sp, err := filepath.Abs(sitePath)
+// DefaultCmdTimeout bounds frontmatter commands when Options leaves
+// CmdTimeout unset.
+const DefaultCmdTimeout = 120 * time.Second
+
+// Options configures NewSiteGen. SourceDir, TemplateDir and DataDir are
+// relative to SitePath and default to "src", "templates" and "data"; BasePath
+// defaults to "/".
+type Options struct {
+	SitePath    string
+	SourceDir   string
+	TemplateDir string
+	DataDir     string
+	PublicPath  string
+	BasePath    string
+	Minify      *minify.M
+	Clean       bool
+	Dev         bool
+	Webp        bool
+	// CmdTimeout bounds serve:/build: frontmatter commands; zero means
+	// DefaultCmdTimeout and a negative value disables it.
+	CmdTimeout time.Duration
+	// Jobs below 1 builds with runtime.NumCPU() workers.
+	Jobs        int
+	Incremental bool
+	// Drafts, Future and Expired include unpublished pages.
+	Drafts  bool
+	Future  bool
+	Expired bool
+	// Site is exposed to templates as .Site.
+	Site Site
+	// Taxonomies configures term pages, keyed by frontmatter key.
+	Taxonomies map[string]TaxonomyConfig
+	// Feeds configures section feeds, keyed by section path.
+	Feeds map[string]FeedConfig
+	// Markdown defaults to DefaultMarkdown when nil.
+	Markdown *MarkdownConfig
+	// Highlight configures syntax highlighting.
+	Highlight HighlightConfig
+	// Redirects, Headers and ServerFiles configure redirects (see
+	// redirect.go).
+	Redirects   map[string]string
+	Headers     map[string]map[string]string
+	ServerFiles []string
+	// TextTemplates opts out of html/template for HTML.
+	TextTemplates bool
+	// ImageAttributes adds size and lazy loading attributes to img tags.
+	ImageAttributes bool
+	// ImagePlaceholder is "color" or "blur" to give img tags a background
+	// while the image loads.
+	ImagePlaceholder string
+	// KeepMetadata lists the image metadata kept, DefaultKeepMetadata when
+	// nil.
+	KeepMetadata []string
+}
+
+func NewSiteGen(opts Options) *SiteGen {
+	sp, err := filepath.Abs(opts.SitePath)
 	if err != nil {
-		log.Fatalln("Site Path ", sitePath, " error ", err)
+		log.Fatalln("Site Path ", opts.SitePath, " error ", err)
+	}
+	if opts.SourceDir == "" {
+		opts.SourceDir = "src"
+	}
+	if opts.TemplateDir == "" {
+		opts.TemplateDir = "templates"
+	}
+	if opts.DataDir == "" {
+		opts.DataDir = "data"
+	}
+	if opts.BasePath == "" {
+		opts.BasePath = "/"
+	}
+	if opts.Jobs < 1 {
+		opts.Jobs = runtime.NumCPU()
+	}
+	if opts.Markdown == nil {
+		opts.Markdown = &DefaultMarkdown
+	}
+	if p := opts.ImagePlaceholder; p != "" && p != "color" && p != "blur" {
+		log.Println("imagePlaceholder: unknown kind:", p)
+		opts.ImagePlaceholder = ""
+	}
+	if opts.CmdTimeout == 0 {
+		opts.CmdTimeout = DefaultCmdTimeout
+	}
+	if opts.KeepMetadata == nil {
+		opts.KeepMetadata = DefaultKeepMetadata
+	}
+	for _, k := range opts.KeepMetadata {
+		if !slices.Contains(MetadataKinds, k) {
+			log.Println("keepMetadata: unknown kind:", k)
+		}
 	}
 	sg := &SiteGen{
 		SitePath:    sp,
-		SourceDir:   sourceDir,
-		TemplateDir: tplDir,
-		DataDir:     dataDir,
-		PublicPath:  pubPath,
-		BasePath:    basePath,
-		Minify:      min,
-		Clean:       clean,
+		SourceDir:   opts.SourceDir,
+		TemplateDir: opts.TemplateDir,
+		DataDir:     opts.DataDir,
+		PublicPath:  opts.PublicPath,
+		BasePath:    opts.BasePath,
+		Minify:      opts.Minify,
+		Clean:       opts.Clean,
 		sources:     make(map[string]*Source),
-		TplCache:    make(map[string]*texttemplate.Template),
-		Dev:         dev,
-		Webp:        webp,
-		CmdTimeout:  120 * time.Second,
+		TplCache:    make(map[string]*Template),
+		tplFiles:    make(map[string][]string),
+		Dev:         opts.Dev,
+		Webp:        opts.Webp,
+		CmdTimeout:  opts.CmdTimeout,
+		Jobs:        opts.Jobs,
+		Incremental: opts.Incremental,
+		Drafts:      opts.Drafts,
+		Future:      opts.Future,
+		Expired:     opts.Expired,
+		Site:        opts.Site,
+
+		TaxonomyConfig:   opts.Taxonomies,
+		FeedConfig:       opts.Feeds,
+		Markdown:         *opts.Markdown,
+		Highlight:        opts.Highlight,
+		TextTemplates:    opts.TextTemplates,
+		ImageAttributes:  opts.ImageAttributes,
+		ImagePlaceholder: opts.ImagePlaceholder,
+		KeepMetadata:     opts.KeepMetadata,
+		RedirectConfig:   opts.Redirects,
+		HeaderConfig:     opts.Headers,
+		ServerFiles:      opts.ServerFiles,
 	}
 
 	// load all sources keyed by local path
@@ -148,9 +350,11 @@ func (sg *SiteGen) NewSource(path string, gen bool) (*Source, error) {
 		s.Ctype = strings.Split(ctype, ";")[0]
 	}
 	s.sg = sg
+	sg.detectLang(s)
 	s.LoadContent()
 	if !gen {
 		sg.sources[path] = s
+		sg.forgetTranslations()
 	}
 	return s, nil
 }
@@ -164,22 +368,33 @@ func (sg *SiteGen) SourceList() []*Source {
 }
 
 func (sg *SiteGen) tplFuncs() map[string]interface{} {
-	return map[string]interface{}{
-		"sort":     sortBy,
-		"limit":    limit,
-		"offset":   offset,
-		"path":     sg.Path,
-		"sources":  sg.GetSources,
-		"data":     sg.Data,
-		"json":     parseJSON,
-		"js":       allowJS,
-		"html":     allowHTML,
-		"css":      allowCSS,
-		"contains": contains,
-		"pages":    pages,
-		"select":   mapToList,
-		"filter":   filterBy,
-	}
+	funcs := map[string]interface{}{
+		"sort":      sortBy,
+		"limit":     limit,
+		"offset":    offset,
+		"path":      sg.Path,
+		"sources":   sg.GetSources,
+		"data":      sg.Data,
+		"image":     sg.Image,
+		"json":      parseJSON,
+		"js":        allowJS,
+		"html":      allowHTML,
+		"css":       allowCSS,
+		"contains":  contains,
+		"pages":     pages,
+		"select":    mapToList,
+		"filter":    filterBy,
+		"taxonomy":  sg.Taxonomy,
+		"absURL":    sg.AbsURL,
+		"i18n":      func(key string) string { return sg.I18n(sg.DefaultLang(), key) },
+		"highlight": sg.HighlightCode,
+		"ref":       func(ref string) (string, error) { return sg.Ref(nil, ref) },
+		"relref":    func(ref string) (string, error) { return sg.RelRef(nil, ref) },
+	}
+	for k, f := range sg.libFuncs() {
+		funcs[k] = f
+	}
+	return funcs
 }
 
 func (sg *SiteGen) parse(s *Source, t string) ([]byte, error) {
@@ -187,42 +402,186 @@ func (sg *SiteGen) parse(s *Source, t string) ([]byte, error) {
 	if content == nil {
 		return nil, fmt.Errorf("failed to load content for %s", s.Local)
 	}
+	return sg.execute(s, t, content, "")
+}
+
+// buildValues are the page data fields set by the build rather than by any
+// file.
+func (sg *SiteGen) buildValues() map[string]string {
+	now := time.Now()
+	return map[string]string{"BuildID": sg.BuildID, "Today": now.Format("2006-01-02"), "Year": now.Format("2006")}
+}
+
+// execute renders content as the body of s through its template. With block
+// set, only that defined block is rendered (e.g. "content" for feed bodies)
+// and the page-level HTML post-processing is skipped.
+func (sg *SiteGen) execute(s *Source, t string, content []byte, block string) ([]byte, error) {
 	tplName := filepath.Base(s.Local)
 	if n, ok := s.Meta["template"]; ok {
 		tplName = fmt.Sprint(n)
 	}
 
+	funcs := sg.pageFuncs(s)
+	tpl, tplFiles, err := sg.cachedTemplate(t, funcs)
+	if err != nil {
+		return nil, fmt.Errorf("parse template %s error %w", s.Local, err)
+	}
+	if s.deps == nil {
+		s.deps = map[string]bool{}
+	}
+
+	// Content is parsed into the root template, so errors in it name the
+	// root rather than the layout. Content with a body of its own replaces
+	// the layout; otherwise the layout runs with the blocks it defines.
+	if _, err := tpl.Parse(string(content)); err != nil {
+		return nil, &renderError{fmt.Errorf("parse %s error %w", s.Local, err), content}
+	}
+	target := tpl
+	if t := tpl.Lookup(tplName); t != nil && !tpl.hasBody() {
+		target = t
+	}
+	for _, f := range templateDeps(target, tplFiles) {
+		s.deps[f] = true
+	}
+	build := sg.buildValues()
+	if s.buildValues == nil {
+		s.buildValues = map[string]string{}
+	}
+	for _, name := range buildFields(target) {
+		s.buildValues[name] = build[name]
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range s.Meta {
+		data[k] = v
+	}
+	// Expose the full frontmatter map as .Meta too, so templates can use
+	// .Meta.title at the page root just like they do for sources in a loop
+	// (.Meta.<key>). Individual keys remain flattened at the root as well.
+	data["Meta"] = s.Meta
+	data["Path"] = s.path
+	data["Page"] = s.CurrentPage
+	data["Pages"] = s.TotalPages
+	data["Dev"] = sg.Dev
+	data["Source"] = s
+	data["BasePath"] = sg.BasePath
+	data["Site"] = sg.pageSite(s)
+	data["Terms"] = s.Terms()
+	data["TableOfContents"] = s.TableOfContents()
+	data["WordCount"] = s.WordCount()
+	data["ReadingTime"] = s.ReadingTime()
+	data["Summary"] = s.Summary()
+	data["Truncated"] = s.Truncated()
+	data["Lang"] = s.Lang
+	if s.pageOf != nil {
+		data["Item"] = s.item
+	}
+	translations := s.Translations()
+	for _, t := range translations {
+		s.deps[t.Local] = true
+	}
+	data["Translations"] = translations
+	if s.taxonomy != nil {
+		data["Taxonomy"] = s.taxonomy
+	}
+	if s.term != nil {
+		data["Term"] = s.term
+	}
+	for k, v := range build {
+		data[k] = v
+	}
+
+	if block != "" {
+		if b := target.Lookup(block); b != nil {
+			target = b
+		}
+	}
+	tplBuf := new(bytes.Buffer)
+	if err := target.Execute(tplBuf, data); err != nil {
+		return nil, &renderError{fmt.Errorf("parse execute %s error %w", s.Local, err), content}
+	}
+	// Markdown shortcodes and render hooks go in only now, so their output is never parsed
+	// as part of the page template.
+	body := s.placeholders.resolve(tplBuf.Bytes())
+	s.placeholders = nil
+	if t == "html" && block == "" {
+		if sg.Webp || sg.ImageAttributes || sg.ImagePlaceholder != "" {
+			if b, err := rewriteHTMLImages(body, sg.imageRewrite(s)); err == nil {
+				body = b
+			} else {
+				log.Println("image rewrite error", err)
+			}
+		}
+		if sg.Minify != nil {
+			b, err := sg.Minify.Bytes("text/html", body)
+			if err != nil {
+				return nil, fmt.Errorf("minify %s error %w", s.Local, err)
+			} else {
+				body = b
+			}
+		}
+	}
+	return body, nil
+}
+
+// pageFuncs returns the template funcs for rendering s, bound to it so they
+// can record what the render depends on.
+func (sg *SiteGen) pageFuncs(s *Source) map[string]interface{} {
 	funcs := sg.tplFuncs()
-	// Mark this source as an aggregator when it lists/loads other content, so
-	// the watcher knows to rebuild it when any content changes.
+	// Mark this source as an aggregator when it lists other content, so the
+	// watcher knows to rebuild it when any content changes.
 	funcs["sources"] = func(prop, pattern string) []*Source {
 		s.dynamic = true
 		return sg.GetSources(prop, pattern)
 	}
+	funcs["now"] = func() time.Time {
+		s.volatile = true
+		return time.Now()
+	}
+	// Data files are tracked individually, so editing one only rebuilds the
+	// pages that actually loaded it.
 	funcs["data"] = func(name string) interface{} {
-		s.dynamic = true
+		s.deps[filepath.Join(sg.SitePath, sg.DataDir, name)] = true
 		return sg.Data(name)
 	}
-	funcs["page"] = func(source, path string) string {
-		var sp *Source
-		for i := range sg.genSources {
-			if sg.genSources[i].path == path {
-				sp = sg.genSources[i]
-				break
-			}
+	funcs["i18n"] = func(key string) string {
+		s.deps[sg.i18nFile(s.Lang)] = true
+		s.deps[sg.i18nFile(sg.DefaultLang())] = true
+		return sg.I18n(s.Lang, key)
+	}
+	funcs["ref"] = func(ref string) (string, error) {
+		return sg.Ref(s, ref)
+	}
+	funcs["relref"] = func(ref string) (string, error) {
+		return sg.RelRef(s, ref)
+	}
+	funcs["markdownify"] = func(v interface{}) (template.HTML, error) {
+		return sg.markdownify(s, v)
+	}
+	funcs["image"] = func(p string) (*Image, error) {
+		img, err := sg.Image(p)
+		if err != nil {
+			return nil, err
 		}
+		s.deps[img.file] = true
+		img.page = s
+		return img, nil
+	}
+	funcs["page"] = func(source, path string) (string, error) {
+		sp := s.gen.find(path)
 		if sp == nil {
 			var err error
 			sp, err = sg.NewSource(filepath.Join(sg.SitePath, sg.SourceDir, source), true)
 			if err != nil {
-				log.Println("page source error", err)
+				return "", fmt.Errorf("page source error: %w", err)
 			}
 			sp.Path += "/" + path
 			sp.Name = path + sp.Ext
 			sp.path = path
-			s.sg.genSources = append(s.sg.genSources, sp)
+			sp.gen = s.gen
+			s.gen.push(sp)
 		}
-		return sp.Path
+		return sp.Path, nil
 	}
 	funcs["paginate"] = func(limit int, list interface{}) interface{} {
 		rv := reflect.ValueOf(list)
@@ -240,7 +599,7 @@ func (sg *SiteGen) parse(s *Source, t string) ([]byte, error) {
 					sp.Path += "/" + p
 					sp.Name = p + sp.Ext
 					sp.CurrentPage = i
-					sp.sg.genSources = append(sp.sg.genSources, &sp)
+					s.gen.push(&sp)
 				}
 			}
 		}
@@ -252,102 +611,7 @@ func (sg *SiteGen) parse(s *Source, t string) ([]byte, error) {
 		}
 		return rv.Slice(start, end).Interface()
 	}
-
-	var tpl *texttemplate.Template
-	var err error
-
-	// Try to get from cache
-	if cached, ok := sg.TplCache[t]; ok {
-		tpl, err = cached.Clone()
-		if err != nil {
-			return nil, fmt.Errorf("template clone error: %w", err)
-		}
-		tpl.Funcs(funcs)
-	} else {
-		// Lazily load cache for this type
-		if err := sg.LoadTemplate(t); err == nil {
-			if cached, ok := sg.TplCache[t]; ok {
-				tpl, err = cached.Clone()
-				if err == nil {
-					tpl.Funcs(funcs)
-				}
-			}
-		} else {
-			// This might not be fatal if we find it later? but LoadTemplate usually means base templates.
-			// log.Println("LoadTemplate error", err)
-		}
-	}
-
-	// Fallback to fresh parse if cache failed or not available (shouldn't happen if LoadTemplate works)
-	if tpl == nil {
-		tpl = texttemplate.New(tplName).Funcs(funcs)
-		tplFiles, err := filepath.Glob(filepath.Join(sg.SitePath, sg.TemplateDir, "*."+t))
-		if err != nil {
-			return nil, fmt.Errorf("load template glob %s error %w", s.Local, err)
-		}
-		if len(tplFiles) > 0 {
-			tpl, err = tpl.ParseFiles(tplFiles...)
-			if err != nil {
-				return nil, fmt.Errorf("parse template %s error %w", s.Local, err)
-			}
-		}
-	}
-
-	// Determine target template
-	var target *texttemplate.Template
-	if t := tpl.Lookup(tplName); t != nil {
-		target = t
-	} else {
-		target = tpl
-	}
-
-	target, err = target.Parse(string(content))
-	if err != nil {
-		return nil, fmt.Errorf("parse %s error %w", s.Local, err)
-	}
-
-	data := map[string]interface{}{}
-	for k, v := range s.Meta {
-		data[k] = v
-	}
-	// Expose the full frontmatter map as .Meta too, so templates can use
-	// .Meta.title at the page root just like they do for sources in a loop
-	// (.Meta.<key>). Individual keys remain flattened at the root as well.
-	data["Meta"] = s.Meta
-	data["Path"] = s.path
-	data["Page"] = s.CurrentPage
-	data["Pages"] = s.TotalPages
-	data["Dev"] = sg.Dev
-	data["Source"] = s
-	data["BasePath"] = sg.BasePath
-	data["Today"] = time.Now().Format("2006-01-02")
-	data["Year"] = time.Now().Format("2006")
-	data["BuildID"] = sg.BuildID
-
-	tplBuf := new(bytes.Buffer)
-	if err := target.Execute(tplBuf, data); err != nil {
-		return nil, fmt.Errorf("parse execute %s error %w", s.Local, err)
-	}
-	if t == "html" {
-		body := tplBuf.Bytes()
-		if sg.Webp {
-			if b, err := rewriteHTMLImages(body, sg.Webp); err == nil {
-				body = b
-			} else {
-				log.Println("webp rewrite error", err)
-			}
-		}
-		if sg.Minify != nil {
-			b, err := sg.Minify.Bytes("text/html", body)
-			if err != nil {
-				return nil, fmt.Errorf("minify %s error %w", s.Local, err)
-			} else {
-				body = b
-			}
-		}
-		return body, nil
-	}
-	return tplBuf.Bytes(), nil
+	return funcs
 }
 
 func (sg *SiteGen) text(s *Source) ([]byte, error) {
@@ -363,26 +627,46 @@ func (sg *SiteGen) markdown(s *Source) ([]byte, error) {
 	if content == nil {
 		return nil, fmt.Errorf("failed to load content for %s", s.Local)
 	}
-	// Convert markdown to HTML
+	htmlContent, err := sg.markdownHTML(s, content)
+	if err != nil {
+		return nil, err
+	}
+	return sg.execute(s, "html", htmlContent, "")
+}
+
+// markdownHTML converts markdown content to HTML wrapped in the source's
+// content block ({{define "content"}} unless the block: frontmatter says
+// otherwise) when it doesn't define its own blocks.
+func (sg *SiteGen) markdownHTML(s *Source, content []byte) ([]byte, error) {
+	md, err := sg.markdownFor(s)
+	if err != nil {
+		return nil, err
+	}
+	r := sg.newMarkupRender(s, md)
+	if content, err = r.renderShortcodes(content); err != nil {
+		return nil, err
+	}
+	// The summary marker has done its job once the page is analyzed.
+	content = bytes.Replace(content, []byte(moreMarker), nil, 1)
 	var buf bytes.Buffer
-	if err := goldmark.Convert(content, &buf); err != nil {
+	if err := r.convert(content, &buf, 0); err != nil {
 		return nil, fmt.Errorf("markdown convert %s error %w", s.Local, err)
 	}
+	s.placeholders = r.ph
 	htmlContent := buf.String()
 	// Auto-wrap in {{define "block"}} if not already present
 	if !strings.Contains(string(content), "{{define") {
-		block := "content"
-		if b, ok := s.Meta["block"].(string); ok {
-			block = b
-		}
-		htmlContent = `{{define "` + block + `"}}` + htmlContent + `{{end}}`
+		htmlContent = `{{define "` + contentBlock(s) + `"}}` + htmlContent + `{{end}}`
+	}
+	return []byte(htmlContent), nil
+}
+
+// contentBlock is the template block holding a source's own content.
+func contentBlock(s *Source) string {
+	if b, ok := s.Meta["block"].(string); ok {
+		return b
 	}
-	// Replace the source content temporarily for template parsing
-	origContent := s.content
-	s.content = []byte(htmlContent)
-	result, err := sg.parse(s, "html")
-	s.content = origContent
-	return result, err
+	return "content"
 }
 
 func (sg *SiteGen) sourcePath(s *Source) string {
@@ -404,8 +688,16 @@ func (sg *SiteGen) Remove(path string) error {
 	if !ok {
 		return nil
 	}
+	delete(sg.sources, path)
+	sg.forgetTranslations()
 
+	// Pagination pages and image derivatives go with the page.
 	pubPath := sg.sourcePath(s)
+	for _, o := range s.outputs {
+		if o != pubPath {
+			removeOutput(o)
+		}
+	}
 	if err := os.Remove(pubPath); err != nil {
 		return fmt.Errorf("remove failed for %s: error %v", pubPath, err)
 	}
@@ -424,35 +716,55 @@ func (sg *SiteGen) Remove(path string) error {
 }
 
 func (sg *SiteGen) Build(path string) (err error) {
+	s, ok := sg.sources[path]
+	if !ok {
+		return fmt.Errorf("build failed for %s: not found", path)
+	}
+
 	// Recover from panics (e.g. a malformed template hitting a reflect call)
 	// so one bad source surfaces as a build error instead of crashing serve.
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("build panic for %s: %v", path, r)
 		}
+		s.failed = err != nil
+		s.buildErr = nil
+		if err != nil {
+			s.buildErr = sg.locate(s, err)
+			err = s.buildErr
+		}
 	}()
 
-	s, ok := sg.sources[path]
-	if !ok {
-		return fmt.Errorf("build failed for %s: not found", path)
-	}
-
 	// Check for source loading errors (e.g. frontmatter parse errors)
 	if s.Err != nil {
 		return s.Err
 	}
+	if !sg.Published(s) {
+		sg.unpublish(s)
+		return nil
+	}
+	// A pages: source renders the pages generated from its data instead.
+	if s.isPageTemplate() {
+		return s.pagesErr
+	}
 
 	pubPath := sg.sourcePath(s)
 	src := s.LoadContent()
+	s.deps = map[string]bool{}
+	s.outputs = nil
+	s.volatile = false
+	s.buildValues = nil
 
 	// check if parametarized page then skip if no parameter
-	if strings.Contains(string(src), " .Path") && s.path == "" {
+	if strings.Contains(string(src), " .Path") && s.path == "" && s.pageOf == nil {
 		return nil
 	}
 
 	var parser Parser
 	// force parse template any file if --- parse: text --- is found
-	if p, ok := s.Meta["parse"].(string); ok {
+	if s.render != nil {
+		parser = s.render
+	} else if p, ok := s.Meta["parse"].(string); ok {
 		switch p {
 		case "text":
 			parser = sg.text
@@ -472,6 +784,11 @@ func (sg *SiteGen) Build(path string) (err error) {
 		}
 	}
 	if parser != nil {
+		// Extra pages spawned by this render (pagination, the page func) are
+		// queued on the source itself, so concurrent builds never share state.
+		q := &genQueue{}
+		s.gen = q
+		defer func() { s.gen = nil }()
 		if err := os.MkdirAll(filepath.Dir(pubPath), os.ModePerm); err != nil {
 			return err
 		}
@@ -491,13 +808,16 @@ func (sg *SiteGen) Build(path string) (err error) {
 				return err
 			}
 		}
+		s.outputs = append(s.outputs, pubPath)
 		for {
-			if len(sg.genSources) == 0 {
+			cs := q.pop()
+			if cs == nil {
 				break
 			}
-			cs := sg.genSources[0]
-			sg.genSources = sg.genSources[1:]
 			childPath := sg.sourcePath(cs)
+			// Children render with their parent's path, which pagination
+			// links are built on. cs is this worker's own copy.
+			cs.Path = sg.LocalToPath(cs)
 			if err := os.MkdirAll(filepath.Dir(childPath), os.ModePerm); err != nil {
 				return err
 			}
@@ -516,6 +836,17 @@ func (sg *SiteGen) Build(path string) (err error) {
 				return err
 			}
 			childFile.Close()
+			s.outputs = append(s.outputs, childPath)
+			for d := range cs.deps {
+				s.deps[d] = true
+			}
+			s.volatile = s.volatile || cs.volatile
+			for k, v := range cs.buildValues {
+				if s.buildValues == nil {
+					s.buildValues = map[string]string{}
+				}
+				s.buildValues[k] = v
+			}
 		}
 	} else {
 		if src != nil {
@@ -536,34 +867,50 @@ func (sg *SiteGen) Build(path string) (err error) {
 		if err := os.MkdirAll(filepath.Dir(pubPath), os.ModePerm); err != nil {
 			return err
 		}
-		if (s.Ext == ".jpg" || s.Ext == ".jpeg" || s.Ext == ".png") && src != nil && (sg.Minify != nil || sg.Webp) {
-			if err := sg.processImage(src, pubPath, s.Ext); err != nil {
+		if (s.Ext == ".jpg" || s.Ext == ".jpeg" || s.Ext == ".png") && src != nil {
+			if outs, err := sg.processImage(src, pubPath, s.Ext); err != nil {
 				log.Println("image processing error", pubPath, err)
-				if err := os.WriteFile(pubPath, src, os.ModePerm); err != nil {
+				if err := os.WriteFile(pubPath, StripMetadata(src, sg.KeepMetadata), os.ModePerm); err != nil {
 					return err
 				}
+			} else {
+				s.outputs = append(s.outputs, outs...)
 			}
 		} else {
 			if err := os.WriteFile(pubPath, src, os.ModePerm); err != nil {
 				return err
 			}
 		}
+		s.outputs = append(s.outputs, pubPath)
 	}
 	return nil
 }
 
 // BuildDependents rebuilds every registered source that aggregated other
 // content (via the sources/data funcs) during a previous render — i.e. listing
-// pages — excluding the path that was just built. This lets adding, editing, or
+// pages — or referred to the changed source, excluding the path that was just
+// built, plus the redirects its aliases changed. This lets adding, editing, or
 // removing one source update the pages that list it, without a full rebuild.
 // The caller must hold sg.Mu. Returns the number of pages rebuilt.
 func (sg *SiteGen) BuildDependents(except string) (int, error) {
-	var paths []string
+	// The changed source may have added or dropped generated pages,
+	// taxonomy terms and aliases.
+	paths := sg.syncDataPages()
+	sg.syncTaxonomies()
+	paths = append(paths, sg.syncRedirects()...)
 	for p, s := range sg.sources {
-		if s.dynamic && p != except {
+		if (s.dynamic || s.deps[except]) && p != except {
 			paths = append(paths, p)
 		}
 	}
+	// Translations of the changed page list it in their language switchers.
+	if s, ok := sg.sources[except]; ok {
+		for _, t := range s.Translations() {
+			if !t.dynamic {
+				paths = append(paths, t.Local)
+			}
+		}
+	}
 	count := 0
 	for _, p := range paths {
 		if s, ok := sg.sources[p]; ok {
@@ -576,9 +923,63 @@ func (sg *SiteGen) BuildDependents(except string) (int, error) {
 		}
 		count++
 	}
+	// A changed pages: source only reports its errors once synced.
+	if s, ok := sg.sources[except]; ok && s.pagesErr != nil {
+		return count, s.pagesErr
+	}
 	return count, nil
 }
 
+// BuildAffected rebuilds the sources whose last render read file, a template
+// or data file, so e.g. editing nav.html only re-renders the pages that include
+// it. Template edits also retry sources whose last build failed, since a new or
+// fixed template may be what they were missing. The caller must hold sg.Mu.
+// Returns the number of sources rebuilt.
+func (sg *SiteGen) BuildAffected(file string) (int, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return 0, err
+	}
+	isTpl := strings.HasPrefix(file, filepath.Join(sg.SitePath, sg.TemplateDir)+string(os.PathSeparator))
+	if isTpl {
+		sg.ClearCache()
+	}
+	var paths []string
+	// A data file is part of .Site.Data and may be what pages are generated
+	// from.
+	isData := strings.HasPrefix(file, sg.dataPath()+string(os.PathSeparator))
+	if isData {
+		sg.forgetI18n()
+		sg.Site.data, sg.dataFiles = sg.loadDataTree()
+		paths = sg.syncDataPages()
+	}
+	queued := map[string]bool{}
+	for _, p := range paths {
+		queued[p] = true
+	}
+	for p, s := range sg.sources {
+		if (s.deps[file] || (isData && s.deps[sg.dataPath()]) || (isTpl && s.failed)) && !queued[p] {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	var errs []error
+	count := 0
+	for _, p := range paths {
+		sg.sources[p].ReloadContent()
+		if err := sg.Build(p); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+			continue
+		}
+		count++
+	}
+	return count, errors.Join(errs...)
+}
+
+// BuildAll builds every registered source, rendering up to Jobs sources
+// concurrently. With Incremental set, sources the build manifest proves
+// unchanged are skipped and counted under "cached". The caller must hold
+// sg.Mu.
 func (sg *SiteGen) BuildAll(reload bool) (map[string]int, error) {
 	sg.BuildID = strconv.FormatInt(time.Now().Unix(), 10)
 	out := make(map[string]int)
@@ -587,27 +988,122 @@ func (sg *SiteGen) BuildAll(reload bool) (map[string]int, error) {
 			return nil, fmt.Errorf("failed to clean public path %s: %w", sg.PublicPath, err)
 		}
 	}
-	sg.genSources = nil
-	var errs []string
-	for k, s := range sg.sources {
+	// Load every source up front, serially: renders read other sources' Meta
+	// and Path through the sources func, so nothing may reload them while the
+	// workers below are running.
+	for _, s := range sg.sources {
 		if reload {
 			s.ReloadContent()
+		} else {
+			s.LoadContent()
 		}
+	}
+	imgs := sg.images()
+	imgs.forgetJobs()
+	imgs.hits.Store(0)
+	imgs.encoded.Store(0)
+	sg.forgetI18n()
+	sg.Site.data, sg.dataFiles = sg.loadDataTree()
+	sg.syncDataPages()
+	sg.syncTaxonomies()
+	sg.syncFeeds()
+	sg.syncHighlight()
+	sg.syncRedirects()
+	keys := make([]string, 0, len(sg.sources))
+	for k, s := range sg.sources {
+		// Unpublished pages are dropped before planning, so the manifest
+		// forgets them and a page coming out of schedule is always built.
+		if s.Err == nil && !sg.Published(s) {
+			sg.unpublish(s)
+			out["unpublished"]++
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	var (
+		old, cur *buildManifest
+		hasher   *fileHasher
+	)
+	if sg.Incremental {
+		hasher = newFileHasher()
+		old = sg.loadManifest()
+		if sg.Clean {
+			old.Entries = map[string]*manifestEntry{}
+		}
+		cur = &buildManifest{Config: old.Config}
+		sg.BuildID = sg.inputsID(old.Config, hasher)
+		keys, cur.Entries = sg.planIncremental(keys, old, hasher)
+		if n := len(cur.Entries); n > 0 {
+			out["cached"] = n
+		}
+	}
 
-		if err := sg.Build(k); err != nil {
-			errs = append(errs, fmt.Sprintf("%s: %v", k, err))
-		} else {
-			out[s.Ext]++
+	jobs := sg.Jobs
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		errs   []error
+		failed = map[string]bool{}
+	)
+	queue := make(chan string)
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range queue {
+				err := sg.Build(k)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", k, err))
+					failed[k] = true
+				} else {
+					out[sg.sources[k].Ext]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, k := range keys {
+		queue <- k
+	}
+	close(queue)
+	wg.Wait()
+
+	if sg.Incremental {
+		for _, k := range keys {
+			if failed[k] {
+				sg.recordFailure(cur, old, k)
+			} else {
+				sg.recordBuild(cur, k, hasher)
+			}
 		}
+		sg.pruneOutputs(old, cur)
+		if err := sg.saveManifest(cur); err != nil {
+			errs = append(errs, fmt.Errorf("build cache: %w", err))
+		}
+	}
+
+	// Images the build wrote, from the image cache or newly encoded.
+	if n := int(imgs.hits.Load()); n > 0 {
+		out["img cached"] = n
 	}
-	if len(errs) > 0 {
-		return out, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	if n := int(imgs.encoded.Load()); n > 0 {
+		out["img encoded"] = n
 	}
-	return out, nil
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return out, errors.Join(errs...)
 }
 
 func (sg *SiteGen) ClearCache() {
-	sg.TplCache = make(map[string]*texttemplate.Template)
+	sg.tplMu.Lock()
+	sg.TplCache = make(map[string]*Template)
+	sg.hooks = nil
+	sg.tplMu.Unlock()
 }
 
 func (sg *SiteGen) Path(path string) string {
@@ -615,15 +1111,9 @@ func (sg *SiteGen) Path(path string) string {
 }
 
 func (sg *SiteGen) Data(name string) interface{} {
-	path := filepath.Join(sg.SitePath, sg.DataDir, name)
-	data, err := os.ReadFile(path)
+	d, err := sg.dataFile(name)
 	if err != nil {
-		log.Println("loadData failed", path, err)
-		return nil
-	}
-	var d interface{}
-	if err := json.Unmarshal(data, &d); err != nil {
-		log.Println("loadData unmarshal failed", path, err)
+		log.Println("loadData failed", err)
 		return nil
 	}
 	return d
@@ -639,11 +1129,14 @@ func (sg *SiteGen) LocalToPath(s *Source) string {
 		switch s.Ext {
 		case ".html", ".htm", ".md":
 			path = strings.TrimSuffix(path, s.Ext)
+			if s.langSuffix {
+				path = strings.TrimSuffix(path, "."+s.Lang)
+			}
 			path = strings.TrimSuffix(path, "index")
 		}
 		path = strings.ReplaceAll(path, "\\", "/")
 	}
-	return sg.BasePath + strings.TrimLeft(path, "/")
+	return sg.BasePath + sg.langPrefix(s) + strings.TrimLeft(path, "/")
 }
 
 func (sg *SiteGen) GetSources(prop string, pattern string) []*Source {
@@ -654,8 +1147,25 @@ func (sg *SiteGen) GetSources(prop string, pattern string) []*Source {
 		return filtered
 	}
 	for _, s := range sg.sources {
+		// Generated outputs (feeds, stylesheets) aren't content to list, nor
+		// are the templates of pages generated from data.
+		if s.render != nil || s.isPageTemplate() || !sg.Published(s) {
+			continue
+		}
 		if g.Match(s.Value(prop)) {
 			filtered = append(filtered, s)
+			continue
+		}
+		// List-valued frontmatter (tags: [a, b]) matches on any item.
+		if strings.HasPrefix(prop, "Meta.") {
+			if items, ok := s.Meta[prop[5:]].([]interface{}); ok {
+				for _, it := range items {
+					if g.Match(fmt.Sprint(it)) {
+						filtered = append(filtered, s)
+						break
+					}
+				}
+			}
 		}
 	}
 
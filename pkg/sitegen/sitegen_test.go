@@ -1,14 +1,41 @@
 package sitegen
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
+// writeSite writes files, keyed by slash path, into a new site directory and
+// returns it.
+func writeSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	site := t.TempDir()
+	writeFiles(t, site, files)
+	return site
+}
+
+// writeFiles writes files, keyed by slash path, below dir, creating the
+// directories they are in. Tests use it to edit a site between builds.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestBuildDependentsRebuildsListingOnNewEntry(t *testing.T) {
 	site := t.TempDir()
 	pub := t.TempDir()
@@ -26,7 +53,7 @@ func TestBuildDependentsRebuildsListingOnNewEntry(t *testing.T) {
 		`{{define "content"}}{{range sources "RelPath" "blog/*"}}[{{.Meta.title}}]{{end}}{{end}}`)
 	mk("src/blog/one.md", "---\ntitle: One\ntemplate: main.html\n---\n# One")
 
-	sg := NewSiteGen(site, "templates", "data", "src", pub, "/", nil, false, true, false)
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Dev: true})
 	if _, err := sg.BuildAll(false); err != nil {
 		t.Fatal(err)
 	}
@@ -62,7 +89,7 @@ func TestBuildDependentsRebuildsListingOnNewEntry(t *testing.T) {
 }
 
 func testSiteGen() *SiteGen {
-	return NewSiteGen("../../site", "templates", "data", "src", "./public", "/", nil, true, true, false)
+	return NewSiteGen(Options{SitePath: "../../site", PublicPath: "./public", Clean: true, Dev: true})
 }
 
 func TestGetSources(t *testing.T) {
@@ -379,3 +406,321 @@ func TestData(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveDeletesEveryOutput(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"src/list.html":   `{{range paginate 1 (sources "RelPath" "post/*")}}{{.Path}}{{end}}`,
+		"src/post/a.html": `a`,
+		"src/post/b.html": `b`,
+	})
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	page := filepath.Join(pub, "list", "2", "index.html")
+	if _, err := os.Stat(page); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := filepath.Abs(filepath.Join(site, "src", "list.html"))
+	if err := sg.Remove(list); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(pub, "list", "index.html"), page} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", p, err)
+		}
+	}
+}
+
+func TestBuildAllParallel(t *testing.T) {
+	files := map[string]string{
+		"templates/main.html": `<html><body>{{template "content" .}}</body></html>`,
+	}
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("src/post/p%02d.md", i)] = fmt.Sprintf("---\ntitle: P%02d\ntemplate: main.html\n---\n# P%02d", i, i)
+	}
+	// Two paginated listings rendering at the same time must each get their
+	// own generated pages, and read the paths of posts being built (run
+	// with -race).
+	for _, name := range []string{"a", "b"} {
+		files["src/"+name+".html"] = "---\ntemplate: main.html\n---\n" +
+			`{{define "content"}}{{range paginate 10 (sort "Meta.title" "asc" (sources "RelPath" "post/*"))}}[{{.Meta.title}} {{.Path}}]{{end}}{{end}}`
+	}
+	site := writeSite(t, files)
+	pub := t.TempDir()
+
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+	sg.Jobs = 8
+	stats, err := sg.BuildAll(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[".md"] != 40 || stats[".html"] != 2 {
+		t.Errorf("stats = %v", stats)
+	}
+	for _, name := range []string{"a", "b"} {
+		for page, want := range map[string]string{"": "[P00 /post/p00]", "2": "[P10 /post/p10]", "4": "[P30 /post/p30]"} {
+			b, err := os.ReadFile(filepath.Join(pub, name, page, "index.html"))
+			if err != nil {
+				t.Fatalf("%s page %q: %v", name, page, err)
+			}
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%s page %q missing %s:\n%s", name, page, want, b)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pub, "post", "p39", "index.html")); err != nil {
+		t.Errorf("post not built: %v", err)
+	}
+}
+
+func TestBuildAllIncremental(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/main.html": `<html><body>{{template "content" .}}</body></html>`,
+		"data/site.json":      `{"name":"One"}`,
+		"src/a.md":            "---\ntemplate: main.html\n---\n# A",
+		"src/b.md":            "---\ntemplate: main.html\n---\n# B",
+		"src/c.html":          "---\ntemplate: main.html\n---\n" + `{{define "content"}}{{(data "site.json").name}}{{end}}`,
+	})
+	pub := t.TempDir()
+
+	build := func() map[string]int {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+		sg.Incremental = true
+		stats, err := sg.BuildAll(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	if stats := build(); stats["cached"] != 0 || stats[".md"] != 2 {
+		t.Fatalf("first build stats = %v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(site, ".sitegen", "cache.json")); err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	if stats := build(); stats["cached"] != 3 || stats[".md"] != 0 {
+		t.Fatalf("unchanged rebuild stats = %v", stats)
+	}
+
+	// Editing one source only rebuilds that source.
+	writeFiles(t, site, map[string]string{"src/a.md": "---\ntemplate: main.html\n---\n# A2"})
+	if stats := build(); stats["cached"] != 2 || stats[".md"] != 1 {
+		t.Fatalf("after edit stats = %v", stats)
+	}
+	if b, _ := os.ReadFile(filepath.Join(pub, "a", "index.html")); !strings.Contains(string(b), "A2") {
+		t.Errorf("edited page not rebuilt:\n%s", b)
+	}
+
+	// Editing a data file rebuilds the page that read it.
+	writeFiles(t, site, map[string]string{"data/site.json": `{"name":"Two"}`})
+	build()
+	if b, _ := os.ReadFile(filepath.Join(pub, "c", "index.html")); !strings.Contains(string(b), "Two") {
+		t.Errorf("data dependent page not rebuilt:\n%s", b)
+	}
+
+	// A deleted output is regenerated.
+	os.Remove(filepath.Join(pub, "b", "index.html"))
+	build()
+	if _, err := os.Stat(filepath.Join(pub, "b", "index.html")); err != nil {
+		t.Errorf("missing output not rebuilt: %v", err)
+	}
+
+	// Removing a source removes its output.
+	os.Remove(filepath.Join(site, "src", "b.md"))
+	build()
+	if _, err := os.Stat(filepath.Join(pub, "b", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("output of deleted source still present: %v", err)
+	}
+}
+
+func TestBuildAllIncrementalBuildTime(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/footer.html": `{{define "footer"}}(c) {{.Year}}{{end}}`,
+		"src/year.html":         `{{template "footer" .}}`,
+		"src/now.html":          `{{(now).Year}}`,
+		"src/plain.html":        `plain`,
+	})
+	pub := t.TempDir()
+
+	build := func() map[string]int {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+		sg.Incremental = true
+		stats, err := sg.BuildAll(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+	build()
+	// Pages calling now are always rebuilt, pages reading .Year only once it
+	// changed.
+	if stats := build(); stats["cached"] != 2 || stats[".html"] != 1 {
+		t.Fatalf("rebuild stats = %v", stats)
+	}
+	// Stand in for last year's build: the manifest recorded another year.
+	manifest := filepath.Join(site, cacheDir, "cache.json")
+	b, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	year := strconv.Itoa(time.Now().Year())
+	last := strconv.Itoa(time.Now().Year() - 1)
+	os.WriteFile(manifest, bytes.ReplaceAll(b, []byte(`"Year": "`+year+`"`), []byte(`"Year": "`+last+`"`)), 0644)
+	for _, p := range []string{"year", "now"} {
+		os.WriteFile(filepath.Join(pub, p, "index.html"), []byte("stale"), 0644)
+	}
+	if stats := build(); stats["cached"] != 1 || stats[".html"] != 2 {
+		t.Fatalf("rebuild stats = %v", stats)
+	}
+	for _, p := range []string{"year", "now"} {
+		if b, _ := os.ReadFile(filepath.Join(pub, p, "index.html")); !strings.Contains(string(b), year) {
+			t.Errorf("%s not rebuilt:\n%s", p, b)
+		}
+	}
+}
+
+func TestBuildAllIncrementalBuildID(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/head.html": `{{define "head"}}?v={{.BuildID}}{{end}}`,
+		"src/index.html":      `{{template "head" .}}`,
+		"src/about.html":      `about`,
+	})
+	pub := t.TempDir()
+	build := func() (map[string]int, string) {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+		sg.Incremental = true
+		stats, err := sg.BuildAll(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(filepath.Join(pub, "index.html"))
+		return stats, string(b)
+	}
+	_, first := build()
+	// The build ID only changes with the inputs, so cache busting with it
+	// does not defeat the cache.
+	if stats, got := build(); stats["cached"] != 2 || got != first {
+		t.Fatalf("unchanged rebuild stats = %v, index = %q, was %q", stats, got, first)
+	}
+	writeFiles(t, site, map[string]string{"src/about.html": "about us"})
+	stats, got := build()
+	if stats["cached"] != 0 || got == first || !strings.HasPrefix(got, "?v=") {
+		t.Fatalf("after edit stats = %v, index = %q, was %q", stats, got, first)
+	}
+}
+
+func TestBuildAllIncrementalUncacheable(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"src/post.html": "---\ntitle: Post\n---\n{{.Meta.title}}",
+	})
+	pub := t.TempDir()
+	build := func() {
+		t.Helper()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub})
+		sg.Incremental = true
+		if _, err := sg.BuildAll(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build()
+	// A source that stops being cacheable keeps the output it just wrote.
+	writeFiles(t, site, map[string]string{"src/post.html": "---\ntitle: Post\nbuild: echo hi\n---\n{{.Meta.title}}"})
+	for i := 0; i < 2; i++ {
+		build()
+		if b, err := os.ReadFile(filepath.Join(pub, "post", "index.html")); err != nil || strings.TrimSpace(string(b)) != "Post" {
+			t.Fatalf("build %d: output = %q, %v", i, b, err)
+		}
+	}
+}
+
+func TestBuildAllIncrementalConfig(t *testing.T) {
+	site := t.TempDir()
+	pub := t.TempDir()
+	os.MkdirAll(filepath.Join(site, "src"), 0755)
+	if err := os.WriteFile(filepath.Join(site, "src", "a.html"), []byte(`{{.Site.Title}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	build := func(opts Options) map[string]int {
+		t.Helper()
+		opts.SitePath, opts.PublicPath, opts.Incremental = site, pub, true
+		stats, err := NewSiteGen(opts).BuildAll(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	build(Options{Site: Site{Title: "One"}})
+	if stats := build(Options{Site: Site{Title: "One"}}); stats["cached"] != 1 {
+		t.Fatalf("unchanged rebuild stats = %v", stats)
+	}
+	// Any change to the site config rebuilds everything.
+	if stats := build(Options{Site: Site{Title: "Two"}}); stats["cached"] != 0 {
+		t.Fatalf("after title change stats = %v", stats)
+	}
+	if b, _ := os.ReadFile(filepath.Join(pub, "a", "index.html")); string(b) != "Two" {
+		t.Errorf("page not rebuilt: %q", b)
+	}
+	if stats := build(Options{Site: Site{Title: "Two"}, Drafts: true}); stats["cached"] != 0 {
+		t.Fatalf("after drafts change stats = %v", stats)
+	}
+	if stats := build(Options{Site: Site{Title: "Two"}, Drafts: true, TextTemplates: true}); stats["cached"] != 0 {
+		t.Fatalf("after textTemplates change stats = %v", stats)
+	}
+}
+
+func TestBuildAffectedTracksTemplatesAndData(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/main.html":   `<html>{{template "nav" .}}{{template "content" .}}</html>`,
+		"templates/nav.html":    `{{define "nav"}}<nav>{{(data "links.json").home}}</nav>{{end}}`,
+		"templates/bare.html":   `<html>{{template "content" .}}</html>`,
+		"templates/unused.html": `{{define "unused"}}x{{end}}`,
+		"data/links.json":       `{"home":"Home"}`,
+		"data/other.json":       `{}`,
+		"src/a.html":            "---\ntemplate: main.html\n---\n" + `{{define "content"}}A{{end}}`,
+		"src/b.html":            "---\ntemplate: bare.html\n---\n" + `{{define "content"}}B{{end}}`,
+	})
+	pub := t.TempDir()
+
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Dev: true})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want int
+	}{
+		{"templates/nav.html", 1},
+		{"templates/main.html", 1},
+		{"templates/bare.html", 1},
+		{"templates/unused.html", 0},
+		{"data/links.json", 1},
+		{"data/other.json", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			n, err := sg.BuildAffected(filepath.Join(site, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("rebuilt %d sources, want %d", n, tt.want)
+			}
+		})
+	}
+
+	writeFiles(t, site, map[string]string{"templates/nav.html": `{{define "nav"}}<nav>Changed</nav>{{end}}`})
+	if _, err := sg.BuildAffected(filepath.Join(site, "templates", "nav.html")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(pub, "a", "index.html")); !strings.Contains(string(b), "Changed") {
+		t.Errorf("dependent page not rebuilt:\n%s", b)
+	}
+}
@@ -1,6 +1,7 @@
 package sitegen
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,17 +24,114 @@ type Source struct {
 	TotalPages  int
 	path        string
 	Err         error
+	// lineOffset is the number of lines before content in the file (the
+	// frontmatter), to report positions in content as file lines.
+	lineOffset int
+
+	// Lang is the page's language (see lang.go); transKey links its
+	// translations and langSuffix records an about.<lang>.md style name.
+	Lang       string
+	transKey   string
+	langSuffix bool
 
 	// dynamic is set during render when this source aggregates other content
-	// via the sources/data template funcs (i.e. a listing page). The watcher
+	// via the sources template func (i.e. a listing page). The watcher
 	// rebuilds such pages when any content changes, so e.g. adding a blog post
 	// updates the blog index without a full reload.
 	dynamic bool
+	// volatile is set during render when the output calls the now func, so
+	// the build manifest never counts it as fresh.
+	volatile bool
+	// buildValues are the build-time fields (.BuildID, .Today, .Year) the
+	// render read, with the values it read. The build manifest rebuilds the
+	// source once any of them changes.
+	buildValues map[string]string
+
+	// deps are the template files (reached via template: frontmatter and
+	// {{template}} calls) and data files the last render read, and outputs the
+	// public files it wrote. They drive BuildAffected and the build manifest.
+	deps    map[string]bool
+	outputs []string
+	// failed records whether the last Build returned an error, and buildErr
+	// the located error.
+	failed   bool
+	buildErr *BuildError
+
+	// virtual sources are generated by the engine (taxonomy pages, feeds)
+	// and have no file on disk: content and Meta are preset and hash stands in
+	// for the file hash in the build manifest.
+	virtual  bool
+	hash     string
+	taxonomy *Taxonomy
+	term     *Term
+	feed     *feed
+	// redirect is the redirect a meta-refresh page is written for, and
+	// serverFile the serverFiles format a server config is written in.
+	redirect   *Redirect
+	serverFile string
+	// item is the data item a page generated from data renders (see
+	// datapages.go), pageOf the source it was generated from, and pagesErr
+	// why a pages: source generated nothing.
+	item     interface{}
+	pageOf   *Source
+	pagesErr error
+	// render replaces the template parsers for generated outputs (feeds,
+	// the highlight stylesheet).
+	render Parser
+
+	// info caches the table of contents, word count and summary derived
+	// from content (see content.go).
+	info *pageInfo
+	// placeholders holds the outputs of the shortcodes and render hooks of
+	// the current markdown render, put in once the page template executed.
+	placeholders *placeholders
+
+	// gen queues the extra pages (pagination, the page func) spawned while
+	// this source renders. Build owns it for the duration of one render.
+	gen *genQueue
+}
+
+// genQueue is the per-render queue of generated child pages. It is only ever
+// touched by the goroutine building the parent source.
+type genQueue struct {
+	sources []*Source
+}
+
+func (q *genQueue) push(s *Source) {
+	q.sources = append(q.sources, s)
+}
+
+func (q *genQueue) pop() *Source {
+	if len(q.sources) == 0 {
+		return nil
+	}
+	s := q.sources[0]
+	q.sources = q.sources[1:]
+	return s
+}
+
+// find returns the queued page generated for path, if any.
+func (q *genQueue) find(path string) *Source {
+	for _, s := range q.sources {
+		if s.path == path {
+			return s
+		}
+	}
+	return nil
 }
 
 func (s *Source) ReloadContent() []byte {
+	if s.virtual {
+		s.CurrentPage = 0
+		s.TotalPages = 0
+		return s.content
+	}
 	s.content = nil
 	s.Err = nil
+	// The frontmatter may name another translationKey.
+	if s.sg != nil {
+		s.sg.forgetTranslations()
+	}
 	return s.LoadContent()
 }
 
@@ -53,6 +151,7 @@ func (s *Source) LoadContent() []byte {
 		_, txtCtype := parseCtype[s.Ctype]
 		if txtCtype {
 			meta, content = ParseContent(c, "---")
+			s.lineOffset = bytes.Count(meta, []byte("\n"))
 		} else {
 			content = c
 		}
@@ -68,8 +167,11 @@ func (s *Source) LoadContent() []byte {
 			}
 		}
 		s.content = content
+		s.info = &pageInfo{}
+		// Path is only set here: BuildAll loads every source before its
+		// workers start, and other sources' renders read it concurrently.
+		s.Path = s.sg.LocalToPath(s)
 	}
-	s.Path = s.sg.LocalToPath(s)
 	return s.content
 }
 
@@ -108,6 +210,8 @@ func (s *Source) Value(prop string) string {
 		}
 	case "Ext":
 		val = s.Ext
+	case "Lang":
+		val = s.Lang
 	default:
 		if strings.HasPrefix(prop, "Meta.") {
 			val = fmt.Sprint(s.Meta[prop[5:]])
@@ -116,6 +220,14 @@ func (s *Source) Value(prop string) string {
 	return val
 }
 
+// position formats the file and line of a byte offset in content.
+func (s *Source) position(offset int) string {
+	if offset > len(s.content) {
+		offset = len(s.content)
+	}
+	return fmt.Sprintf("%s:%d", s.Local, s.lineOffset+1+bytes.Count(s.content[:offset], []byte("\n")))
+}
+
 func ParseContent(content []byte, sep string) ([]byte, []byte) {
 	c := string(content)
 	cc := c
@@ -0,0 +1,277 @@
+package sitegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// taxonomy.go groups sources by list-valued frontmatter (tags, categories,
+// authors, ...) configured under "taxonomies" in sitegen.yaml. For every term
+// the engine registers a virtual source rendered through the taxonomy's term
+// template, plus one terms index page, so they build, paginate and appear in
+// sources/sitemaps exactly like pages backed by a file.
+
+// TaxonomyConfig configures one taxonomy. The map key in sitegen.yaml is the
+// frontmatter key terms are collected from.
+type TaxonomyConfig struct {
+	// Template renders each term page (default "term.html").
+	Template string `yaml:"template" json:"template"`
+	// Index renders the list of all terms (default "terms.html").
+	Index string `yaml:"index" json:"index"`
+	// Path is the URL prefix for the index and term pages (default the
+	// taxonomy name).
+	Path string `yaml:"path" json:"path"`
+}
+
+// Taxonomy is the collected set of terms for one frontmatter key.
+type Taxonomy struct {
+	Name  string
+	Path  string
+	Terms []*Term
+}
+
+// Term is one value of a taxonomy and the sources tagged with it, newest
+// first by Meta.date.
+type Term struct {
+	Name     string
+	Slug     string
+	Path     string
+	Taxonomy string
+	Sources  []*Source
+}
+
+// Count is the number of sources tagged with the term.
+func (t *Term) Count() int {
+	return len(t.Sources)
+}
+
+// Terms returns the taxonomy terms this source is tagged with, keyed by
+// taxonomy name (e.g. {{range .Terms.tags}}).
+func (s *Source) Terms() map[string][]*Term {
+	out := map[string][]*Term{}
+	if s.sg == nil {
+		return out
+	}
+	for name, tax := range s.sg.taxonomies {
+		for _, v := range metaList(s.Meta[name]) {
+			slug := slugify(v)
+			for _, t := range tax.Terms {
+				if t.Slug == slug {
+					out[name] = append(out[name], t)
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Taxonomy returns the terms of the named taxonomy sorted by name, or nil if
+// it isn't configured. Exposed to templates as taxonomy "tags".
+func (sg *SiteGen) Taxonomy(name string) []*Term {
+	if tax, ok := sg.taxonomies[name]; ok {
+		return tax.Terms
+	}
+	return nil
+}
+
+// metaList flattens a frontmatter value into its string items: a YAML list
+// yields each element, a scalar yields itself.
+func metaList(v interface{}) []string {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			if s := strings.TrimSpace(fmt.Sprint(e)); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	case []string:
+		return v
+	default:
+		if s := strings.TrimSpace(fmt.Sprint(v)); s != "" {
+			return []string{s}
+		}
+		return nil
+	}
+}
+
+// slugify lowercases s and collapses every run of characters other than
+// letters and digits into a single hyphen.
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// syncTaxonomies recollects every configured taxonomy from the current
+// sources and replaces the virtual term/index sources to match. Pages of
+// terms that disappeared are removed from the public dir. The caller must
+// hold sg.Mu and no build may be running.
+func (sg *SiteGen) syncTaxonomies() {
+	old := map[string]*Source{}
+	for k, s := range sg.sources {
+		if s.taxonomy != nil {
+			old[k] = s
+			delete(sg.sources, k)
+		}
+	}
+	sg.taxonomies = map[string]*Taxonomy{}
+
+	// Walk sources in a fixed order so a term spelled differently across
+	// pages (Go, go) is always named after the same one.
+	var keys []string
+	for k, s := range sg.sources {
+		if !s.virtual && sg.Published(s) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	// Public files real sources write, which generated pages must not replace.
+	taken := map[string]bool{}
+	for _, s := range sg.sources {
+		if sg.Published(s) {
+			taken[sg.sourcePath(s)] = true
+		}
+	}
+
+	names := make([]string, 0, len(sg.TaxonomyConfig))
+	for name := range sg.TaxonomyConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := sg.TaxonomyConfig[name]
+		if cfg.Path == "" {
+			cfg.Path = name
+		}
+		cfg.Path = strings.Trim(cfg.Path, "/")
+		if cfg.Path == "" {
+			log.Println("taxonomy", name, "path must not be the site root")
+			continue
+		}
+		if cfg.Template == "" {
+			cfg.Template = "term.html"
+		}
+		if cfg.Index == "" {
+			cfg.Index = "terms.html"
+		}
+		tax := &Taxonomy{Name: name, Path: sg.Path(cfg.Path)}
+		bySlug := map[string]*Term{}
+		for _, k := range keys {
+			s := sg.sources[k]
+			seen := map[string]bool{}
+			for _, v := range metaList(s.Meta[name]) {
+				slug := slugify(v)
+				if slug == "" || seen[slug] {
+					continue
+				}
+				seen[slug] = true
+				t, ok := bySlug[slug]
+				if !ok {
+					t = &Term{Name: v, Slug: slug, Path: sg.Path(cfg.Path + "/" + slug), Taxonomy: name}
+					bySlug[slug] = t
+					tax.Terms = append(tax.Terms, t)
+				}
+				t.Sources = append(t.Sources, s)
+			}
+		}
+		sort.Slice(tax.Terms, func(i, j int) bool { return tax.Terms[i].Slug < tax.Terms[j].Slug })
+		for _, t := range tax.Terms {
+			sort.Slice(t.Sources, func(i, j int) bool {
+				di, dj := t.Sources[i].Value("Meta.date"), t.Sources[j].Value("Meta.date")
+				if di != dj {
+					return di > dj
+				}
+				return t.Sources[i].Path < t.Sources[j].Path
+			})
+		}
+		sg.taxonomies[name] = tax
+
+		if sg.hasTemplate(cfg.Template) {
+			for _, t := range tax.Terms {
+				sg.addTaxonomyPage(tax, t, cfg.Path+"/"+t.Slug, cfg.Template, t.Name, taken)
+			}
+		} else if len(tax.Terms) > 0 {
+			log.Println("taxonomy", name, "term template not found:", cfg.Template)
+		}
+		if sg.hasTemplate(cfg.Index) {
+			sg.addTaxonomyPage(tax, nil, cfg.Path, cfg.Index, name, taken)
+		}
+	}
+
+	// Pages of terms no longer used take what they wrote with them.
+	for k, s := range old {
+		if _, ok := sg.sources[k]; !ok {
+			for _, o := range s.outputs {
+				removeOutput(o)
+			}
+		}
+	}
+}
+
+func (sg *SiteGen) hasTemplate(name string) bool {
+	fi, err := os.Stat(filepath.Join(sg.SitePath, sg.TemplateDir, name))
+	return err == nil && !fi.IsDir()
+}
+
+// addTaxonomyPage registers a virtual source at path rendered with tpl. A real
+// source writing the same public file (taken), such as src/tags.md or
+// src/tags/index.html for the tags index, takes precedence.
+func (sg *SiteGen) addTaxonomyPage(tax *Taxonomy, term *Term, path, tpl, title string, taken map[string]bool) {
+	local := filepath.Join(sg.SitePath, sg.SourceDir, filepath.FromSlash(path)+".html")
+	if _, ok := sg.sources[local]; ok {
+		return
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", tax.Name, path, tpl)
+	if term != nil {
+		for _, s := range term.Sources {
+			fmt.Fprintln(h, s.Local)
+		}
+	} else {
+		for _, t := range tax.Terms {
+			fmt.Fprintln(h, t.Slug, t.Count())
+		}
+	}
+	s := &Source{
+		Name:     filepath.Base(local),
+		Local:    local,
+		Ext:      ".html",
+		Ctype:    "text/html",
+		Meta:     map[string]interface{}{"template": tpl, "title": title, "path": path},
+		content:  []byte{},
+		sg:       sg,
+		dynamic:  true,
+		virtual:  true,
+		hash:     hex.EncodeToString(h.Sum(nil)),
+		taxonomy: tax,
+		term:     term,
+	}
+	s.Path = sg.LocalToPath(s)
+	if taken[sg.sourcePath(s)] {
+		return
+	}
+	sg.sources[local] = s
+}
@@ -0,0 +1,139 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTaxonomySite(t *testing.T) (*SiteGen, string) {
+	t.Helper()
+	site := writeSite(t, map[string]string{
+		"templates/main.html":  `<html>{{template "content" .}}</html>`,
+		"templates/term.html":  `<h1>{{.Term.Name}}</h1>{{range paginate 1 .Term.Sources}}[{{.Meta.title}}]{{end}}`,
+		"templates/terms.html": `{{range .Taxonomy.Terms}}<a href="{{.Path}}">{{.Name}} ({{.Count}})</a>{{end}}`,
+		"src/a.md": "---\ntitle: A\ndate: 2026-01-01\ntags: [Go, Web Dev]\ntemplate: main.html\n---\n" +
+			`{{range .Terms.tags}}<{{.Slug}}>{{end}}`,
+		"src/b.md":     "---\ntitle: B\ndate: 2026-01-02\ntags:\n  - go\ntemplate: main.html\n---\nB",
+		"src/all.html": `{{range taxonomy "tags"}}{{.Name}}={{.Count}};{{end}}`,
+	})
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, Taxonomies: map[string]TaxonomyConfig{
+		"tags": {},
+	}})
+	return sg, pub
+}
+
+func TestTaxonomyPages(t *testing.T) {
+	sg, pub := newTaxonomySite(t)
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	read := func(rel string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(pub, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Term pages are paginated newest first; "Go" and "go" are one term.
+	if got := read("tags/go/index.html"); !strings.Contains(got, "<h1>Go</h1>") || !strings.Contains(got, "[B]") {
+		t.Errorf("term page 1 = %q", got)
+	}
+	if got := read("tags/go/2/index.html"); !strings.Contains(got, "[A]") {
+		t.Errorf("term page 2 = %q", got)
+	}
+	if got := read("tags/web-dev/index.html"); !strings.Contains(got, "[A]") {
+		t.Errorf("web-dev term page = %q", got)
+	}
+	if got := read("tags/index.html"); !strings.Contains(got, `<a href="/tags/go">Go (2)</a>`) ||
+		!strings.Contains(got, `<a href="/tags/web-dev">Web Dev (1)</a>`) {
+		t.Errorf("terms index = %q", got)
+	}
+	if got := read("a/index.html"); !strings.Contains(got, "&lt;go&gt;") && !strings.Contains(got, "<go><web-dev>") {
+		t.Errorf(".Terms on page = %q", got)
+	}
+	if got := read("all/index.html"); got != "Go=2;Web Dev=1;" {
+		t.Errorf("taxonomy func = %q", got)
+	}
+
+	// Term pages are ordinary sources to listings and sitemaps.
+	if n := len(sg.GetSources("RelPath", "tags/*")); n != 2 {
+		t.Errorf("GetSources found %d term pages, want 2", n)
+	}
+}
+
+func TestTaxonomyRemovesStaleTerms(t *testing.T) {
+	sg, pub := newTaxonomySite(t)
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	// Only what the term page wrote goes, not other files next to it.
+	other := filepath.Join(pub, "tags", "web-dev", "feed.xml")
+	if err := os.WriteFile(other, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, sg.SitePath, map[string]string{"src/a.md": "---\ntitle: A\ntags: [go]\ntemplate: main.html\n---\nA"})
+	if _, err := sg.BuildAll(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(pub, "tags", "web-dev", "index.html")); !os.IsNotExist(err) {
+		t.Errorf("stale term page still present: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("file the term page did not write was removed: %v", err)
+	}
+}
+
+func TestTaxonomyKeepsRealPages(t *testing.T) {
+	old, pub := newTaxonomySite(t)
+	writeFiles(t, old.SitePath, map[string]string{
+		"src/tags.md":                 "---\ntemplate: main.html\n---\nmy tags",
+		"src/tags/web-dev/index.html": "my web dev",
+		"src/c.md":                    "---\ntitle: C\ntags: [Rust, rust]\ntemplate: main.html\n---\nC",
+	})
+	sg := NewSiteGen(Options{SitePath: old.SitePath, PublicPath: pub, Taxonomies: old.TaxonomyConfig})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	for rel, want := range map[string]string{
+		"tags/index.html":         "my tags",
+		"tags/web-dev/index.html": "my web dev",
+	} {
+		if b, _ := os.ReadFile(filepath.Join(pub, rel)); !strings.Contains(string(b), want) {
+			t.Errorf("%s = %q, want the hand-written page", rel, b)
+		}
+	}
+	// A page listing one term twice counts once.
+	if n := sg.Taxonomy("tags")[1].Count(); n != 1 {
+		t.Errorf("rust count = %d, want 1", n)
+	}
+}
+
+func TestGetSourcesMatchesListItems(t *testing.T) {
+	sg, _ := newTaxonomySite(t)
+	if n := len(sg.GetSources("Meta.tags", "go")); n != 1 {
+		t.Errorf("Meta.tags go matched %d sources, want 1", n)
+	}
+	if n := len(sg.GetSources("Meta.tags", "[Gg]o")); n != 2 {
+		t.Errorf("Meta.tags [Gg]o matched %d sources, want 2", n)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Go":          "go",
+		"Web Dev":     "web-dev",
+		"  C++ / C# ": "c-c",
+		"Größe":       "größe",
+		"日本語":         "日本語",
+	}
+	for in, want := range tests {
+		if got := slugify(in); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -2,19 +2,166 @@ package sitegen
 
 import (
 	"fmt"
+	htmltemplate "html/template"
+	"io"
 	"path/filepath"
+	"reflect"
 	texttemplate "text/template"
+	"text/template/parse"
 )
 
+// Template is a template set of either template package. HTML sources and
+// templates use html/template, which escapes what they print for the
+// context it appears in, unless the site sets textTemplates; everything
+// else, such as parse: text outputs, uses text/template.
+type Template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// newTemplate starts an empty set, escaping HTML when html is set.
+func newTemplate(name string, html bool) *Template {
+	if html {
+		return &Template{html: htmltemplate.New(name)}
+	}
+	return &Template{text: texttemplate.New(name)}
+}
+
+// Name returns the name of the template.
+func (t *Template) Name() string {
+	if t.html != nil {
+		return t.html.Name()
+	}
+	return t.text.Name()
+}
+
+// Lookup returns the template of the set with the given name, nil if none.
+func (t *Template) Lookup(name string) *Template {
+	if t.html != nil {
+		if l := t.html.Lookup(name); l != nil {
+			return &Template{html: l}
+		}
+		return nil
+	}
+	if l := t.text.Lookup(name); l != nil {
+		return &Template{text: l}
+	}
+	return nil
+}
+
+// New adds an empty template of the given name to the set.
+func (t *Template) New(name string) *Template {
+	if t.html != nil {
+		return &Template{html: t.html.New(name)}
+	}
+	return &Template{text: t.text.New(name)}
+}
+
+// Funcs adds funcs to the set and returns t.
+func (t *Template) Funcs(funcs map[string]interface{}) *Template {
+	if t.html != nil {
+		t.html.Funcs(funcs)
+	} else {
+		t.text.Funcs(funcs)
+	}
+	return t
+}
+
+// Parse parses text as the body of t.
+func (t *Template) Parse(text string) (*Template, error) {
+	if t.html != nil {
+		p, err := t.html.Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return &Template{html: p}, nil
+	}
+	p, err := t.text.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{text: p}, nil
+}
+
+// ParseFiles parses the files into the set, each as a template named after
+// the file's base name.
+func (t *Template) ParseFiles(files ...string) (*Template, error) {
+	if t.html != nil {
+		p, err := t.html.ParseFiles(files...)
+		if err != nil {
+			return nil, err
+		}
+		return &Template{html: p}, nil
+	}
+	p, err := t.text.ParseFiles(files...)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{text: p}, nil
+}
+
+// Clone copies the set. An html/template set can no longer be cloned, nor
+// parsed into, once one of its templates has executed.
+func (t *Template) Clone() (*Template, error) {
+	if t.html != nil {
+		c, err := t.html.Clone()
+		if err != nil {
+			return nil, err
+		}
+		return &Template{html: c}, nil
+	}
+	c, err := t.text.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &Template{text: c}, nil
+}
+
+// Execute applies the template to data.
+func (t *Template) Execute(w io.Writer, data interface{}) error {
+	if t.html != nil {
+		return t.html.Execute(w, data)
+	}
+	return t.text.Execute(w, data)
+}
+
+// tree is the parse tree of the template, nil before it is parsed.
+func (t *Template) tree() *parse.Tree {
+	if t.html != nil {
+		return t.html.Tree
+	}
+	return t.text.Tree
+}
+
+// hasBody reports whether t has text of its own besides the templates it
+// defines.
+func (t *Template) hasBody() bool {
+	tree := t.tree()
+	return tree != nil && !parse.IsEmptyTree(tree.Root)
+}
+
+// escapesHTML reports whether templates of type t render through
+// html/template.
+func (sg *SiteGen) escapesHTML(t string) bool {
+	return t == "html" && !sg.TextTemplates
+}
+
 // LoadTemplate parses all templates of a specific type (ext) and stores them in cache
 func (sg *SiteGen) LoadTemplate(t string) error {
+	sg.tplMu.Lock()
+	defer sg.tplMu.Unlock()
+	return sg.loadTemplate(t)
+}
+
+// loadTemplate is LoadTemplate for callers already holding tplMu.
+func (sg *SiteGen) loadTemplate(t string) error {
 	// Dummy funcs to allow parsing
 	// We need to provide all funcs that might be used in base templates
 	funcs := sg.tplFuncs()
 	funcs["page"] = func(source, path string) string { return "" }
 	funcs["paginate"] = func(limit int, list interface{}) interface{} { return list }
 
-	tpl := texttemplate.New("base").Funcs(funcs)
+	tpl := newTemplate("base", sg.escapesHTML(t)).Funcs(funcs)
 
 	tplFiles, err := filepath.Glob(filepath.Join(sg.SitePath, sg.TemplateDir, "*."+t))
 	if err != nil {
@@ -28,5 +175,157 @@ func (sg *SiteGen) LoadTemplate(t string) error {
 	}
 
 	sg.TplCache[t] = tpl
+	sg.tplFiles[t] = tplFiles
 	return nil
 }
+
+// cachedTemplate returns a private clone of the cached base templates for type
+// t with funcs bound, loading the cache on first use, plus the template files
+// it was parsed from. Each render gets its own clone, so concurrent BuildAll
+// workers never share a template set.
+func (sg *SiteGen) cachedTemplate(t string, funcs map[string]interface{}) (*Template, []string, error) {
+	sg.tplMu.Lock()
+	defer sg.tplMu.Unlock()
+	cached, ok := sg.TplCache[t]
+	if !ok {
+		if err := sg.loadTemplate(t); err != nil {
+			return nil, nil, err
+		}
+		cached = sg.TplCache[t]
+	}
+	tpl, err := cached.Clone()
+	if err != nil {
+		return nil, nil, fmt.Errorf("template clone error: %w", err)
+	}
+	return tpl.Funcs(funcs), sg.tplFiles[t], nil
+}
+
+// templateDeps returns the template files a render of target can reach: the
+// file target itself came from plus, transitively, the file defining every
+// {{template "name"}} it calls. Go templates only accept constant names, so
+// this static walk is exact.
+func templateDeps(target *Template, files []string) []string {
+	byBase := make(map[string]string, len(files))
+	for _, f := range files {
+		byBase[filepath.Base(f)] = f
+	}
+	var deps []string
+	seenFile := map[string]bool{}
+	for _, t := range reachableTemplates(target) {
+		if f, ok := byBase[t.tree().ParseName]; ok && !seenFile[f] {
+			seenFile[f] = true
+			deps = append(deps, f)
+		}
+	}
+	return deps
+}
+
+// reachableTemplates returns target and, transitively, every template it
+// calls with {{template "name"}}.
+func reachableTemplates(target *Template) []*Template {
+	var out []*Template
+	seen := map[string]bool{}
+	var visit func(t *Template)
+	visit = func(t *Template) {
+		if t == nil || t.tree() == nil || seen[t.Name()] {
+			return
+		}
+		seen[t.Name()] = true
+		out = append(out, t)
+		walkTemplateCalls(t.tree().Root, func(name string) {
+			visit(target.Lookup(name))
+		})
+	}
+	visit(target)
+	return out
+}
+
+// walkTemplateCalls calls fn with the name of every {{template}} node below n.
+func walkTemplateCalls(n parse.Node, fn func(string)) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			walkTemplateCalls(c, fn)
+		}
+	case *parse.TemplateNode:
+		fn(n.Name)
+	case *parse.IfNode:
+		walkTemplateCalls(n.List, fn)
+		walkTemplateCalls(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkTemplateCalls(n.List, fn)
+		walkTemplateCalls(n.ElseList, fn)
+	case *parse.WithNode:
+		walkTemplateCalls(n.List, fn)
+		walkTemplateCalls(n.ElseList, fn)
+	}
+}
+
+// buildFields reports the names of the build-time fields a render of target
+// can read (.Year or the $.Year form, say). A field of that name under range
+// or with is counted too, which only costs a rebuild.
+func buildFields(target *Template) []string {
+	found := map[string]bool{}
+	for _, t := range reachableTemplates(target) {
+		walkNodes(t.tree().Root, func(n parse.Node) {
+			switch n := n.(type) {
+			case *parse.FieldNode:
+				found[n.Ident[0]] = true
+			case *parse.VariableNode:
+				if len(n.Ident) > 1 && n.Ident[0] == "$" {
+					found[n.Ident[1]] = true
+				}
+			}
+		})
+	}
+	var names []string
+	for _, name := range []string{"BuildID", "Today", "Year"} {
+		if found[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// walkNodes calls fn with n and every node below it.
+func walkNodes(n parse.Node, fn func(parse.Node)) {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return
+	}
+	fn(n)
+	switch n := n.(type) {
+	case *parse.ListNode:
+		for _, c := range n.Nodes {
+			walkNodes(c, fn)
+		}
+	case *parse.ActionNode:
+		walkNodes(n.Pipe, fn)
+	case *parse.PipeNode:
+		for _, c := range n.Cmds {
+			walkNodes(c, fn)
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			walkNodes(a, fn)
+		}
+	case *parse.ChainNode:
+		walkNodes(n.Node, fn)
+	case *parse.TemplateNode:
+		walkNodes(n.Pipe, fn)
+	case *parse.IfNode:
+		walkNodes(n.Pipe, fn)
+		walkNodes(n.List, fn)
+		walkNodes(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkNodes(n.Pipe, fn)
+		walkNodes(n.List, fn)
+		walkNodes(n.ElseList, fn)
+	case *parse.WithNode:
+		walkNodes(n.Pipe, fn)
+		walkNodes(n.List, fn)
+		walkNodes(n.ElseList, fn)
+	}
+}
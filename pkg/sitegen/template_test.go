@@ -0,0 +1,115 @@
+package sitegen
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTMLEscaping(t *testing.T) {
+	build := func(t *testing.T, textTemplates bool) func(rel string) string {
+		t.Helper()
+		meta := "---\ntitle: \"<script>x</script>\"\nlink: \"javascript:alert(1)\"\nraw: \"<em>ok</em>\"\n"
+		site := writeSite(t, map[string]string{
+			"templates/main.html":                `<h1>{{.title}}</h1><a href="{{.link}}">x</a>{{html .raw}}{{template "content" .}}`,
+			"templates/shortcodes/name.html":     `<b>{{.Source.Meta.title}}</b>`,
+			"templates/_markup/render-link.html": `<a href="{{.Destination}}" title="{{.Title}}">{{.Text}}</a>`,
+			"src/page.md":                        meta + "template: main.html\n---\n{{< name >}} and [a](/b \"<t>\")\n",
+			"src/feed.xml":                       meta + "parse: text\n---\n<t>{{.title}}</t>",
+		})
+		pub := t.TempDir()
+		sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, TextTemplates: textTemplates})
+		if _, err := sg.BuildAll(false); err != nil {
+			t.Fatal(err)
+		}
+		return func(rel string) string {
+			b, err := os.ReadFile(filepath.Join(pub, rel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(b)
+		}
+	}
+
+	read := build(t, false)
+	page := read("page/index.html")
+	for _, want := range []string{
+		"<h1>&lt;script&gt;x&lt;/script&gt;</h1>",
+		`<a href="#ZgotmplZ">`,
+		"<em>ok</em>",
+		"<b>&lt;script&gt;x&lt;/script&gt;</b>",
+		`<a href="/b" title="&lt;t&gt;">a</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("missing %q in:\n%s", want, page)
+		}
+	}
+	if got := strings.TrimSpace(read("feed.xml")); got != "<t><script>x</script></t>" {
+		t.Errorf("parse: text output = %q", got)
+	}
+
+	page = build(t, true)("page/index.html")
+	for _, want := range []string{"<h1><script>x</script></h1>", `<a href="javascript:alert(1)">`, "<b><script>x</script></b>"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("textTemplates: missing %q in:\n%s", want, page)
+		}
+	}
+}
+
+// TestTextTemplatesKeepsOldOutput pins textTemplates: true to what sitegen
+// wrote before HTML sources used html/template, byte for byte.
+func TestTextTemplatesKeepsOldOutput(t *testing.T) {
+	site := writeSite(t, map[string]string{
+		"templates/main.html": `<html><head><title>{{.title}}</title><script>var t = "{{.title}}";</script></head>
+<body><a href="{{.link}}" style="color: {{.color}}">{{.title}}</a>
+{{template "content" .}}
+</body></html>
+`,
+		"src/index.html": `---
+title: "Tom & Jerry <b>live</b>"
+link: "javascript:alert(1)"
+color: "red; background: url(x)"
+template: main.html
+blocks:
+  - type: rich_text
+    body: "<p>Use <code>-share</code> to publish.</p>"
+---
+{{define "content"}}{{range .blocks}}{{ .body }}{{end}}
+<p>{{ .title }}</p>{{end}}
+`,
+		"src/post.md": `---
+title: "A <em>post</em>"
+template: main.html
+---
+Some *text* with [a link](/about) and <span>{{ .title }}</span>.
+`,
+	})
+	pub := t.TempDir()
+	sg := NewSiteGen(Options{SitePath: site, PublicPath: pub, TextTemplates: true})
+	if _, err := sg.BuildAll(false); err != nil {
+		t.Fatal(err)
+	}
+	for rel, want := range map[string]string{
+		"index.html": `<html><head><title>Tom & Jerry <b>live</b></title><script>var t = "Tom & Jerry <b>live</b>";</script></head>
+<body><a href="javascript:alert(1)" style="color: red; background: url(x)">Tom & Jerry <b>live</b></a>
+<p>Use <code>-share</code> to publish.</p>
+<p>Tom & Jerry <b>live</b></p>
+</body></html>
+`,
+		"post/index.html": `<html><head><title>A <em>post</em></title><script>var t = "A <em>post</em>";</script></head>
+<body><a href="<no value>" style="color: <no value>">A <em>post</em></a>
+<p>Some <em>text</em> with <a href="/about">a link</a> and <!-- raw HTML omitted -->A <em>post</em><!-- raw HTML omitted -->.</p>
+
+</body></html>
+`,
+	} {
+		b, err := os.ReadFile(filepath.Join(pub, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s =\n%s\nwant\n%s", rel, b, want)
+		}
+	}
+}